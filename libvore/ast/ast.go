@@ -3,16 +3,25 @@ package ast
 import (
 	"fmt"
 	"io"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Ast struct {
 	commands []AstCommand
+	caseless bool
 }
 
 func (ast *Ast) Commands() []AstCommand {
 	return ast.commands
 }
 
+// Caseless is true when the program starts with 'caseless' and every command ignores case by default
+func (ast *Ast) Caseless() bool {
+	return ast.caseless
+}
+
 func ParseReader(reader io.Reader) (*Ast, error) {
 	lexer := initLexer(reader)
 
@@ -21,15 +30,16 @@ func ParseReader(reader io.Reader) (*Ast, error) {
 		return nil, lexError
 	}
 
-	commands, parseError := parse(tokens)
+	commands, caseless, parseError := parse(tokens)
 	if parseError != nil {
 		return nil, parseError
 	}
-	return &Ast{commands}, nil
+	return &Ast{commands, caseless}, nil
 }
 
 type AstNode interface {
 	NodeString() string
+	Span() Span
 }
 
 type AstCommand interface {
@@ -69,11 +79,14 @@ type AstProcessExpression interface {
 }
 
 type AstFind struct {
-	All  bool
-	Skip int
-	Take int
-	Last int
-	Body []AstExpression
+	spanned
+	All         bool
+	Skip        int
+	Take        int
+	Last        int
+	Overlapping bool
+	Tree        bool
+	Body        []AstExpression
 }
 
 func (f AstFind) isCmd() {}
@@ -83,6 +96,12 @@ func (f AstFind) NodeString() string {
 		result += " all"
 	}
 	result += fmt.Sprintf(" skip %d take %d", f.Skip, f.Take)
+	if f.Overlapping {
+		result += " overlapping"
+	}
+	if f.Tree {
+		result += " tree"
+	}
 	result += " (body"
 	for _, expr := range f.Body {
 		result += fmt.Sprintf(" %s", expr.NodeString())
@@ -92,6 +111,7 @@ func (f AstFind) NodeString() string {
 }
 
 type AstReplace struct {
+	spanned
 	All    bool
 	Skip   int
 	Take   int
@@ -119,6 +139,7 @@ func (r AstReplace) NodeString() string {
 }
 
 type AstSet struct {
+	spanned
 	Id   string
 	Body AstSetBody
 }
@@ -130,10 +151,11 @@ func (s AstSet) NodeString() string {
 
 type AstSetBody interface {
 	// generate(state *GenState, id string) (SetCommandBody, error)
-	NodeString() string
+	AstNode
 }
 
 type AstSetPattern struct {
+	spanned
 	Pattern []AstExpression
 	Body    []AstProcessStatement
 }
@@ -152,6 +174,7 @@ func (b AstSetPattern) NodeString() string {
 }
 
 type AstSetMatches struct {
+	spanned
 	Command AstCommand
 }
 
@@ -160,6 +183,7 @@ func (b AstSetMatches) NodeString() string {
 }
 
 type AstSetTransform struct {
+	spanned
 	Statements []AstProcessStatement
 }
 
@@ -173,29 +197,86 @@ func (b AstSetTransform) NodeString() string {
 }
 
 type AstLoop struct {
-	Min    int
-	Max    int
-	Fewest bool
-	Body   AstExpression
-	Name   string
+	spanned
+	Min        int
+	Max        int
+	Fewest     bool
+	Possessive bool // never gives back an iteration once the loop is done
+	Body       AstExpression
+	Name       string
 }
 
 func (l AstLoop) isExpr() {}
 func (l AstLoop) NodeString() string {
+	if l.Possessive {
+		return fmt.Sprintf("(loop min %d max %d possessive %s)", l.Min, l.Max, l.Body.NodeString())
+	}
 	return fmt.Sprintf("(loop min %d max %d fewest %t %s)", l.Min, l.Max, l.Fewest, l.Body.NodeString())
 }
 
+type AstLookaround struct {
+	spanned
+	Not    bool
+	Behind bool
+	Body   AstExpression
+}
+
+func (l AstLookaround) isExpr() {}
+func (l AstLookaround) NodeString() string {
+	kind := "lookahead"
+	if l.Behind {
+		kind = "lookbehind"
+	}
+	return fmt.Sprintf("(%s not %t %s)", kind, l.Not, l.Body.NodeString())
+}
+
 type AstBranch struct {
+	spanned
 	Left  AstLiteral
 	Right AstExpression
 }
 
+type AstConditional struct {
+	spanned
+	Variable string
+	Then     []AstExpression
+	Else     []AstExpression
+}
+
+func (c AstConditional) isExpr() {}
+func (c AstConditional) NodeString() string {
+	result := fmt.Sprintf("(if '%s' (then", c.Variable)
+	for _, expr := range c.Then {
+		result += fmt.Sprintf(" %s", expr.NodeString())
+	}
+	result += ") (else"
+	for _, expr := range c.Else {
+		result += fmt.Sprintf(" %s", expr.NodeString())
+	}
+	result += "))"
+	return result
+}
+
+type AstFuzzy struct {
+	spanned
+	MaxEdits int
+	Swaps    bool
+	Target   AstAtom
+	Name     string
+}
+
+func (f AstFuzzy) isExpr() {}
+func (f AstFuzzy) NodeString() string {
+	return fmt.Sprintf("(within %d swaps %t %s '%s')", f.MaxEdits, f.Swaps, f.Target.NodeString(), f.Name)
+}
+
 func (b AstBranch) isExpr() {}
 func (b AstBranch) NodeString() string {
 	return fmt.Sprintf("(branch %s %s)", b.Left.NodeString(), b.Right.NodeString())
 }
 
 type AstDec struct {
+	spanned
 	Name string
 	Body AstLiteral
 }
@@ -206,6 +287,7 @@ func (d AstDec) NodeString() string {
 }
 
 type AstSub struct {
+	spanned
 	Name string
 	Body []AstExpression
 }
@@ -221,10 +303,32 @@ func (d AstSub) NodeString() string {
 }
 
 type AstList struct {
+	spanned
 	Not      bool
 	Contents []AstListable
 }
 
+// AstTermList matches any one of a list of terms that are either written inline or loaded from File when the program is compiled
+type AstTermList struct {
+	spanned
+	Terms     []string
+	File      string
+	Caseless  bool
+	WholeWord bool
+}
+
+func (l AstTermList) isExpr() {}
+func (l AstTermList) NodeString() string {
+	result := fmt.Sprintf("(in list (caseless %t) (whole word %t)", l.Caseless, l.WholeWord)
+	if l.File != "" {
+		result += fmt.Sprintf(" (file '%s')", l.File)
+	}
+	for _, term := range l.Terms {
+		result += fmt.Sprintf(" '%s'", term)
+	}
+	return result + ")"
+}
+
 func (l AstList) isExpr() {}
 func (l AstList) GetMaxSize() int {
 	max := -1
@@ -247,6 +351,7 @@ func (l AstList) NodeString() string {
 }
 
 type AstPrimary struct {
+	spanned
 	Literal AstLiteral
 }
 
@@ -255,7 +360,37 @@ func (s AstPrimary) NodeString() string {
 	return fmt.Sprintf("(primary %s)", s.Literal.NodeString())
 }
 
+// AstNumberRange matches the written form of a number from Min to Max
+type AstNumberRange struct {
+	spanned
+	Min          int
+	Max          int
+	LeadingZeros bool
+	Signs        bool
+	Decimals     bool
+}
+
+func (n AstNumberRange) isLiteral() {}
+func (n AstNumberRange) GetMaxSize() int {
+	if n.LeadingZeros || n.Decimals {
+		return -1
+	}
+	digits := len(strconv.Itoa(n.Max))
+	if lower := len(strconv.Itoa(n.Min)); lower > digits {
+		digits = lower
+	}
+	// the '-' of a negative bound is already counted in its digits
+	if n.Signs {
+		digits += 1
+	}
+	return digits
+}
+func (n AstNumberRange) NodeString() string {
+	return fmt.Sprintf("(number %d %d (padded %t) (signed %t) (decimal %t))", n.Min, n.Max, n.LeadingZeros, n.Signs, n.Decimals)
+}
+
 type AstRange struct {
+	spanned
 	From *AstString
 	To   *AstString
 }
@@ -263,7 +398,7 @@ type AstRange struct {
 func (r AstRange) isListable() {}
 func (r AstRange) GetMaxSize() int {
 	//? Can we guarantee that "from" is going to be greater than "to"??
-	return len(r.To.Value)
+	return utf8.RuneCountInString(r.To.Value)
 }
 
 func (r AstRange) NodeString() string {
@@ -271,6 +406,7 @@ func (r AstRange) NodeString() string {
 }
 
 type AstString struct {
+	spanned
 	Not      bool
 	Value    string
 	Caseless bool
@@ -279,7 +415,7 @@ type AstString struct {
 func (s AstString) isLiteral()  {}
 func (s AstString) isListable() {}
 func (s AstString) GetMaxSize() int {
-	return len(s.Value)
+	return utf8.RuneCountInString(s.Value)
 }
 func (s AstString) isAtom() {}
 func (s AstString) NodeString() string {
@@ -287,12 +423,21 @@ func (s AstString) NodeString() string {
 }
 
 type AstSubExpr struct {
-	Body []AstExpression
+	spanned
+	Body     []AstExpression
+	Caseless bool
+	Atomic   bool // once the body matches nothing inside of it is tried again
 }
 
 func (n AstSubExpr) isLiteral() {}
 func (n AstSubExpr) NodeString() string {
 	result := "(subexpr"
+	if n.Caseless {
+		result += " caseless"
+	}
+	if n.Atomic {
+		result += " atomic"
+	}
 	for _, expr := range n.Body {
 		result += fmt.Sprintf(" %s", expr.NodeString())
 	}
@@ -301,6 +446,7 @@ func (n AstSubExpr) NodeString() string {
 }
 
 type AstVariable struct {
+	spanned
 	Name string
 }
 
@@ -310,6 +456,58 @@ func (s AstVariable) NodeString() string {
 	return fmt.Sprintf("(var %s)", s.Name)
 }
 
+type AstUnicodeClassType int
+
+const (
+	UnicodeCategory AstUnicodeClassType = iota
+	UnicodeScript
+	UnicodeProperty
+)
+
+func (a AstUnicodeClassType) String() string {
+	switch a {
+	case UnicodeCategory:
+		return "category"
+	case UnicodeScript:
+		return "script"
+	case UnicodeProperty:
+		return "property"
+	}
+	return "MISSING UNICODE CLASS"
+}
+
+// UnicodeTable looks up the range table of a unicode category, script, or property by name
+func UnicodeTable(classType AstUnicodeClassType, name string) (*unicode.RangeTable, bool) {
+	var table *unicode.RangeTable
+	var found bool
+	switch classType {
+	case UnicodeCategory:
+		table, found = unicode.Categories[name]
+	case UnicodeScript:
+		table, found = unicode.Scripts[name]
+	case UnicodeProperty:
+		table, found = unicode.Properties[name]
+	}
+	return table, found
+}
+
+type AstUnicodeClass struct {
+	spanned
+	Not       bool
+	ClassType AstUnicodeClassType
+	Name      string
+}
+
+func (c AstUnicodeClass) isLiteral()  {}
+func (c AstUnicodeClass) isListable() {}
+func (c AstUnicodeClass) GetMaxSize() int {
+	return 1
+}
+
+func (c AstUnicodeClass) NodeString() string {
+	return fmt.Sprintf("(unicode %s '%s')", c.ClassType, c.Name)
+}
+
 type AstCharacterClassType int
 
 const (
@@ -319,6 +517,7 @@ const (
 	ClassUpper
 	ClassLower
 	ClassLetter
+	ClassWord
 	ClassLineStart
 	ClassFileStart
 	ClassWordStart
@@ -344,6 +543,8 @@ func (a AstCharacterClassType) String() string {
 		return "LOWER"
 	case ClassLetter:
 		return "LETTER"
+	case ClassWord:
+		return "WORD"
 	case ClassLineStart:
 		return "LStart"
 	case ClassFileStart:
@@ -367,6 +568,7 @@ func (a AstCharacterClassType) String() string {
 }
 
 type AstCharacterClass struct {
+	spanned
 	Not       bool
 	ClassType AstCharacterClassType
 }
@@ -387,6 +589,8 @@ func (c AstCharacterClass) GetMaxSize() int {
 		return 1
 	case ClassLetter:
 		return 1
+	case ClassWord:
+		return 1
 	case ClassLineStart:
 		return 0
 	case ClassFileStart:
@@ -424,6 +628,8 @@ func (c AstCharacterClass) NodeString() string {
 		result += "lower"
 	case ClassLetter:
 		result += "letter"
+	case ClassWord:
+		result += "word"
 	case ClassLineStart:
 		result += "line start"
 	case ClassFileStart:
@@ -448,6 +654,7 @@ func (c AstCharacterClass) NodeString() string {
 }
 
 type AstProcessSet struct {
+	spanned
 	Name string
 	Expr AstProcessExpression
 }
@@ -458,6 +665,7 @@ func (s AstProcessSet) NodeString() string {
 }
 
 type AstProcessReturn struct {
+	spanned
 	Expr AstProcessExpression
 }
 
@@ -467,6 +675,7 @@ func (s AstProcessReturn) NodeString() string {
 }
 
 type AstProcessIf struct {
+	spanned
 	Condition AstProcessExpression
 	TrueBody  []AstProcessStatement
 	FalseBody []AstProcessStatement
@@ -487,6 +696,7 @@ func (s AstProcessIf) NodeString() string {
 }
 
 type AstProcessDebug struct {
+	spanned
 	Expr AstProcessExpression
 }
 
@@ -496,6 +706,7 @@ func (s AstProcessDebug) NodeString() string {
 }
 
 type AstProcessLoop struct {
+	spanned
 	Body []AstProcessStatement
 }
 
@@ -509,14 +720,18 @@ func (s AstProcessLoop) NodeString() string {
 	return result
 }
 
-type AstProcessContinue struct{}
+type AstProcessContinue struct {
+	spanned
+}
 
 func (s AstProcessContinue) isProcessStatement() {}
 func (s AstProcessContinue) NodeString() string {
 	return "(continue)"
 }
 
-type AstProcessBreak struct{}
+type AstProcessBreak struct {
+	spanned
+}
 
 func (s AstProcessBreak) isProcessStatement() {}
 func (s AstProcessBreak) NodeString() string {
@@ -524,6 +739,7 @@ func (s AstProcessBreak) NodeString() string {
 }
 
 type AstProcessUnaryExpression struct {
+	spanned
 	Op   TokenType
 	Expr AstProcessExpression
 }
@@ -534,6 +750,7 @@ func (e AstProcessUnaryExpression) NodeString() string {
 }
 
 type AstProcessBinaryExpression struct {
+	spanned
 	Op  TokenType
 	Lhs AstProcessExpression
 	Rhs AstProcessExpression
@@ -545,6 +762,7 @@ func (e AstProcessBinaryExpression) NodeString() string {
 }
 
 type AstProcessString struct {
+	spanned
 	Value string
 }
 
@@ -554,6 +772,7 @@ func (e AstProcessString) NodeString() string {
 }
 
 type AstProcessNumber struct {
+	spanned
 	Value int
 }
 
@@ -563,6 +782,7 @@ func (e AstProcessNumber) NodeString() string {
 }
 
 type AstProcessBoolean struct {
+	spanned
 	Value bool
 }
 
@@ -572,6 +792,7 @@ func (e AstProcessBoolean) NodeString() string {
 }
 
 type AstProcessVariable struct {
+	spanned
 	Name string
 }
 
@@ -0,0 +1,14 @@
+package ast
+
+// CompileError is implemented by every error that can come out of compiling a vore program so callers can
+// report where in the source the problem is without knowing which stage of the compiler failed.
+// Use errors.As to get one out of an error.
+type CompileError interface {
+	error
+	// Span is the part of the source the error is about
+	Span() Span
+	// Message is the error without the position information Error adds to it
+	Message() string
+	// Code is the kind of error like "ParseError" or "SemanticError"
+	Code() string
+}
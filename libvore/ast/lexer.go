@@ -62,6 +62,8 @@ const (
 	TAKE
 	TOP
 	LAST
+	OVERLAPPING
+	TREE
 
 	// classes
 	ANY
@@ -89,7 +91,19 @@ const (
 	EXACTLY
 	MAYBE
 	FEWEST
+	POSSESSIVE
+	ATOMIC
 	NAMED
+	WITHIN
+	EDITS
+	SWAPS
+	OF
+	NUMERIC
+	PADDED
+	SIGNED
+	DECIMAL
+	LIST
+	FROM
 	IN
 	OR
 	IF
@@ -104,6 +118,12 @@ const (
 	CONTINUE
 	TRUE
 	FALSE
+	FOLLOWED
+	PRECEDED
+	BY
+	UNICODE
+	CATEGORY
+	SCRIPT
 )
 
 func (t TokenType) PP() string {
@@ -162,6 +182,10 @@ func (t TokenType) PP() string {
 		return "TOP"
 	case LAST:
 		return "LAST"
+	case OVERLAPPING:
+		return "OVERLAPPING"
+	case TREE:
+		return "TREE"
 	case ANY:
 		return "ANY"
 	case WHITESPACE:
@@ -252,14 +276,50 @@ func (t TokenType) PP() string {
 		return "CONTINUE"
 	case BREAK:
 		return "BREAK"
+	case POSSESSIVE:
+		return "POSSESSIVE"
+	case ATOMIC:
+		return "ATOMIC"
 	case NAMED:
 		return "NAMED"
+	case WITHIN:
+		return "WITHIN"
+	case EDITS:
+		return "EDITS"
+	case SWAPS:
+		return "SWAPS"
+	case OF:
+		return "OF"
+	case NUMERIC:
+		return "NUMERIC"
+	case PADDED:
+		return "PADDED"
+	case SIGNED:
+		return "SIGNED"
+	case DECIMAL:
+		return "DECIMAL"
+	case LIST:
+		return "LIST"
+	case FROM:
+		return "FROM"
 	case TRUE:
 		return "TRUE"
 	case FALSE:
 		return "FALSE"
 	case CASELESS:
 		return "CASELESS"
+	case FOLLOWED:
+		return "FOLLOWED"
+	case PRECEDED:
+		return "PRECEDED"
+	case BY:
+		return "BY"
+	case UNICODE:
+		return "UNICODE"
+	case CATEGORY:
+		return "CATEGORY"
+	case SCRIPT:
+		return "SCRIPT"
 	case REGEXP:
 		return "REGEXP"
 	default:
@@ -275,6 +335,46 @@ type Token struct {
 	Lexeme    string
 }
 
+func (token *Token) Span() Span {
+	return Span{
+		Offset: *token.Offset,
+		Line:   *token.Line,
+		Column: *token.Column,
+	}
+}
+
+// contextualKeywords are lexed as identifiers so existing queries can keep using them as variable names.
+// The parser only treats them as keywords in the spot that starts or continues their construct.
+var contextualKeywords = map[string]TokenType{
+	"overlapping": OVERLAPPING,
+	"tree":        TREE,
+	"possessive":  POSSESSIVE,
+	"atomic":      ATOMIC,
+	"within":      WITHIN,
+	"edit":        EDITS,
+	"edits":       EDITS,
+	"swap":        SWAPS,
+	"swaps":       SWAPS,
+	"of":          OF,
+	"number":      NUMERIC,
+	"padded":      PADDED,
+	"signed":      SIGNED,
+	"decimal":     DECIMAL,
+	"list":        LIST,
+	"from":        FROM,
+	"followed":    FOLLOWED,
+	"preceded":    PRECEDED,
+	"by":          BY,
+	"unicode":     UNICODE,
+	"category":    CATEGORY,
+	"script":      SCRIPT,
+}
+
+// isKeyword is true when the token is an identifier spelling the contextual keyword t
+func (token *Token) isKeyword(t TokenType) bool {
+	return token.TokenType == IDENTIFIER && contextualKeywords[strings.ToLower(token.Lexeme)] == t
+}
+
 /*
 func (token Token) print() {
 	fmt.Printf("[%s] '%s' \tline: %d, \tstart column: %d, \tend column: %d\n", token.tokenType.pp(), token.lexeme, token.line.Start, token.column.Start, token.column.End)
@@ -797,7 +897,7 @@ func (s *Lexer) read() rune {
 	posInfo.offset = lastPosition.offset + 1
 	posInfo.column = lastPosition.column + 1
 	posInfo.line = lastPosition.line
-	if s.currentChar == '\n' {
+	if ch == '\n' {
 		posInfo.line += 1
 		posInfo.column = 1
 	}
@@ -154,6 +154,8 @@ func TestTokenTypePP(t *testing.T) {
 	ppMatch(t, TAKE, "TAKE")
 	ppMatch(t, TOP, "TOP")
 	ppMatch(t, LAST, "LAST")
+	ppMatch(t, OVERLAPPING, "OVERLAPPING")
+	ppMatch(t, TREE, "TREE")
 	ppMatch(t, ANY, "ANY")
 	ppMatch(t, WHITESPACE, "WHITESPACE")
 	ppMatch(t, DIGIT, "DIGIT")
@@ -175,7 +177,19 @@ func TestTokenTypePP(t *testing.T) {
 	ppMatch(t, EXACTLY, "EXACTLY")
 	ppMatch(t, MAYBE, "MAYBE")
 	ppMatch(t, FEWEST, "FEWEST")
+	ppMatch(t, POSSESSIVE, "POSSESSIVE")
+	ppMatch(t, ATOMIC, "ATOMIC")
 	ppMatch(t, NAMED, "NAMED")
+	ppMatch(t, WITHIN, "WITHIN")
+	ppMatch(t, EDITS, "EDITS")
+	ppMatch(t, SWAPS, "SWAPS")
+	ppMatch(t, OF, "OF")
+	ppMatch(t, NUMERIC, "NUMERIC")
+	ppMatch(t, PADDED, "PADDED")
+	ppMatch(t, SIGNED, "SIGNED")
+	ppMatch(t, DECIMAL, "DECIMAL")
+	ppMatch(t, LIST, "LIST")
+	ppMatch(t, FROM, "FROM")
 	ppMatch(t, IN, "IN")
 	ppMatch(t, OR, "OR")
 	ppMatch(t, IF, "IF")
@@ -203,5 +217,20 @@ func TestTokenTypePP(t *testing.T) {
 	ppMatch(t, FALSE, "FALSE")
 	ppMatch(t, WHOLE, "WHOLE")
 	ppMatch(t, CASELESS, "CASELESS")
+	ppMatch(t, FOLLOWED, "FOLLOWED")
+	ppMatch(t, PRECEDED, "PRECEDED")
+	ppMatch(t, BY, "BY")
+	ppMatch(t, UNICODE, "UNICODE")
+	ppMatch(t, CATEGORY, "CATEGORY")
+	ppMatch(t, SCRIPT, "SCRIPT")
 	ppMatch(t, REGEXP, "REGEXP")
 }
+
+func TestLexerPositionAfterNewline(t *testing.T) {
+	lexer := initLexer(strings.NewReader("find\n  all 'a'"))
+	actual, err := lexer.getTokens()
+	testutils.CheckNoError(t, err)
+	all := actual[2]
+	testutils.AssertEqual(t, ALL, all.TokenType)
+	testutils.AssertEqual(t, Span{Offset: ds.Range{Start: 7, End: 10}, Line: ds.Range{Start: 2, End: 2}, Column: ds.Range{Start: 3, End: 6}}, all.Span())
+}
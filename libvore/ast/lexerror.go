@@ -19,6 +19,14 @@ func (err *LexError) Message() string {
 	return err.message
 }
 
+func (err *LexError) Span() Span {
+	return err.token.Span()
+}
+
+func (err *LexError) Code() string {
+	return "LexError"
+}
+
 func NewLexError(token *Token, message string) *LexError {
 	return &LexError{token, message}
 }
@@ -19,6 +19,14 @@ func (err *ParseError) Message() string {
 	return err.message
 }
 
+func (err *ParseError) Span() Span {
+	return err.token.Span()
+}
+
+func (err *ParseError) Code() string {
+	return "ParseError"
+}
+
 func NewParseError(token *Token, message string) *ParseError {
 	return &ParseError{token, message}
 }
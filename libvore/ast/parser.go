@@ -1,20 +1,26 @@
 package ast
 
 import (
+	"fmt"
 	"strconv"
+	"strings"
 )
 
 var capture_group_number int = 0
 
-func parse(tokens []*Token) ([]AstCommand, error) {
+func parse(tokens []*Token) ([]AstCommand, bool, error) {
 	commands := []AstCommand{}
 	capture_group_number = 0
-	token_index := 0
+	token_index := consumeIgnoreableTokens(tokens, 0)
+	caseless := tokens[token_index].TokenType == CASELESS
+	if caseless {
+		token_index += 1
+	}
 	for token_index < len(tokens)-1 {
 		ws_index := consumeIgnoreableTokens(tokens, token_index)
 		command, new_index, e := parse_command(tokens, ws_index)
 		if e != nil {
-			return []AstCommand{}, e
+			return []AstCommand{}, false, e
 		}
 		token_index = new_index
 		if command != nil {
@@ -22,7 +28,7 @@ func parse(tokens []*Token) ([]AstCommand, error) {
 		}
 	}
 
-	return commands, nil
+	return commands, caseless, nil
 }
 
 func parse_command(tokens []*Token, token_index int) (AstCommand, int, error) {
@@ -35,6 +41,8 @@ func parse_command(tokens []*Token, token_index int) (AstCommand, int, error) {
 		return parse_set(tokens, token_index)
 	case EOF:
 		return nil, token_index, nil
+	case CASELESS:
+		return nil, token_index, NewParseError(tokens[token_index], "A program can only be made caseless before its first command.")
 	default:
 		return nil, token_index, NewParseError(tokens[token_index], "Unexpected token. Expected 'find', 'replace', or 'set'.")
 	}
@@ -54,6 +62,16 @@ func parse_find(tokens []*Token, token_index int) (*AstFind, int, error) {
 		Body: []AstExpression{},
 	}
 
+	new_index = consumeIgnoreableTokens(tokens, new_index)
+	if tokens[new_index].isKeyword(OVERLAPPING) {
+		findCommand.Overlapping = true
+		new_index = consumeIgnoreableTokens(tokens, new_index+1)
+	}
+	if tokens[new_index].isKeyword(TREE) {
+		findCommand.Tree = true
+		new_index += 1
+	}
+
 	current_token := tokens[new_index]
 	current_index := new_index
 	for current_token.TokenType != FIND && current_token.TokenType != REPLACE && current_token.TokenType != SET && current_token.TokenType != EOF {
@@ -68,7 +86,7 @@ func parse_find(tokens []*Token, token_index int) (*AstFind, int, error) {
 		current_token = tokens[current_index]
 	}
 
-	return &findCommand, current_index, nil
+	return spanning(&findCommand, tokens, token_index, current_index), current_index, nil
 }
 
 func parse_replace(tokens []*Token, token_index int) (*AstReplace, int, error) {
@@ -77,6 +95,14 @@ func parse_replace(tokens []*Token, token_index int) (*AstReplace, int, error) {
 		return nil, new_index, amountError
 	}
 
+	new_index = consumeIgnoreableTokens(tokens, new_index)
+	if tokens[new_index].isKeyword(OVERLAPPING) {
+		return nil, new_index, NewParseError(tokens[new_index], "Replace statements can't use 'overlapping' since overlapping matches can't all be replaced.")
+	}
+	if tokens[new_index].isKeyword(TREE) {
+		return nil, new_index, NewParseError(tokens[new_index], "Replace statements can't use 'tree' since the replacement is built from the variables.")
+	}
+
 	replaceCommand := AstReplace{
 		All:  all,
 		Skip: skipValue,
@@ -116,7 +142,7 @@ func parse_replace(tokens []*Token, token_index int) (*AstReplace, int, error) {
 		current_token = tokens[current_index]
 	}
 
-	return &replaceCommand, current_index, nil
+	return spanning(&replaceCommand, tokens, token_index, current_index), current_index, nil
 }
 
 func parse_set(tokens []*Token, token_index int) (*AstSet, int, error) {
@@ -168,7 +194,7 @@ func parse_set(tokens []*Token, token_index int) (*AstSet, int, error) {
 		Id:   name,
 		Body: body,
 	}
-	return &setCommand, current_index, nil
+	return spanning(&setCommand, tokens, token_index, current_index), current_index, nil
 }
 
 func parse_set_transform(tokens []*Token, token_index int) (AstSetBody, int, error) {
@@ -187,7 +213,7 @@ func parse_set_transform(tokens []*Token, token_index int) (AstSetBody, int, err
 		return nil, next_index, NewParseError(tokens[next_index], "Unexpected token. Expected 'end'.")
 	}
 
-	return &AstSetTransform{statements}, next_index + 1, err
+	return spanning(&AstSetTransform{Statements: statements}, tokens, token_index, next_index+1), next_index + 1, err
 }
 
 func parse_set_pattern(tokens []*Token, token_index int) (AstSetBody, int, error) {
@@ -207,7 +233,7 @@ func parse_set_pattern(tokens []*Token, token_index int) (AstSetBody, int, error
 
 	current_index = consumeIgnoreableTokens(tokens, current_index)
 	if tokens[current_index].TokenType != BEGIN {
-		return &AstSetPattern{pattern, []AstProcessStatement{}}, current_index, nil
+		return spanning(&AstSetPattern{Pattern: pattern, Body: []AstProcessStatement{}}, tokens, token_index, current_index), current_index, nil
 	}
 
 	statements, next_index, err := parse_process_statements(tokens, current_index+1)
@@ -219,7 +245,7 @@ func parse_set_pattern(tokens []*Token, token_index int) (AstSetBody, int, error
 		return nil, next_index, NewParseError(tokens[next_index], "Unexpected token. Expected 'end'.")
 	}
 
-	return &AstSetPattern{pattern, statements}, next_index + 1, nil
+	return spanning(&AstSetPattern{Pattern: pattern, Body: statements}, tokens, token_index, next_index+1), next_index + 1, nil
 }
 
 func parse_set_matches(tokens []*Token, token_index int) (AstSetBody, int, error) {
@@ -228,7 +254,7 @@ func parse_set_matches(tokens []*Token, token_index int) (AstSetBody, int, error
 	if err != nil {
 		return nil, next_index, err
 	}
-	return &AstSetMatches{command}, next_index, err
+	return spanning(&AstSetMatches{Command: command}, tokens, token_index, next_index), next_index, err
 }
 
 func parse_amount(tokens []*Token, token_index int) (bool, int, int, int, int, error) {
@@ -301,8 +327,16 @@ func parse_expression(tokens []*Token, token_index int) (AstExpression, int, err
 		return parse_exactly(tokens, token_index)
 	} else if current_token.TokenType == MAYBE {
 		return parse_maybe(tokens, token_index)
+	} else if isLookaround(tokens, token_index) {
+		return parse_lookaround(tokens, token_index, false)
+	} else if current_token.TokenType == IN && isTermList(tokens, token_index) {
+		return parse_term_list(tokens, token_index)
 	} else if current_token.TokenType == IN {
 		return parse_in(tokens, token_index, false)
+	} else if current_token.TokenType == IF {
+		return parse_conditional(tokens, token_index)
+	} else if current_token.isKeyword(WITHIN) && tokens[consumeIgnoreableTokens(tokens, token_index+1)].TokenType == NUMBER {
+		return parse_within(tokens, token_index)
 	} else if current_token.TokenType == OPENCURLY {
 		return parse_subroutine(tokens, token_index)
 	} else if current_token.TokenType == NOT {
@@ -318,7 +352,7 @@ func parse_expression(tokens []*Token, token_index int) (AstExpression, int, err
 		current_token.TokenType == WHOLE || current_token.TokenType == CASELESS {
 		return parse_primary_or_dec(tokens, token_index)
 	}
-	return nil, token_index, NewParseError(current_token, "Unexpected token. Expected 'at', 'between', 'exactly', 'maybe', 'in', '<string>', '<identifier>', or a character class ")
+	return nil, token_index, NewParseError(current_token, "Unexpected token. Expected 'at', 'between', 'exactly', 'maybe', 'followed', 'preceded', 'in', 'if', 'within', '<string>', '<identifier>', or a character class ")
 }
 
 func parse_at(tokens []*Token, token_index int) (*AstLoop, int, error) {
@@ -350,7 +384,8 @@ func parse_at(tokens []*Token, token_index int) (*AstLoop, int, error) {
 	current_index = consumeIgnoreableTokens(tokens, next_index)
 	current_token = tokens[current_index]
 	fewest := current_token.TokenType == FEWEST
-	if fewest {
+	possessive := current_token.isKeyword(POSSESSIVE)
+	if fewest || possessive {
 		current_index += 1
 	}
 
@@ -379,14 +414,15 @@ func parse_at(tokens []*Token, token_index int) (*AstLoop, int, error) {
 	}
 
 	atLoop := AstLoop{
-		Min:    min,
-		Max:    max,
-		Fewest: fewest,
-		Body:   expr,
-		Name:   loopName,
+		Min:        min,
+		Max:        max,
+		Fewest:     fewest,
+		Possessive: possessive,
+		Body:       expr,
+		Name:       loopName,
 	}
 
-	return &atLoop, current_index, nil
+	return spanning(&atLoop, tokens, token_index, current_index), current_index, nil
 }
 
 func parse_between(tokens []*Token, token_index int) (*AstLoop, int, error) {
@@ -426,7 +462,8 @@ func parse_between(tokens []*Token, token_index int) (*AstLoop, int, error) {
 	current_index = consumeIgnoreableTokens(tokens, next_index)
 	current_token = tokens[current_index]
 	fewest := current_token.TokenType == FEWEST
-	if fewest {
+	possessive := current_token.isKeyword(POSSESSIVE)
+	if fewest || possessive {
 		current_index += 1
 	}
 
@@ -445,14 +482,15 @@ func parse_between(tokens []*Token, token_index int) (*AstLoop, int, error) {
 	}
 
 	between := AstLoop{
-		Min:    minValue,
-		Max:    maxValue,
-		Fewest: fewest,
-		Body:   expr,
-		Name:   loopName,
+		Min:        minValue,
+		Max:        maxValue,
+		Fewest:     fewest,
+		Possessive: possessive,
+		Body:       expr,
+		Name:       loopName,
 	}
 
-	return &between, current_index, nil
+	return spanning(&between, tokens, token_index, current_index), current_index, nil
 }
 
 func parse_exactly(tokens []*Token, token_index int) (*AstLoop, int, error) {
@@ -495,7 +533,7 @@ func parse_exactly(tokens []*Token, token_index int) (*AstLoop, int, error) {
 		Name:   loopName,
 	}
 
-	return &exactly, next_index, nil
+	return spanning(&exactly, tokens, token_index, next_index), next_index, nil
 }
 
 func parse_maybe(tokens []*Token, token_index int) (*AstLoop, int, error) {
@@ -508,13 +546,157 @@ func parse_maybe(tokens []*Token, token_index int) (*AstLoop, int, error) {
 	current_index := consumeIgnoreableTokens(tokens, next_index)
 	current_token := tokens[current_index]
 	fewest := current_token.TokenType == FEWEST
-	if fewest {
+	possessive := current_token.isKeyword(POSSESSIVE)
+	if fewest || possessive {
 		current_index += 1
 	}
 
-	maybe := AstLoop{0, 1, fewest, expr, ""}
+	maybe := AstLoop{
+		Min:        0,
+		Max:        1,
+		Fewest:     fewest,
+		Possessive: possessive,
+		Body:       expr,
+	}
+
+	return spanning(&maybe, tokens, token_index, current_index), current_index, nil
+}
+
+func parse_lookaround(tokens []*Token, token_index int, not bool) (*AstLookaround, int, error) {
+	behind := tokens[token_index].isKeyword(PRECEDED)
+
+	// isLookaround already found the 'by'
+	current_index := consumeIgnoreableTokens(tokens, token_index+1)
+	current_index = consumeIgnoreableTokens(tokens, current_index+1)
+	expr, next_index, err := parse_expression(tokens, current_index)
+	if err != nil {
+		return nil, next_index, err
+	}
+
+	lookaround := AstLookaround{
+		Not:    not,
+		Behind: behind,
+		Body:   expr,
+	}
+	return spanning(&lookaround, tokens, token_index, next_index), next_index, nil
+}
+
+func parse_conditional(tokens []*Token, token_index int) (*AstConditional, int, error) {
+	current_index := consumeIgnoreableTokens(tokens, token_index+1)
+	current_token := tokens[current_index]
+	if current_token.TokenType != IDENTIFIER {
+		return nil, current_index, NewParseError(current_token, "Unexpected token. Expected identifier.")
+	}
+
+	conditional := AstConditional{
+		Variable: current_token.Lexeme,
+		Then:     []AstExpression{},
+		Else:     []AstExpression{},
+	}
+
+	current_index = consumeIgnoreableTokens(tokens, current_index+1)
+	current_token = tokens[current_index]
+	if current_token.TokenType != THEN {
+		return nil, current_index, NewParseError(current_token, "Unexpected token. Expected 'then'.")
+	}
+
+	then_body, current_index, err := parse_conditional_body(tokens, current_index+1)
+	if err != nil {
+		return nil, current_index, err
+	}
+	conditional.Then = then_body
+
+	if tokens[current_index].TokenType == ELSE {
+		else_body, next_index, err := parse_conditional_body(tokens, current_index+1)
+		if err != nil {
+			return nil, next_index, err
+		}
+		conditional.Else = else_body
+		current_index = next_index
+	}
+
+	if tokens[current_index].TokenType != END {
+		return nil, current_index, NewParseError(tokens[current_index], "Unexpected token. Expected 'else' or 'end'.")
+	}
+	return spanning(&conditional, tokens, token_index, current_index+1), current_index + 1, nil
+}
+
+func parse_within(tokens []*Token, token_index int) (*AstFuzzy, int, error) {
+	current_index := consumeIgnoreableTokens(tokens, token_index+1)
+	current_token := tokens[current_index]
+	if current_token.TokenType != NUMBER {
+		return nil, current_index, NewParseError(current_token, "Unexpected token. Expected a number.")
+	}
+	value, err := strconv.Atoi(current_token.Lexeme)
+	if err != nil {
+		return nil, current_index, NewParseError(current_token, "Error converting lexeme to number value")
+	}
+
+	current_index = consumeIgnoreableTokens(tokens, current_index+1)
+	current_token = tokens[current_index]
+	if !current_token.isKeyword(EDITS) {
+		return nil, current_index, NewParseError(current_token, "Unexpected token. Expected 'edits'.")
+	}
 
-	return &maybe, current_index, nil
+	swaps := false
+	current_index = consumeIgnoreableTokens(tokens, current_index+1)
+	current_token = tokens[current_index]
+	if current_token.TokenType == OR {
+		current_index = consumeIgnoreableTokens(tokens, current_index+1)
+		current_token = tokens[current_index]
+		if !current_token.isKeyword(SWAPS) {
+			return nil, current_index, NewParseError(current_token, "Unexpected token. Expected 'swaps'.")
+		}
+		swaps = true
+		current_index = consumeIgnoreableTokens(tokens, current_index+1)
+		current_token = tokens[current_index]
+	}
+
+	if !current_token.isKeyword(OF) {
+		return nil, current_index, NewParseError(current_token, "Unexpected token. Expected 'of'.")
+	}
+
+	current_index = consumeIgnoreableTokens(tokens, current_index+1)
+	target, next_index, parseError := parse_atom(tokens, current_index)
+	if parseError != nil {
+		return nil, next_index, parseError
+	}
+
+	fuzzy := AstFuzzy{
+		MaxEdits: value,
+		Swaps:    swaps,
+		Target:   target,
+	}
+
+	current_index = consumeIgnoreableTokens(tokens, next_index)
+	if tokens[current_index].TokenType == NAMED {
+		current_index = consumeIgnoreableTokens(tokens, current_index+1)
+		nameToken := tokens[current_index]
+		if nameToken.TokenType != IDENTIFIER {
+			return nil, current_index, NewParseError(nameToken, "Unexpected token. Expected identifier.")
+		}
+		fuzzy.Name = nameToken.Lexeme
+		next_index = current_index + 1
+	}
+
+	return spanning(&fuzzy, tokens, token_index, next_index), next_index, nil
+}
+
+func parse_conditional_body(tokens []*Token, token_index int) ([]AstExpression, int, error) {
+	body := []AstExpression{}
+	current_index := consumeIgnoreableTokens(tokens, token_index)
+	current_token := tokens[current_index]
+	for current_token.TokenType != ELSE && current_token.TokenType != END && current_token.TokenType != FIND && current_token.TokenType != REPLACE && current_token.TokenType != SET && current_token.TokenType != EOF {
+		expr, new_index, parseError := parse_expression(tokens, current_index)
+		if parseError != nil {
+			return nil, new_index, parseError
+		}
+
+		body = append(body, expr)
+		current_index = consumeIgnoreableTokens(tokens, new_index)
+		current_token = tokens[current_index]
+	}
+	return body, current_index, nil
 }
 
 func parse_not_expression(tokens []*Token, token_index int) (AstExpression, int, error) {
@@ -522,7 +704,17 @@ func parse_not_expression(tokens []*Token, token_index int) (AstExpression, int,
 	current_token := tokens[new_index]
 
 	if current_token.TokenType == IN {
-		return parse_in(tokens, new_index, true)
+		list, next_index, err := parse_in(tokens, new_index, true)
+		if err != nil {
+			return nil, next_index, err
+		}
+		return spanning(list, tokens, token_index, next_index), next_index, nil
+	} else if isLookaround(tokens, new_index) {
+		lookaround, next_index, err := parse_lookaround(tokens, new_index, true)
+		if err != nil {
+			return nil, next_index, err
+		}
+		return spanning(lookaround, tokens, token_index, next_index), next_index, nil
 	} else {
 		return parse_primary_or_dec(tokens, token_index)
 	}
@@ -541,6 +733,8 @@ func parse_not_literal(tokens []*Token, token_index int) (AstLiteral, int, error
 		current_token.TokenType == FILE || current_token.TokenType == WORD ||
 		current_token.TokenType == WHOLE {
 		return parse_character_class(tokens, new_index, true)
+	} else if isUnicodeClass(tokens, new_index) {
+		return parse_unicode_class(tokens, new_index, true)
 	} else {
 		return nil, new_index, NewParseError(current_token, "Unexpected token. Expected 'in', <string>, <character class>")
 	}
@@ -568,11 +762,74 @@ func parse_in(tokens []*Token, token_index int, not bool) (*AstList, int, error)
 		current_token = tokens[current_index]
 	}
 	inList := AstList{Contents: contents, Not: not}
-	return &inList, current_index, nil
+	return spanning(&inList, tokens, token_index, current_index), current_index, nil
+}
+
+// isTermList looks past the options of an 'in' for the 'list' keyword so 'in whole word, "a"' is still a regular list
+func isTermList(tokens []*Token, token_index int) bool {
+	current_index := consumeIgnoreableTokens(tokens, token_index+1)
+	for tokens[current_index].TokenType == CASELESS || tokens[current_index].TokenType == WHOLE || tokens[current_index].TokenType == WORD {
+		current_index = consumeIgnoreableTokens(tokens, current_index+1)
+	}
+	return tokens[current_index].isKeyword(LIST)
+}
+
+func parse_term_list(tokens []*Token, token_index int) (*AstTermList, int, error) {
+	termList := AstTermList{Terms: []string{}}
+
+	current_index := consumeIgnoreableTokens(tokens, token_index+1)
+	current_token := tokens[current_index]
+	for !current_token.isKeyword(LIST) {
+		if current_token.TokenType == CASELESS {
+			termList.Caseless = true
+		} else if current_token.TokenType == WHOLE {
+			current_index = consumeIgnoreableTokens(tokens, current_index+1)
+			if tokens[current_index].TokenType != WORD {
+				return nil, current_index, NewParseError(tokens[current_index], "Unexpected token. Expected 'word'.")
+			}
+			termList.WholeWord = true
+		} else {
+			return nil, current_index, NewParseError(current_token, "Unexpected token. Expected 'caseless', 'whole word', or 'list'.")
+		}
+		current_index = consumeIgnoreableTokens(tokens, current_index+1)
+		current_token = tokens[current_index]
+	}
+
+	current_index = consumeIgnoreableTokens(tokens, current_index+1)
+	current_token = tokens[current_index]
+	if current_token.isKeyword(FROM) {
+		current_index = consumeIgnoreableTokens(tokens, current_index+1)
+		if tokens[current_index].TokenType != FILE {
+			return nil, current_index, NewParseError(tokens[current_index], "Unexpected token. Expected 'file'.")
+		}
+		current_index = consumeIgnoreableTokens(tokens, current_index+1)
+		if tokens[current_index].TokenType != STRING {
+			return nil, current_index, NewParseError(tokens[current_index], "Unexpected token. Expected a string")
+		}
+		termList.File = tokens[current_index].Lexeme
+		return spanning(&termList, tokens, token_index, current_index+1), current_index + 1, nil
+	}
+
+	if current_token.TokenType != STRING {
+		return nil, current_index, NewParseError(current_token, "Unexpected token. Expected 'from' or a string")
+	}
+	termList.Terms = append(termList.Terms, current_token.Lexeme)
+	next_index := current_index + 1
+	current_index = consumeIgnoreableTokens(tokens, next_index)
+	for tokens[current_index].TokenType == COMMA {
+		current_index = consumeIgnoreableTokens(tokens, current_index+1)
+		if tokens[current_index].TokenType != STRING {
+			return nil, current_index, NewParseError(tokens[current_index], "Unexpected token. Expected a string")
+		}
+		termList.Terms = append(termList.Terms, tokens[current_index].Lexeme)
+		next_index = current_index + 1
+		current_index = consumeIgnoreableTokens(tokens, next_index)
+	}
+	return spanning(&termList, tokens, token_index, next_index), next_index, nil
 }
 
 func isListableClass(t TokenType) bool {
-	return t == ANY || t == WHITESPACE || t == DIGIT || t == UPPER || t == LOWER || t == LETTER
+	return t == ANY || t == WHITESPACE || t == DIGIT || t == UPPER || t == LOWER || t == LETTER || t == WORD
 }
 
 func parse_listable(tokens []*Token, token_index int) (AstListable, int, error) {
@@ -599,12 +856,14 @@ func parse_listable(tokens []*Token, token_index int) (AstListable, int, error)
 			From: from,
 			To:   to,
 		}
-		return &r, new_index, nil
+		return spanning(&r, tokens, token_index, new_index), new_index, nil
 
 	} else if current_token.TokenType == CASELESS {
 		return parse_caseless(tokens, token_index)
 	} else if isListableClass(current_token.TokenType) {
 		return parse_character_class(tokens, token_index, false)
+	} else if isUnicodeClass(tokens, token_index) {
+		return parse_unicode_class(tokens, token_index, false)
 	}
 	return nil, token_index, NewParseError(current_token, "Unexpected token. Expected listable literal")
 }
@@ -614,7 +873,17 @@ func parse_literal(tokens []*Token, token_index int) (AstLiteral, int, error) {
 	if current_token.TokenType == STRING {
 		return parse_string(tokens, token_index, false)
 	} else if current_token.TokenType == CASELESS {
+		next_index := consumeIgnoreableTokens(tokens, token_index+1)
+		if tokens[next_index].TokenType == OPENPAREN {
+			return parse_caseless_sub_expression(tokens, next_index)
+		}
 		return parse_caseless(tokens, token_index)
+	} else if current_token.isKeyword(ATOMIC) && tokens[consumeIgnoreableTokens(tokens, token_index+1)].TokenType == OPENPAREN {
+		return parse_atomic_sub_expression(tokens, token_index)
+	} else if current_token.isKeyword(NUMERIC) && tokens[consumeIgnoreableTokens(tokens, token_index+1)].TokenType == BETWEEN {
+		return parse_number_range(tokens, token_index)
+	} else if isUnicodeClass(tokens, token_index) {
+		return parse_unicode_class(tokens, token_index, false)
 	} else if current_token.TokenType == IDENTIFIER {
 		return parse_variable(tokens, token_index)
 	} else if current_token.TokenType == OPENPAREN {
@@ -629,7 +898,79 @@ func parse_literal(tokens []*Token, token_index int) (AstLiteral, int, error) {
 		current_token.TokenType == WHOLE {
 		return parse_character_class(tokens, token_index, false)
 	}
-	return nil, token_index, NewParseError(current_token, "Unexpected token. Expected '(', '<string>', '<identifier>', or a character class.")
+	return nil, token_index, NewParseError(current_token, "Unexpected token. Expected '(', '<string>', '<identifier>', 'number', or a character class.")
+}
+
+func parse_number_range(tokens []*Token, token_index int) (*AstNumberRange, int, error) {
+	current_index := consumeIgnoreableTokens(tokens, token_index+1)
+	current_token := tokens[current_index]
+	if current_token.TokenType != BETWEEN {
+		return nil, current_index, NewParseError(current_token, "Unexpected token. Expected 'between'.")
+	}
+
+	current_index = consumeIgnoreableTokens(tokens, current_index+1)
+	minValue, current_index, err := parse_signed_number(tokens, current_index)
+	if err != nil {
+		return nil, current_index, err
+	}
+
+	current_index = consumeIgnoreableTokens(tokens, current_index)
+	current_token = tokens[current_index]
+	if current_token.TokenType != AND {
+		return nil, current_index, NewParseError(current_token, "Unexpected token. Expected 'and'.")
+	}
+
+	current_index = consumeIgnoreableTokens(tokens, current_index+1)
+	max_token := tokens[current_index]
+	maxValue, current_index, err := parse_signed_number(tokens, current_index)
+	if err != nil {
+		return nil, current_index, err
+	}
+	if minValue > maxValue {
+		return nil, current_index, NewParseError(max_token, "The largest number can't be smaller than the smallest number.")
+	}
+
+	number := AstNumberRange{
+		Min: minValue,
+		Max: maxValue,
+	}
+
+	// the options can come in any order
+	for {
+		next_index := consumeIgnoreableTokens(tokens, current_index)
+		switch next := tokens[next_index]; {
+		case next.isKeyword(PADDED):
+			number.LeadingZeros = true
+		case next.isKeyword(SIGNED):
+			number.Signs = true
+		case next.isKeyword(DECIMAL):
+			number.Decimals = true
+		default:
+			return spanning(&number, tokens, token_index, current_index), current_index, nil
+		}
+		current_index = next_index + 1
+	}
+}
+
+func parse_signed_number(tokens []*Token, token_index int) (int, int, error) {
+	current_index := token_index
+	negative := tokens[current_index].TokenType == MINUS
+	if negative {
+		current_index += 1
+	}
+
+	current_token := tokens[current_index]
+	if current_token.TokenType != NUMBER {
+		return 0, current_index, NewParseError(current_token, "Unexpected token. Expected a number.")
+	}
+	value, err := strconv.Atoi(current_token.Lexeme)
+	if err != nil {
+		return 0, current_index, NewParseError(current_token, "Error converting lexeme to number value")
+	}
+	if negative {
+		value = -value
+	}
+	return value, current_index + 1, nil
 }
 
 func parse_primary_or_dec(tokens []*Token, token_index int) (AstExpression, int, error) {
@@ -652,7 +993,7 @@ func parse_primary_or_dec(tokens []*Token, token_index int) (AstExpression, int,
 			Name: current_token.Lexeme,
 			Body: literal,
 		}
-		return &dec, current_index + 1, nil
+		return spanning(&dec, tokens, token_index, current_index+1), current_index + 1, nil
 	}
 
 	if current_token.TokenType == OR {
@@ -666,13 +1007,13 @@ func parse_primary_or_dec(tokens []*Token, token_index int) (AstExpression, int,
 			Left:  literal,
 			Right: right_expression,
 		}
-		return &branch, final_index, nil
+		return spanning(&branch, tokens, token_index, final_index), final_index, nil
 	}
 
 	prim := AstPrimary{}
 	prim.Literal = literal
 
-	return &prim, new_index, nil
+	return withSpan(&prim, literal.Span()), new_index, nil
 }
 
 func parse_primary_or_or(tokens []*Token, token_index int) (AstExpression, int, error) {
@@ -694,13 +1035,13 @@ func parse_primary_or_or(tokens []*Token, token_index int) (AstExpression, int,
 			Left:  literal,
 			Right: right_expression,
 		}
-		return &branch, final_index, nil
+		return spanning(&branch, tokens, token_index, final_index), final_index, nil
 	}
 
 	prim := AstPrimary{}
 	prim.Literal = literal
 
-	return &prim, new_index, nil
+	return withSpan(&prim, literal.Span()), new_index, nil
 }
 
 func parse_atom(tokens []*Token, token_index int) (AstAtom, int, error) {
@@ -718,16 +1059,16 @@ func parse_atom(tokens []*Token, token_index int) (AstAtom, int, error) {
 func parse_caseless(tokens []*Token, token_index int) (*AstString, int, error) {
 	next_index := consumeIgnoreableTokens(tokens, token_index+1)
 	if tokens[next_index].TokenType != STRING {
-		return nil, next_index, NewParseError(tokens[next_index], "Unexpected token. Expected <string> after the 'caseless' keyword.")
+		return nil, next_index, NewParseError(tokens[next_index], "Unexpected token. Expected <string> or '(' after the 'caseless' keyword.")
 	}
 
 	s := AstString{
-		false,
-		tokens[next_index].Lexeme,
-		true,
+		Not:      false,
+		Value:    tokens[next_index].Lexeme,
+		Caseless: true,
 	}
 
-	return &s, next_index + 1, nil
+	return spanning(&s, tokens, token_index, next_index+1), next_index + 1, nil
 }
 
 func parse_string(tokens []*Token, token_index int, not bool) (*AstString, int, error) {
@@ -738,7 +1079,7 @@ func parse_string(tokens []*Token, token_index int, not bool) (*AstString, int,
 
 	if current_token.TokenType == STRING {
 		str_literal.Value = current_token.Lexeme
-		return &str_literal, token_index + 1, nil
+		return spanning(&str_literal, tokens, token_index, token_index+1), token_index + 1, nil
 	}
 
 	return nil, token_index, NewParseError(current_token, "Unexpected token. Expected a string")
@@ -750,7 +1091,7 @@ func parse_variable(tokens []*Token, token_index int) (*AstVariable, int, error)
 
 	if current_token.TokenType == IDENTIFIER {
 		var_literal.Name = current_token.Lexeme
-		return &var_literal, token_index + 1, nil
+		return spanning(&var_literal, tokens, token_index, token_index+1), token_index + 1, nil
 	}
 
 	return nil, token_index, NewParseError(current_token, "Unexpected token. Expected a variable")
@@ -778,7 +1119,29 @@ func parse_sub_expression(tokens []*Token, token_index int) (*AstSubExpr, int, e
 	}
 
 	sub_expr := AstSubExpr{Body: expr_list}
-	return &sub_expr, current_index + 1, nil
+	return spanning(&sub_expr, tokens, token_index, current_index+1), current_index + 1, nil
+}
+
+func parse_caseless_sub_expression(tokens []*Token, token_index int) (*AstSubExpr, int, error) {
+	sub_expr, next_index, err := parse_sub_expression(tokens, token_index)
+	if err != nil {
+		return nil, next_index, err
+	}
+	sub_expr.Caseless = true
+	return sub_expr, next_index, nil
+}
+
+func parse_atomic_sub_expression(tokens []*Token, token_index int) (*AstSubExpr, int, error) {
+	next_index := consumeIgnoreableTokens(tokens, token_index+1)
+	if tokens[next_index].TokenType != OPENPAREN {
+		return nil, next_index, NewParseError(tokens[next_index], "Unexpected token. Expected '(' after the 'atomic' keyword.")
+	}
+	sub_expr, next_index, err := parse_sub_expression(tokens, next_index)
+	if err != nil {
+		return nil, next_index, err
+	}
+	sub_expr.Atomic = true
+	return spanning(sub_expr, tokens, token_index, next_index), next_index, nil
 }
 
 func parse_subroutine(tokens []*Token, token_index int) (*AstSub, int, error) {
@@ -819,7 +1182,7 @@ func parse_subroutine(tokens []*Token, token_index int) (*AstSub, int, error) {
 		Name: current_token.Lexeme,
 		Body: expr_list,
 	}
-	return &dec, current_index + 1, nil
+	return spanning(&dec, tokens, token_index, current_index+1), current_index + 1, nil
 }
 
 func parse_character_class(tokens []*Token, token_index int, not bool) (*AstCharacterClass, int, error) {
@@ -874,7 +1237,8 @@ func parse_character_class(tokens []*Token, token_index int, not bool) (*AstChar
 			charClass.ClassType = ClassWordEnd
 			return &charClass, new_index + 1, nil
 		}
-		return nil, new_index, NewParseError(tokens[new_index], "Unexpected token. Expected 'start' or 'end'")
+		charClass.ClassType = ClassWord
+		return &charClass, token_index + 1, nil
 	} else if current_token.TokenType == WHOLE {
 		new_index := consumeIgnoreableTokens(tokens, token_index+1)
 		if tokens[new_index].TokenType == LINE {
@@ -889,7 +1253,75 @@ func parse_character_class(tokens []*Token, token_index int, not bool) (*AstChar
 		}
 		return nil, new_index, NewParseError(tokens[new_index], "Unexpected token. Expected 'file', 'line', or 'word'")
 	}
-	return nil, token_index, NewParseError(tokens[token_index], "Unexpected token. Expected a character class: 'any', 'whitespace', 'digit', 'upper', 'lower', 'letter', 'whole word', 'whole line', 'whole file', 'word start', 'word end', 'line start', 'line end', 'file start', or 'file end'.")
+	return nil, token_index, NewParseError(tokens[token_index], "Unexpected token. Expected a character class: 'any', 'whitespace', 'digit', 'upper', 'lower', 'letter', 'word', 'whole word', 'whole line', 'whole file', 'word start', 'word end', 'line start', 'line end', 'file start', or 'file end'.")
+}
+
+// isUnicodeClass is true when the tokens at token_index are 'unicode' and a class name or 'category' or 'script' and a string
+func isUnicodeClass(tokens []*Token, token_index int) bool {
+	current_token := tokens[token_index]
+	name_token := tokens[consumeIgnoreableTokens(tokens, token_index+1)]
+	if current_token.isKeyword(UNICODE) {
+		_, found := unicodeClassAliases[strings.ToLower(name_token.Lexeme)]
+		return found
+	}
+	return (current_token.isKeyword(CATEGORY) || current_token.isKeyword(SCRIPT)) && name_token.TokenType == STRING
+}
+
+// isLookaround is true when the tokens at token_index are 'followed by' or 'preceded by'
+func isLookaround(tokens []*Token, token_index int) bool {
+	current_token := tokens[token_index]
+	if !current_token.isKeyword(FOLLOWED) && !current_token.isKeyword(PRECEDED) {
+		return false
+	}
+	return tokens[consumeIgnoreableTokens(tokens, token_index+1)].isKeyword(BY)
+}
+
+var unicodeClassAliases = map[string]AstUnicodeClass{
+	"letter":      {ClassType: UnicodeCategory, Name: "L"},
+	"upper":       {ClassType: UnicodeCategory, Name: "Lu"},
+	"lower":       {ClassType: UnicodeCategory, Name: "Ll"},
+	"title":       {ClassType: UnicodeCategory, Name: "Lt"},
+	"mark":        {ClassType: UnicodeCategory, Name: "M"},
+	"number":      {ClassType: UnicodeCategory, Name: "N"},
+	"digit":       {ClassType: UnicodeCategory, Name: "Nd"},
+	"punctuation": {ClassType: UnicodeCategory, Name: "P"},
+	"symbol":      {ClassType: UnicodeCategory, Name: "S"},
+	"separator":   {ClassType: UnicodeCategory, Name: "Z"},
+	"control":     {ClassType: UnicodeCategory, Name: "Cc"},
+	"whitespace":  {ClassType: UnicodeProperty, Name: "White_Space"},
+}
+
+func parse_unicode_class(tokens []*Token, token_index int, not bool) (*AstUnicodeClass, int, error) {
+	current_token := tokens[token_index]
+	name_index := consumeIgnoreableTokens(tokens, token_index+1)
+	name_token := tokens[name_index]
+
+	if current_token.isKeyword(UNICODE) {
+		alias, found := unicodeClassAliases[strings.ToLower(name_token.Lexeme)]
+		if !found {
+			return nil, name_index, NewParseError(name_token, "Unexpected token. Expected 'letter', 'upper', 'lower', 'title', 'mark', 'number', 'digit', 'punctuation', 'symbol', 'separator', 'control', or 'whitespace'.")
+		}
+		alias.Not = not
+		return spanning(&alias, tokens, token_index, name_index+1), name_index + 1, nil
+	}
+
+	if name_token.TokenType != STRING {
+		return nil, name_index, NewParseError(name_token, "Unexpected token. Expected <string>.")
+	}
+
+	class := AstUnicodeClass{
+		Not:       not,
+		ClassType: UnicodeCategory,
+		Name:      name_token.Lexeme,
+	}
+	if current_token.isKeyword(SCRIPT) {
+		class.ClassType = UnicodeScript
+	}
+
+	if _, found := UnicodeTable(class.ClassType, class.Name); !found {
+		return nil, name_index, NewParseError(name_token, fmt.Sprintf("Unknown unicode %s '%s'.", class.ClassType, class.Name))
+	}
+	return spanning(&class, tokens, token_index, name_index+1), name_index + 1, nil
 }
 
 func parse_process_statements(tokens []*Token, index int) ([]AstProcessStatement, int, error) {
@@ -924,9 +1356,9 @@ func parse_process_statement(tokens []*Token, index int) (AstProcessStatement, i
 	} else if tokens[index].TokenType == LOOP {
 		return parse_process_loop(tokens, index)
 	} else if tokens[index].TokenType == BREAK {
-		return &AstProcessBreak{}, index + 1, nil
+		return spanning(&AstProcessBreak{}, tokens, index, index+1), index + 1, nil
 	} else if tokens[index].TokenType == CONTINUE {
-		return &AstProcessContinue{}, index + 1, nil
+		return spanning(&AstProcessContinue{}, tokens, index, index+1), index + 1, nil
 	} else if tokens[index].TokenType == END {
 		return nil, index, nil
 	} else if tokens[index].TokenType == ELSE {
@@ -963,7 +1395,7 @@ func parse_process_set(tokens []*Token, index int) (AstProcessStatement, int, er
 		Name: name,
 		Expr: expr,
 	}
-	return &setStatement, next_index, nil
+	return spanning(&setStatement, tokens, index, next_index), next_index, nil
 }
 
 func parse_process_if(tokens []*Token, index int) (AstProcessStatement, int, error) {
@@ -997,7 +1429,7 @@ func parse_process_if(tokens []*Token, index int) (AstProcessStatement, int, err
 		return nil, follow_index, NewParseError(tokens[follow_index], "Unexpected token. Expected 'end'.")
 	}
 
-	return &AstProcessIf{expr, trueBody, falseBody}, follow_index + 1, nil
+	return spanning(&AstProcessIf{Condition: expr, TrueBody: trueBody, FalseBody: falseBody}, tokens, index, follow_index+1), follow_index + 1, nil
 }
 
 func parse_process_return(tokens []*Token, index int) (AstProcessStatement, int, error) {
@@ -1007,7 +1439,7 @@ func parse_process_return(tokens []*Token, index int) (AstProcessStatement, int,
 		return nil, next_index, err
 	}
 
-	return &AstProcessReturn{expr}, next_index, err
+	return spanning(&AstProcessReturn{Expr: expr}, tokens, index, next_index), next_index, err
 }
 
 func parse_process_debug(tokens []*Token, index int) (AstProcessStatement, int, error) {
@@ -1017,7 +1449,7 @@ func parse_process_debug(tokens []*Token, index int) (AstProcessStatement, int,
 		return nil, next_index, err
 	}
 
-	return &AstProcessDebug{expr}, next_index, err
+	return spanning(&AstProcessDebug{Expr: expr}, tokens, index, next_index), next_index, err
 }
 
 func parse_process_loop(tokens []*Token, index int) (AstProcessStatement, int, error) {
@@ -1033,7 +1465,7 @@ func parse_process_loop(tokens []*Token, index int) (AstProcessStatement, int, e
 		return nil, next_index, NewParseError(tokens[next_index], "Unexpected token. Expected 'end'.")
 	}
 
-	return &AstProcessLoop{body}, next_index + 1, nil
+	return spanning(&AstProcessLoop{Body: body}, tokens, index, next_index+1), next_index + 1, nil
 }
 
 func parse_process_expression(tokens []*Token, index int) (AstProcessExpression, int, error) {
@@ -1048,20 +1480,22 @@ func parse_process_expression(tokens []*Token, index int) (AstProcessExpression,
 func parse_expr_pratt(tokens []*Token, index int, minPrecedence int) (AstProcessExpression, int, error) {
 	token_index := index + 1
 	var lhs AstProcessExpression
+	// process expressions are values so their span has to be set when they are made
+	at := spanned{tokens[index].Span()}
 	if tokens[index].TokenType == STRING {
-		lhs = AstProcessString{tokens[index].Lexeme}
+		lhs = AstProcessString{at, tokens[index].Lexeme}
 	} else if tokens[index].TokenType == TRUE {
-		lhs = AstProcessBoolean{true}
+		lhs = AstProcessBoolean{at, true}
 	} else if tokens[index].TokenType == FALSE {
-		lhs = AstProcessBoolean{false}
+		lhs = AstProcessBoolean{at, false}
 	} else if tokens[index].TokenType == NUMBER {
 		intval, err := strconv.Atoi(tokens[index].Lexeme)
 		if err != nil {
 			intval = 0
 		}
-		lhs = AstProcessNumber{intval}
+		lhs = AstProcessNumber{at, intval}
 	} else if tokens[index].TokenType == IDENTIFIER {
-		lhs = AstProcessVariable{tokens[index].Lexeme}
+		lhs = AstProcessVariable{at, tokens[index].Lexeme}
 	} else if tokens[index].TokenType == OPENPAREN {
 		subexpr, next_index, err := parse_expr_pratt(tokens, index+1, 0)
 		if err != nil {
@@ -1078,7 +1512,7 @@ func parse_expr_pratt(tokens []*Token, index int, minPrecedence int) (AstProcess
 		if err != nil {
 			return nil, next_index, err
 		}
-		lhs = AstProcessUnaryExpression{tokens[index].TokenType, rhs}
+		lhs = AstProcessUnaryExpression{spanned{at.span.Through(rhs.Span())}, tokens[index].TokenType, rhs}
 		token_index = next_index
 	} else {
 		return nil, index, NewParseError(tokens[index], "Unexpected token. Expected string, number, variable, or unary operator")
@@ -1101,7 +1535,7 @@ func parse_expr_pratt(tokens []*Token, index int, minPrecedence int) (AstProcess
 			return nil, next_index, err
 		}
 		token_index = next_index
-		lhs = AstProcessBinaryExpression{op, lhs, rhs}
+		lhs = AstProcessBinaryExpression{spanned{lhs.Span().Through(rhs.Span())}, op, lhs, rhs}
 	}
 
 	return lhs, token_index, nil
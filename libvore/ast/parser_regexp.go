@@ -3,7 +3,11 @@ package ast
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
+
+	"github.com/jmeaster30/vore/libvore/ds"
 )
 
 /*
@@ -22,13 +26,34 @@ func parse_regexp(tokens []*Token, token_index int) (AstExpression, int, error)
 		return nil, token_index, err
 	}
 
-	s := &AstPrimary{
-		&AstSubExpr{results},
-	}
+	span := regexp_token.Span()
+	s := withSpan(&AstPrimary{
+		Literal: withSpan(&AstSubExpr{Body: results}, span),
+	}, span)
 
 	return s, token_index + 1, nil
 }
 
+// regexp_span is the span of regexp[start:end] in the source. The regexp is written as @/regexp/ so it starts
+// 2 characters into its token. A regexp that spans multiple lines just gets the span of the whole token.
+func regexp_span(regexp_token *Token, regexp string, start int, end int) Span {
+	span := regexp_token.Span()
+	if strings.ContainsRune(regexp, '\n') {
+		return span
+	}
+	if end > len(regexp) {
+		end = len(regexp)
+	}
+	if start > end {
+		start = end
+	}
+	from := 2 + utf8.RuneCountInString(regexp[:start])
+	to := 2 + utf8.RuneCountInString(regexp[:end])
+	span.Offset = ds.Range{Start: span.Offset.Start + from, End: span.Offset.Start + to}
+	span.Column = ds.Range{Start: span.Column.Start + from, End: span.Column.Start + to}
+	return span
+}
+
 func parse_regexp_disjunction(regexp_token *Token, regexp string, index int) ([]AstExpression, int, error) {
 	current_index := index
 	results := []AstExpression{}
@@ -52,7 +77,14 @@ func parse_regexp_pattern(regexp_token *Token, regexp string, index int) (AstExp
 	if next_index < len(regexp) {
 		if regexp[next_index] == '|' {
 			end, idx, err := parse_regexp_pattern(regexp_token, regexp, next_index+1)
-			return &AstBranch{&AstSubExpr{[]AstExpression{start}}, end}, idx, err
+			if err != nil {
+				return nil, idx, err
+			}
+			branch := &AstBranch{
+				Left:  withSpan(&AstSubExpr{Body: []AstExpression{start}}, start.Span()),
+				Right: end,
+			}
+			return withSpan(branch, regexp_span(regexp_token, regexp, index, idx)), idx, nil
 		} else {
 			return start, next_index, nil
 		}
@@ -85,46 +117,49 @@ func parse_regexp_literal(regexp_token *Token, regexp string, index int) (AstExp
 	var start AstLiteral
 	next_index := index
 	if c == '^' {
-		start = &AstCharacterClass{false, ClassLineStart}
 		next_index += 1
-		return &AstPrimary{start}, next_index, nil
+		start = withSpan(&AstCharacterClass{Not: false, ClassType: ClassLineStart}, regexp_span(regexp_token, regexp, index, next_index))
+		return withSpan(&AstPrimary{Literal: start}, start.Span()), next_index, nil
 	} else if c == '$' {
-		start = &AstCharacterClass{false, ClassLineEnd}
 		next_index += 1
-		return &AstPrimary{start}, next_index, nil
+		start = withSpan(&AstCharacterClass{Not: false, ClassType: ClassLineEnd}, regexp_span(regexp_token, regexp, index, next_index))
+		return withSpan(&AstPrimary{Literal: start}, start.Span()), next_index, nil
 	} else if c == '\\' {
 		start, next_index, err := parse_regexp_escape_characters(regexp_token, regexp, index+1)
 		if err != nil {
 			return nil, next_index, err
 		}
+		start = withSpan(start, regexp_span(regexp_token, regexp, index, next_index))
 		exp, idx, err := parse_regexp_quantifier(regexp_token, regexp, next_index)
 		if err != nil {
 			return nil, idx, err
 		}
 		if exp == nil {
-			return &AstPrimary{start}, idx, nil
+			return withSpan(&AstPrimary{Literal: start}, start.Span()), idx, nil
 		}
-		exp.Body = &AstPrimary{start}
-		return exp, idx, nil
+		exp.Body = withSpan(&AstPrimary{Literal: start}, start.Span())
+		return withSpan(exp, regexp_span(regexp_token, regexp, index, idx)), idx, nil
 	} else if c == '(' {
 		start, next_index, err := parse_regexp_groups(regexp_token, regexp, index+1)
 		if err != nil {
 			return nil, next_index, err
 		}
+		start = withSpan(start, regexp_span(regexp_token, regexp, index, next_index))
 		exp, idx, err := parse_regexp_quantifier(regexp_token, regexp, next_index)
 		if err != nil {
 			return nil, idx, err
 		}
 		if exp == nil {
-			return &AstPrimary{start}, idx, nil
+			return withSpan(&AstPrimary{Literal: start}, start.Span()), idx, nil
 		}
-		exp.Body = &AstPrimary{start}
-		return exp, idx, nil
+		exp.Body = withSpan(&AstPrimary{Literal: start}, start.Span())
+		return withSpan(exp, regexp_span(regexp_token, regexp, index, idx)), idx, nil
 	} else if c == '[' {
 		start, next_index, err := parse_regexp_character_class(regexp_token, regexp, index+1)
 		if err != nil {
 			return nil, next_index, err
 		}
+		start = withSpan(start, regexp_span(regexp_token, regexp, index, next_index))
 		exp, idx, err := parse_regexp_quantifier(regexp_token, regexp, next_index)
 		if err != nil {
 			return nil, idx, err
@@ -133,31 +168,34 @@ func parse_regexp_literal(regexp_token *Token, regexp string, index int) (AstExp
 			return start, idx, nil
 		}
 		exp.Body = start
-		return exp, idx, nil
+		return withSpan(exp, regexp_span(regexp_token, regexp, index, idx)), idx, nil
 	} else if c == '.' {
-		start = &AstString{true, "\n", false}
+		start = &AstString{Not: true, Value: "\n", Caseless: false}
 		next_index += 1
+		start = withSpan(start, regexp_span(regexp_token, regexp, index, next_index))
 		exp, idx, err := parse_regexp_quantifier(regexp_token, regexp, next_index)
 		if err != nil {
 			return nil, idx, err
 		}
 		if exp == nil {
-			return &AstPrimary{start}, idx, nil
+			return withSpan(&AstPrimary{Literal: start}, start.Span()), idx, nil
 		}
-		exp.Body = &AstPrimary{start}
-		return exp, idx, nil
+		exp.Body = withSpan(&AstPrimary{Literal: start}, start.Span())
+		return withSpan(exp, regexp_span(regexp_token, regexp, index, idx)), idx, nil
 	} else {
-		start = &AstString{false, string(c), false}
-		next_index += 1
+		r, width := utf8.DecodeRuneInString(regexp[index:])
+		start = &AstString{Not: false, Value: string(r), Caseless: false}
+		next_index += width
+		start = withSpan(start, regexp_span(regexp_token, regexp, index, next_index))
 		exp, idx, err := parse_regexp_quantifier(regexp_token, regexp, next_index)
 		if err != nil {
 			return nil, idx, err
 		}
 		if exp == nil {
-			return &AstPrimary{start}, idx, nil
+			return withSpan(&AstPrimary{Literal: start}, start.Span()), idx, nil
 		}
-		exp.Body = &AstPrimary{start}
-		return exp, idx, nil
+		exp.Body = withSpan(&AstPrimary{Literal: start}, start.Span())
+		return withSpan(exp, regexp_span(regexp_token, regexp, index, idx)), idx, nil
 	}
 }
 
@@ -192,12 +230,12 @@ func parse_regexp_character_class(regexp_token *Token, regexp string, index int)
 	}
 
 	if len(results) == 0 {
-		results = append(results, &AstCharacterClass{true, ClassAny})
+		results = append(results, withSpan(&AstCharacterClass{Not: true, ClassType: ClassAny}, regexp_span(regexp_token, regexp, index, next_index)))
 	}
 
 	next_index += 1
 
-	return &AstList{notin, results}, next_index, nil
+	return withSpan(&AstList{Not: notin, Contents: results}, regexp_span(regexp_token, regexp, index-1, next_index)), next_index, nil
 }
 
 func parse_regexp_class_ranges(regexp_token *Token, regexp string, index int) (AstListable, int, error) {
@@ -223,7 +261,7 @@ func parse_regexp_class_ranges(regexp_token *Token, regexp string, index int) (A
 				return start, next_index, nil
 			}
 
-			return &AstRange{start, to}, end_index, nil
+			return withSpan(&AstRange{From: start, To: to}, regexp_span(regexp_token, regexp, index, end_index)), end_index, nil
 		}
 
 		return start, next_index, err
@@ -234,14 +272,33 @@ func parse_regexp_class_atom_escape(regexp_token *Token, regexp string, index in
 	if index+1 >= len(regexp) {
 		return nil, index + 1, NewParseError(regexp_token, "Unexpected end of regexp")
 	}
-	panic("PARSE ESCAPE CHARACTER")
+	c := regexp[index+1]
+	switch c {
+	case 'd':
+		return withSpan(&AstCharacterClass{Not: false, ClassType: ClassDigit}, regexp_span(regexp_token, regexp, index, index+2)), index + 2, nil
+	case 'D':
+		return withSpan(&AstCharacterClass{Not: true, ClassType: ClassDigit}, regexp_span(regexp_token, regexp, index, index+2)), index + 2, nil
+	case 's':
+		return withSpan(&AstCharacterClass{Not: false, ClassType: ClassWhitespace}, regexp_span(regexp_token, regexp, index, index+2)), index + 2, nil
+	case 'S':
+		return withSpan(&AstCharacterClass{Not: true, ClassType: ClassWhitespace}, regexp_span(regexp_token, regexp, index, index+2)), index + 2, nil
+	case 'w':
+		return withSpan(&AstCharacterClass{Not: false, ClassType: ClassWord}, regexp_span(regexp_token, regexp, index, index+2)), index + 2, nil
+	case 'W':
+		return withSpan(&AstCharacterClass{Not: true, ClassType: ClassWord}, regexp_span(regexp_token, regexp, index, index+2)), index + 2, nil
+	case 'p', 'P':
+		return parse_regexp_unicode_class(regexp_token, regexp, index+2, c == 'P')
+	}
+	r, width := utf8.DecodeRuneInString(regexp[index+1:])
+	return withSpan(&AstString{Not: false, Value: string(getEscapedRune(r)), Caseless: false}, regexp_span(regexp_token, regexp, index, index+1+width)), index + 1 + width, nil
 }
 
 func parse_regexp_class_atom_string(regexp_token *Token, regexp string, index int) (*AstString, int, error) {
 	if regexp[index] == ']' {
 		return nil, index, nil
 	}
-	return &AstString{false, string(regexp[index]), false}, index + 1, nil
+	r, width := utf8.DecodeRuneInString(regexp[index:])
+	return withSpan(&AstString{Not: false, Value: string(r), Caseless: false}, regexp_span(regexp_token, regexp, index, index+width)), index + width, nil
 }
 
 func parse_regexp_quantifier(regexp_token *Token, regexp string, index int) (*AstLoop, int, error) {
@@ -252,13 +309,13 @@ func parse_regexp_quantifier(regexp_token *Token, regexp string, index int) (*As
 	var end_idx int
 	var exp *AstLoop
 	if op == '*' {
-		exp = &AstLoop{0, -1, false, nil, ""}
+		exp = &AstLoop{Min: 0, Max: -1}
 		end_idx = index + 1
 	} else if op == '+' {
-		exp = &AstLoop{1, -1, false, nil, ""}
+		exp = &AstLoop{Min: 1, Max: -1}
 		end_idx = index + 1
 	} else if op == '?' {
-		exp = &AstLoop{0, 1, false, nil, ""}
+		exp = &AstLoop{Min: 0, Max: 1}
 		end_idx = index + 1
 	} else if op == '{' {
 		from, idx, err := parse_regexp_number(regexp_token, regexp, index+1)
@@ -269,7 +326,7 @@ func parse_regexp_quantifier(regexp_token *Token, regexp string, index int) (*As
 
 		if comma_or_brace == ',' {
 			if regexp[idx+1] == '}' {
-				exp = &AstLoop{from, -1, false, nil, ""}
+				exp = &AstLoop{Min: from, Max: -1}
 				end_idx = idx + 2
 			} else {
 				to, idx2, err := parse_regexp_number(regexp_token, regexp, idx+1)
@@ -281,11 +338,11 @@ func parse_regexp_quantifier(regexp_token *Token, regexp string, index int) (*As
 					return nil, idx2, NewParseError(regexp_token, "Unexpected character. Expected '}'")
 				}
 
-				exp = &AstLoop{from, to, false, nil, ""}
+				exp = &AstLoop{Min: from, Max: to}
 				end_idx = idx2 + 1
 			}
 		} else if comma_or_brace == '}' {
-			exp = &AstLoop{from, from, false, nil, ""}
+			exp = &AstLoop{Min: from, Max: from}
 			end_idx = idx + 1
 		}
 	} else {
@@ -295,7 +352,8 @@ func parse_regexp_quantifier(regexp_token *Token, regexp string, index int) (*As
 
 	if exp != nil {
 		exp.Fewest = end_idx < len(regexp) && regexp[end_idx] == '?'
-		if exp.Fewest {
+		exp.Possessive = end_idx < len(regexp) && regexp[end_idx] == '+'
+		if exp.Fewest || exp.Possessive {
 			end_idx += 1
 		}
 	}
@@ -307,29 +365,44 @@ func parse_regexp_escape_characters(regexp_token *Token, regexp string, index in
 	c := regexp[index]
 	if c >= '1' && c <= '9' {
 		if index+1 >= len(regexp) {
-			return &AstVariable{fmt.Sprintf("_%c", c)}, index + 1, nil
+			return &AstVariable{Name: fmt.Sprintf("_%c", c)}, index + 1, nil
 		}
 		d := regexp[index+1]
 		if d >= '0' && d <= '9' {
-			return &AstVariable{fmt.Sprintf("_%c%c", c, d)}, index + 2, nil
+			return &AstVariable{Name: fmt.Sprintf("_%c%c", c, d)}, index + 2, nil
 		}
-		return &AstVariable{fmt.Sprintf("_%c", c)}, index + 1, nil
+		return &AstVariable{Name: fmt.Sprintf("_%c", c)}, index + 1, nil
 	} else if c == 'd' {
-		return &AstCharacterClass{false, ClassDigit}, index + 1, nil
+		return &AstCharacterClass{Not: false, ClassType: ClassDigit}, index + 1, nil
 	} else if c == 'D' {
-		return &AstCharacterClass{true, ClassDigit}, index + 1, nil
+		return &AstCharacterClass{Not: true, ClassType: ClassDigit}, index + 1, nil
 	} else if c == 's' {
-		return &AstCharacterClass{false, ClassWhitespace}, index + 1, nil
+		return &AstCharacterClass{Not: false, ClassType: ClassWhitespace}, index + 1, nil
 	} else if c == 'S' {
-		return &AstCharacterClass{true, ClassWhitespace}, index + 1, nil
+		return &AstCharacterClass{Not: true, ClassType: ClassWhitespace}, index + 1, nil
 	} else if c == 'w' {
-		return &AstCharacterClass{false, ClassLetter}, index + 1, nil // FIXME This isn't the word class
+		return &AstCharacterClass{Not: false, ClassType: ClassWord}, index + 1, nil
 	} else if c == 'W' {
-		return &AstCharacterClass{true, ClassLetter}, index + 1, nil // FIXME This isn't the word class
+		return &AstCharacterClass{Not: true, ClassType: ClassWord}, index + 1, nil
+	} else if c == 'p' || c == 'P' {
+		return parse_regexp_unicode_class(regexp_token, regexp, index+1, c == 'P')
 	} else if c == 'b' {
-		return &AstSubExpr{[]AstExpression{&AstBranch{&AstCharacterClass{false, ClassWordStart}, &AstPrimary{&AstCharacterClass{false, ClassWordEnd}}}}}, index + 1, nil
+		span := regexp_span(regexp_token, regexp, index-1, index+1)
+		branch := &AstBranch{
+			Left:  withSpan(&AstCharacterClass{Not: false, ClassType: ClassWordStart}, span),
+			Right: withSpan(&AstPrimary{Literal: withSpan(&AstCharacterClass{Not: false, ClassType: ClassWordEnd}, span)}, span),
+		}
+		return &AstSubExpr{Body: []AstExpression{withSpan(branch, span)}}, index + 1, nil
 	} else if c == 'B' {
-		return &AstSubExpr{[]AstExpression{&AstList{true, []AstListable{&AstCharacterClass{false, ClassWordStart}, &AstCharacterClass{false, ClassWordEnd}}}}}, index + 1, nil
+		span := regexp_span(regexp_token, regexp, index-1, index+1)
+		list := &AstList{
+			Not: true,
+			Contents: []AstListable{
+				withSpan(&AstCharacterClass{Not: false, ClassType: ClassWordStart}, span),
+				withSpan(&AstCharacterClass{Not: false, ClassType: ClassWordEnd}, span),
+			},
+		}
+		return &AstSubExpr{Body: []AstExpression{withSpan(list, span)}}, index + 1, nil
 	} else if c == 'k' {
 		d := regexp[index+1]
 		if d != '<' {
@@ -347,9 +420,10 @@ func parse_regexp_escape_characters(regexp_token *Token, regexp string, index in
 		if regexp[current_index] != '>' {
 			return nil, current_index, NewParseError(regexp_token, "Unexpected charactrer in named capture group identifier.")
 		}
-		return &AstVariable{identifier}, current_index + 1, nil
+		return &AstVariable{Name: identifier}, current_index + 1, nil
 	} else {
-		return &AstString{false, string(c), false}, index + 1, nil
+		r, width := utf8.DecodeRuneInString(regexp[index:])
+		return &AstString{Not: false, Value: string(r), Caseless: false}, index + width, nil
 	}
 }
 
@@ -367,18 +441,40 @@ func parse_regexp_groups(regexp_token *Token, regexp string, index int) (AstLite
 			if regexp[next_index] != ')' {
 				return nil, next_index, NewParseError(regexp_token, "Expected end parenthesis")
 			}
-			return &AstSubExpr{subexpr}, next_index + 1, nil
+			return &AstSubExpr{Body: subexpr}, next_index + 1, nil
+		} else if marker == 'i' && regexp[index+2] == ':' {
+			// caseless non capture group
+			subexpr, next_index, err := parse_regexp_disjunction(regexp_token, regexp, index+3)
+			if err != nil {
+				return nil, next_index, err
+			}
+			if regexp[next_index] != ')' {
+				return nil, next_index, NewParseError(regexp_token, "Expected end parenthesis")
+			}
+			return &AstSubExpr{Body: subexpr, Caseless: true}, next_index + 1, nil
+		} else if marker == '>' {
+			// atomic group
+			subexpr, next_index, err := parse_regexp_disjunction(regexp_token, regexp, index+2)
+			if err != nil {
+				return nil, next_index, err
+			}
+			if regexp[next_index] != ')' {
+				return nil, next_index, NewParseError(regexp_token, "Expected end parenthesis")
+			}
+			return &AstSubExpr{Body: subexpr, Atomic: true}, next_index + 1, nil
+		} else if marker == '(' {
+			return parse_regexp_conditional(regexp_token, regexp, index+2)
 		} else if marker == '=' {
-			panic("Positive lookahead unimplemented")
+			return parse_regexp_lookaround(regexp_token, regexp, index+2, false, false)
 		} else if marker == '!' {
-			panic("Negative lookahead unimplemented")
+			return parse_regexp_lookaround(regexp_token, regexp, index+2, true, false)
 		} else if marker == '<' {
 			// lookbehind or named capture group
 			a := regexp[index+2]
 			if a == '=' {
-				panic("Positive lookbehind unimplemented")
+				return parse_regexp_lookaround(regexp_token, regexp, index+3, false, true)
 			} else if a == '!' {
-				panic("Negative lookahead unimplemented")
+				return parse_regexp_lookaround(regexp_token, regexp, index+3, true, true)
 			} else {
 				// named capture group
 				current_index := index + 2
@@ -399,7 +495,9 @@ func parse_regexp_groups(regexp_token *Token, regexp string, index int) (AstLite
 				if regexp[next_index] != ')' {
 					return nil, next_index, NewParseError(regexp_token, "Expected end parenthesis")
 				}
-				return &AstSubExpr{[]AstExpression{&AstDec{identifier, &AstSubExpr{body}}}}, next_index + 1, nil
+				span := regexp_span(regexp_token, regexp, index-1, next_index+1)
+				dec := &AstDec{Name: identifier, Body: withSpan(&AstSubExpr{Body: body}, span)}
+				return &AstSubExpr{Body: []AstExpression{withSpan(dec, span)}}, next_index + 1, nil
 			}
 		}
 		return nil, index, NewParseError(regexp_token, "Invalid marker for group")
@@ -413,5 +511,137 @@ func parse_regexp_groups(regexp_token *Token, regexp string, index int) (AstLite
 		return nil, next_index, NewParseError(regexp_token, "Expected end parenthesis")
 	}
 	capture_group_number += 1
-	return &AstSubExpr{[]AstExpression{&AstDec{fmt.Sprintf("_%d", capture_group_number), &AstSubExpr{subexpr}}}}, next_index + 1, nil
+	span := regexp_span(regexp_token, regexp, index-1, next_index+1)
+	dec := &AstDec{Name: fmt.Sprintf("_%d", capture_group_number), Body: withSpan(&AstSubExpr{Body: subexpr}, span)}
+	return &AstSubExpr{Body: []AstExpression{withSpan(dec, span)}}, next_index + 1, nil
+}
+
+// parse_regexp_conditional parses (?(1)yes|no), (?(name)yes|no), (?(<name>)yes|no) and (?('name')yes|no)
+// where the no side is optional
+func parse_regexp_conditional(regexp_token *Token, regexp string, index int) (AstLiteral, int, error) {
+	current_index := index
+	for current_index < len(regexp) && regexp[current_index] != ')' {
+		current_index += 1
+	}
+	if current_index >= len(regexp) {
+		return nil, current_index, NewParseError(regexp_token, "Expected end parenthesis")
+	}
+	name := regexp[index:current_index]
+	if len(name) > 2 && ((name[0] == '<' && name[len(name)-1] == '>') || (name[0] == '\'' && name[len(name)-1] == '\'')) {
+		name = name[1 : len(name)-1]
+	}
+	if name == "" {
+		return nil, current_index, NewParseError(regexp_token, "Missing the group to check in conditional group")
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		// numbered capture groups are stored in variables named by their number
+		name = "_" + name
+	}
+
+	then_body, current_index, err := parse_regexp_alternative(regexp_token, regexp, current_index+1)
+	if err != nil {
+		return nil, current_index, err
+	}
+	else_body := []AstExpression{}
+	if current_index < len(regexp) && regexp[current_index] == '|' {
+		else_body, current_index, err = parse_regexp_alternative(regexp_token, regexp, current_index+1)
+		if err != nil {
+			return nil, current_index, err
+		}
+		if current_index < len(regexp) && regexp[current_index] == '|' {
+			return nil, current_index, NewParseError(regexp_token, "Conditional groups can only have two alternatives")
+		}
+	}
+	if current_index >= len(regexp) || regexp[current_index] != ')' {
+		return nil, current_index, NewParseError(regexp_token, "Expected end parenthesis")
+	}
+
+	conditional := &AstConditional{
+		Variable: name,
+		Then:     then_body,
+		Else:     else_body,
+	}
+	// index is just past the "(?(" that starts the group
+	conditional = withSpan(conditional, regexp_span(regexp_token, regexp, index-3, current_index+1))
+	return &AstSubExpr{Body: []AstExpression{conditional}}, current_index + 1, nil
+}
+
+// parse_regexp_alternative parses patterns up to the next '|' or ')' that isn't nested in a group
+func parse_regexp_alternative(regexp_token *Token, regexp string, index int) ([]AstExpression, int, error) {
+	current_index := index
+	results := []AstExpression{}
+	for current_index < len(regexp) && regexp[current_index] != '|' && regexp[current_index] != ')' {
+		exp, next_index, err := parse_regexp_literal(regexp_token, regexp, current_index)
+		if err != nil {
+			return nil, next_index, err
+		}
+		results = append(results, exp)
+		current_index = next_index
+	}
+	return results, current_index, nil
+}
+
+func parse_regexp_lookaround(regexp_token *Token, regexp string, index int, not bool, behind bool) (AstLiteral, int, error) {
+	body, next_index, err := parse_regexp_disjunction(regexp_token, regexp, index)
+	if err != nil {
+		return nil, next_index, err
+	}
+	if next_index >= len(regexp) || regexp[next_index] != ')' {
+		return nil, next_index, NewParseError(regexp_token, "Expected end parenthesis")
+	}
+	// index is just past the "(?=", "(?!", "(?<=" or "(?<!" that starts the group
+	var span Span
+	if behind {
+		span = regexp_span(regexp_token, regexp, index-4, next_index+1)
+	} else {
+		span = regexp_span(regexp_token, regexp, index-3, next_index+1)
+	}
+	lookaround := &AstLookaround{
+		Not:    not,
+		Behind: behind,
+		Body:   withSpan(&AstPrimary{Literal: withSpan(&AstSubExpr{Body: body}, span)}, span),
+	}
+	lookaround = withSpan(lookaround, span)
+	return &AstSubExpr{Body: []AstExpression{lookaround}}, next_index + 1, nil
+}
+
+func parse_regexp_unicode_class(regexp_token *Token, regexp string, index int, not bool) (*AstUnicodeClass, int, error) {
+	if index >= len(regexp) {
+		return nil, index, NewParseError(regexp_token, "Unexpected end of regexp")
+	}
+
+	name := ""
+	next_index := index
+	if regexp[index] == '{' {
+		end := strings.IndexByte(regexp[index:], '}')
+		if end == -1 {
+			return nil, index, NewParseError(regexp_token, "Expected '}' to close the unicode property")
+		}
+		name = regexp[index+1 : index+end]
+		next_index = index + end + 1
+	} else {
+		name = regexp[index : index+1]
+		next_index = index + 1
+	}
+
+	classTypes := []AstUnicodeClassType{UnicodeCategory, UnicodeScript, UnicodeProperty}
+	if key, value, found := strings.Cut(name, "="); found {
+		switch key {
+		case "gc", "General_Category":
+			classTypes = []AstUnicodeClassType{UnicodeCategory}
+		case "sc", "Script":
+			classTypes = []AstUnicodeClassType{UnicodeScript}
+		default:
+			return nil, next_index, NewParseError(regexp_token, fmt.Sprintf("Unknown unicode property '%s'", key))
+		}
+		name = value
+	}
+
+	for _, classType := range classTypes {
+		if _, found := UnicodeTable(classType, name); found {
+			// index is just past the \p or \P
+			return withSpan(&AstUnicodeClass{Not: not, ClassType: classType, Name: name}, regexp_span(regexp_token, regexp, index-2, next_index)), next_index, nil
+		}
+	}
+	return nil, next_index, NewParseError(regexp_token, fmt.Sprintf("Unknown unicode class '%s'", name))
 }
@@ -0,0 +1,62 @@
+package ast
+
+import "github.com/jmeaster30/vore/libvore/ds"
+
+// Span is the part of the source a token or node came from
+type Span struct {
+	Offset ds.Range
+	Line   ds.Range
+	Column ds.Range
+}
+
+// Through is the span from the start of s to the end of other
+func (s Span) Through(other Span) Span {
+	return Span{
+		Offset: ds.Range{Start: s.Offset.Start, End: other.Offset.End},
+		Line:   ds.Range{Start: s.Line.Start, End: other.Line.End},
+		Column: ds.Range{Start: s.Column.Start, End: other.Column.End},
+	}
+}
+
+// spanned is embedded in every node to remember where in the source it came from
+type spanned struct {
+	span Span
+}
+
+func (s spanned) Span() Span {
+	return s.span
+}
+
+func (s *spanned) setSpan(span Span) {
+	s.span = span
+}
+
+// withSpan sets the span of node and hands the node back so it can be used right in a return
+func withSpan[T AstNode](node T, span Span) T {
+	var n any = node
+	if s, ok := n.(interface{ setSpan(Span) }); ok {
+		s.setSpan(span)
+	}
+	return node
+}
+
+// spanning sets the span of node to cover the tokens from start up to end
+func spanning[T AstNode](node T, tokens []*Token, start int, end int) T {
+	return withSpan(node, tokenSpan(tokens, start, end))
+}
+
+// tokenSpan covers the tokens from start up to end leaving off any whitespace and comments at the end
+func tokenSpan(tokens []*Token, start int, end int) Span {
+	if end > len(tokens) {
+		end = len(tokens)
+	}
+	first := tokens[start]
+	last := first
+	for i := end - 1; i > start; i-- {
+		if tokens[i].TokenType != WS && tokens[i].TokenType != COMMENT {
+			last = tokens[i]
+			break
+		}
+	}
+	return first.Span().Through(last.Span())
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/jmeaster30/vore/libvore/ast"
+	"github.com/jmeaster30/vore/libvore/ds"
 )
 
 type Command interface {
@@ -13,17 +14,20 @@ type Command interface {
 }
 
 type FindCommand struct {
-	All  bool
-	Skip int
-	Take int
-	Last int
-	Body []SearchInstruction
+	All         bool
+	Skip        int
+	Take        int
+	Last        int
+	Overlapping bool
+	Tree        bool
+	Body        []SearchInstruction
+	Spans       []ast.Span // the part of the source each instruction of Body was generated from
 }
 
 func (f FindCommand) IsCommand() {}
 
 func (f FindCommand) String() string {
-	return fmt.Sprintf("(find (all %t) (min %d max %d) (last %d) %s)", f.All, f.Skip, f.Take, f.Last, f.Body)
+	return fmt.Sprintf("(find (all %t) (min %d max %d) (last %d) (overlapping %t) (tree %t) %s)", f.All, f.Skip, f.Take, f.Last, f.Overlapping, f.Tree, f.Body)
 }
 
 type ReplaceCommand struct {
@@ -32,6 +36,7 @@ type ReplaceCommand struct {
 	Take     int
 	Last     int
 	Body     []SearchInstruction
+	Spans    []ast.Span // the part of the source each instruction of Body was generated from
 	Replacer []ReplaceInstruction
 }
 
@@ -100,6 +105,7 @@ func (i MatchLiteral) String() string {
 }
 
 func (i MatchLiteral) adjust(offset int, state *GenState) SearchInstruction {
+	i.Caseless = i.Caseless || state.caseless
 	return i
 }
 
@@ -118,49 +124,142 @@ func (i MatchCharClass) adjust(offset int, state *GenState) SearchInstruction {
 	return i
 }
 
+type MatchUnicodeClass struct {
+	Not   bool
+	Class ast.AstUnicodeClassType
+	Name  string
+}
+
+func (i MatchUnicodeClass) IsSearchInstruction() {}
+
+func (i MatchUnicodeClass) String() string {
+	return fmt.Sprintf("(unicode (not %t) %s '%s')", i.Not, i.Class, i.Name)
+}
+
+func (i MatchUnicodeClass) adjust(offset int, state *GenState) SearchInstruction {
+	return i
+}
+
 type MatchVariable struct {
-	Name string
+	Name     string
+	Caseless bool
 }
 
 func (i MatchVariable) IsSearchInstruction() {}
 
 func (i MatchVariable) String() string {
-	return fmt.Sprintf("(var '%s')", i.Name)
+	return fmt.Sprintf("(var (caseless %t) '%s')", i.Caseless, i.Name)
 }
 
 func (i MatchVariable) adjust(offset int, state *GenState) SearchInstruction {
+	i.Caseless = i.Caseless || state.caseless
 	return i
 }
 
 type MatchRange struct {
-	Not  bool
-	From string
-	To   string
+	Not      bool
+	From     string
+	To       string
+	Caseless bool
 }
 
 func (i MatchRange) IsSearchInstruction() {}
 
 func (i MatchRange) String() string {
-	return fmt.Sprintf("(range (not %t) (from '%s') (to '%s'))", i.Not, i.From, i.To)
+	return fmt.Sprintf("(range (not %t) (caseless %t) (from '%s') (to '%s'))", i.Not, i.Caseless, i.From, i.To)
 }
 
 func (i MatchRange) adjust(offset int, state *GenState) SearchInstruction {
+	i.Caseless = i.Caseless || state.caseless
+	return i
+}
+
+// MatchTerms matches the longest term in Terms that the text starts with. When WholeWord the term can't be part of a bigger word.
+type MatchTerms struct {
+	Terms     *ds.Trie
+	WholeWord bool
+}
+
+func (i MatchTerms) IsSearchInstruction() {}
+
+func (i MatchTerms) String() string {
+	return fmt.Sprintf("(terms (whole word %t) %d)", i.WholeWord, i.Terms.Size())
+}
+
+func (i MatchTerms) adjust(offset int, state *GenState) SearchInstruction {
+	if state.caseless {
+		i.Terms = i.Terms.Caseless()
+	}
+	return i
+}
+
+// MatchNumber matches a number written in the text whose value is from Min to Max
+type MatchNumber struct {
+	Min          int
+	Max          int
+	LeadingZeros bool
+	Signs        bool
+	Decimals     bool
+}
+
+func (i MatchNumber) IsSearchInstruction() {}
+
+func (i MatchNumber) String() string {
+	return fmt.Sprintf("(number (padded %t) (signed %t) (decimal %t) %d %d)", i.LeadingZeros, i.Signs, i.Decimals, i.Min, i.Max)
+}
+
+func (i MatchNumber) adjust(offset int, state *GenState) SearchInstruction {
+	return i
+}
+
+// MatchFuzzy matches text within MaxEdits insertions, deletions and substitutions of ToFind
+// or of the value of Variable when it is set. Swaps also counts swapping two neighbouring runes as one edit.
+type MatchFuzzy struct {
+	ToFind   string
+	Variable string
+	MaxEdits int
+	Swaps    bool
+	Caseless bool
+	Name     string
+}
+
+func (i MatchFuzzy) IsSearchInstruction() {}
+
+func (i MatchFuzzy) String() string {
+	if i.Variable != "" {
+		return fmt.Sprintf("(fuzzy (edits %d) (swaps %t) (caseless %t) (var '%s') '%s')", i.MaxEdits, i.Swaps, i.Caseless, i.Variable, i.Name)
+	}
+	return fmt.Sprintf("(fuzzy (edits %d) (swaps %t) (caseless %t) '%s' '%s')", i.MaxEdits, i.Swaps, i.Caseless, i.ToFind, i.Name)
+}
+
+func (i MatchFuzzy) adjust(offset int, state *GenState) SearchInstruction {
+	i.Caseless = i.Caseless || state.caseless
 	return i
 }
 
+// unresolvedCall is the ToPC of a call to a pattern that was set after the pattern making the call.
+// The call gets pointed at the pattern once the command using them both is generated.
+const unresolvedCall = -1
+
+// CallSubroutine jumps to the subroutine at ToPC. Caseless calls go to a copy of the pattern that ignores case
+// so a pattern called inside a caseless group matches the same as one set under a caseless program.
 type CallSubroutine struct {
-	Name string
-	ToPC int
+	Name     string
+	ToPC     int
+	Caseless bool
 }
 
 func (i CallSubroutine) IsSearchInstruction() {}
 
 func (i CallSubroutine) String() string {
-	return fmt.Sprintf("(call '%s' %d)", i.Name, i.ToPC)
+	return fmt.Sprintf("(call (caseless %t) '%s' %d)", i.Caseless, i.Name, i.ToPC)
 }
 
 func (i CallSubroutine) adjust(offset int, state *GenState) SearchInstruction {
-	i.ToPC += offset
+	if i.ToPC != unresolvedCall {
+		i.ToPC += offset
+	}
+	i.Caseless = i.Caseless || state.caseless
 	return i
 }
 
@@ -175,9 +274,44 @@ func (i Branch) String() string {
 }
 
 func (i Branch) adjust(offset int, state *GenState) SearchInstruction {
+	// the branches are shared with the pattern this was copied from so they can't be changed in place
+	branches := make([]int, len(i.Branches))
 	for idx := range i.Branches {
-		i.Branches[idx] += offset
+		branches[idx] = i.Branches[idx] + offset
 	}
+	i.Branches = branches
+	return i
+}
+
+// IfVariable moves on to the next instruction when the variable has been captured and jumps to ElsePC when it hasn't
+type IfVariable struct {
+	Name   string
+	ElsePC int
+}
+
+func (i IfVariable) IsSearchInstruction() {}
+
+func (i IfVariable) String() string {
+	return fmt.Sprintf("(ifVariable %s %d)", i.Name, i.ElsePC)
+}
+
+func (i IfVariable) adjust(offset int, state *GenState) SearchInstruction {
+	i.ElsePC += offset
+	return i
+}
+
+// StartNotInList tries every length from MaxSize down to 1 and each length is checked against the items of the list
+type StartNotInList struct {
+	MaxSize int
+}
+
+func (i StartNotInList) IsSearchInstruction() {}
+
+func (i StartNotInList) String() string {
+	return fmt.Sprintf("(startNotInList %d)", i.MaxSize)
+}
+
+func (i StartNotInList) adjust(offset int, state *GenState) SearchInstruction {
 	return i
 }
 
@@ -222,6 +356,64 @@ func (i EndNotIn) adjust(offset int, state *GenState) SearchInstruction {
 	return i
 }
 
+type StartLookaround struct {
+	Not       bool
+	Behind    bool
+	MaxLength int
+	EndPC     int
+}
+
+func (i StartLookaround) IsSearchInstruction() {}
+
+func (i StartLookaround) String() string {
+	return fmt.Sprintf("(startLookaround (not %t) (behind %t) (max %d) %d)", i.Not, i.Behind, i.MaxLength, i.EndPC)
+}
+
+func (i StartLookaround) adjust(offset int, state *GenState) SearchInstruction {
+	i.EndPC += offset
+	return i
+}
+
+type EndLookaround struct {
+	Not    bool
+	Behind bool
+}
+
+func (i EndLookaround) IsSearchInstruction() {}
+
+func (i EndLookaround) String() string {
+	return fmt.Sprintf("(endLookaround (not %t) (behind %t))", i.Not, i.Behind)
+}
+
+func (i EndLookaround) adjust(offset int, state *GenState) SearchInstruction {
+	return i
+}
+
+// StartAtomic begins a group that can't be backtracked into once EndAtomic is reached
+type StartAtomic struct{}
+
+func (i StartAtomic) IsSearchInstruction() {}
+
+func (i StartAtomic) String() string {
+	return "(startAtomic)"
+}
+
+func (i StartAtomic) adjust(offset int, state *GenState) SearchInstruction {
+	return i
+}
+
+type EndAtomic struct{}
+
+func (i EndAtomic) IsSearchInstruction() {}
+
+func (i EndAtomic) String() string {
+	return "(endAtomic)"
+}
+
+func (i EndAtomic) adjust(offset int, state *GenState) SearchInstruction {
+	return i
+}
+
 type StartLoop struct {
 	Id       int64
 	MinLoops int
@@ -303,6 +495,8 @@ func (i StartSubroutine) String() string {
 }
 
 func (i StartSubroutine) adjust(offset int, state *GenState) SearchInstruction {
+	// the id is where the subroutine starts since that is where calls to it jump to
+	i.Id += offset
 	i.EndOffset += offset
 	return i
 }
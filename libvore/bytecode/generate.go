@@ -2,6 +2,10 @@ package bytecode
 
 import (
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/jmeaster30/vore/libvore/ast"
 	"github.com/jmeaster30/vore/libvore/ds"
@@ -12,23 +16,39 @@ type Bytecode struct {
 }
 
 type GeneratedPattern struct {
-	search   []SearchInstruction
-	validate []ProcInstruction
+	search    []SearchInstruction
+	validate  []ProcInstruction
+	maxLength int
+	spans     map[int]ast.Span
 }
 
 type GenState struct {
 	variables             map[string]int
 	globalSubroutines     map[string]GeneratedPattern
+	globalCalls           map[string]int
+	patternNames          map[string]bool
+	caseless              bool
 	globalVariables       map[string]int
 	globalTransformations map[string][]ProcInstruction
+	directory             string
+	spans                 map[int]ast.Span
 }
 
 func GenerateBytecode(a *ast.Ast) (*Bytecode, error) {
+	return GenerateBytecodeIn(a, "")
+}
+
+// GenerateBytecodeIn generates the bytecode for a program whose term list files are relative to directory
+func GenerateBytecodeIn(a *ast.Ast, directory string) (*Bytecode, error) {
 	bytecode := []Command{}
 	gen_state := &GenState{
 		globalSubroutines:     make(map[string]GeneratedPattern),
+		globalCalls:           make(map[string]int),
+		patternNames:          patternNames(a),
+		caseless:              a.Caseless(),
 		globalVariables:       make(map[string]int),
 		globalTransformations: make(map[string][]ProcInstruction),
+		directory:             directory,
 	}
 	for _, ast_comm := range a.Commands() {
 		byte_comm, gen_error := generateCommand(&ast_comm, gen_state)
@@ -40,6 +60,23 @@ func GenerateBytecode(a *ast.Ast) (*Bytecode, error) {
 	return &Bytecode{bytecode}, nil
 }
 
+// patternNames collects the names of every pattern that gets set so patterns can call patterns that are set after them
+func patternNames(a *ast.Ast) map[string]bool {
+	names := make(map[string]bool)
+	for _, ast_comm := range a.Commands() {
+		var icom any = ast_comm
+		set, ok := icom.(*ast.AstSet)
+		if !ok {
+			continue
+		}
+		var ibody any = set.Body
+		if _, ok := ibody.(*ast.AstSetPattern); ok {
+			names[set.Id] = true
+		}
+	}
+	return names
+}
+
 func generateCommand(com *ast.AstCommand, state *GenState) (Command, error) {
 	var icom any = *com
 	switch c := icom.(type) {
@@ -55,14 +92,18 @@ func generateCommand(com *ast.AstCommand, state *GenState) (Command, error) {
 
 func generateFindCommand(f *ast.AstFind, state *GenState) (Command, error) {
 	result := FindCommand{
-		All:  f.All,
-		Skip: f.Skip,
-		Take: f.Take,
-		Last: f.Last,
-		Body: []SearchInstruction{},
+		All:         f.All,
+		Skip:        f.Skip,
+		Take:        f.Take,
+		Last:        f.Last,
+		Overlapping: f.Overlapping,
+		Tree:        f.Tree,
+		Body:        []SearchInstruction{},
 	}
 
 	state.variables = make(map[string]int)
+	state.globalCalls = make(map[string]int)
+	state.spans = make(map[int]ast.Span)
 
 	offset := 0
 	for _, expr := range f.Body {
@@ -74,6 +115,13 @@ func generateFindCommand(f *ast.AstFind, state *GenState) (Command, error) {
 		result.Body = append(result.Body, expr_insts...)
 	}
 
+	body, gen_error := resolveCalls(result.Body, f, state)
+	if gen_error != nil {
+		return nil, gen_error
+	}
+	result.Body = body
+	result.Spans = commandSpans(body, f, state)
+
 	return result, nil
 }
 
@@ -88,6 +136,8 @@ func generateReplaceCommand(r *ast.AstReplace, state *GenState) (Command, error)
 	}
 
 	state.variables = make(map[string]int)
+	state.globalCalls = make(map[string]int)
+	state.spans = make(map[int]ast.Span)
 
 	offset := 0
 	for _, expr := range r.Body {
@@ -99,6 +149,13 @@ func generateReplaceCommand(r *ast.AstReplace, state *GenState) (Command, error)
 		result.Body = append(result.Body, expr_insts...)
 	}
 
+	body, gen_error := resolveCalls(result.Body, r, state)
+	if gen_error != nil {
+		return nil, gen_error
+	}
+	result.Body = body
+	result.Spans = commandSpans(body, r, state)
+
 	offset = 0
 	for _, expr := range r.Result {
 		expr_insts, gen_error := generateReplaceInstruction(&expr, offset, state)
@@ -168,10 +225,13 @@ func generateSetTransform(s ast.AstSetTransform, state *GenState, id string) (Se
 }
 
 func generateSetPattern(s ast.AstSetPattern, state *GenState, id string) (SetCommandBody, error) {
-	state.variables = make(map[string]int)
+	// the pattern starts after the StartSubroutine it gets wrapped in so the pattern can call itself
+	state.variables = map[string]int{id: 0}
+	state.globalCalls = make(map[string]int)
+	state.spans = make(map[int]ast.Span)
 
 	searchInstructions := []SearchInstruction{}
-	offset := 0
+	offset := 1
 	for _, val := range s.Pattern {
 		part, err := generateSearchInstruction(&val, offset, state)
 		if err != nil {
@@ -206,7 +266,7 @@ func generateSetPattern(s ast.AstSetPattern, state *GenState, id string) (SetCom
 	if err != nil {
 		return nil, err
 	}
-	state.globalSubroutines[id] = GeneratedPattern{searchInstructions, generatedInstructions}
+	state.globalSubroutines[id] = GeneratedPattern{searchInstructions, generatedInstructions, maxSequenceLength(s.Pattern, state), state.spans}
 
 	return &SetCommandExpression{
 		Instructions: searchInstructions,
@@ -225,18 +285,34 @@ func generateSetMatches(s ast.AstSetMatches, state *GenState, id string) (SetCom
 }
 
 func generateSearchInstruction(l *ast.AstExpression, offset int, state *GenState) ([]SearchInstruction, error) {
+	insts, err := generateExpression(l, offset, state)
+	if err == nil {
+		claimSpan(*l, offset, insts, state)
+	}
+	return insts, err
+}
+
+func generateExpression(l *ast.AstExpression, offset int, state *GenState) ([]SearchInstruction, error) {
 	var il any = *l
 	switch si := il.(type) {
 	case *ast.AstLoop:
 		return generateLoop(si, offset, state)
 	case *ast.AstBranch:
 		return generateBranch(si, offset, state)
+	case *ast.AstLookaround:
+		return generateLookaround(si, offset, state)
+	case *ast.AstConditional:
+		return generateConditional(si, offset, state)
+	case *ast.AstFuzzy:
+		return generateFuzzy(si, offset, state)
 	case *ast.AstDec:
 		return generateVarDec(si, offset, state)
 	case *ast.AstSub:
 		return generateSubroutine(si, offset, state)
 	case *ast.AstList:
 		return generateList(si, offset, state)
+	case *ast.AstTermList:
+		return generateTermList(si, offset, state)
 	case *ast.AstRange:
 		return generateRange(si, offset, state)
 	case *ast.AstPrimary:
@@ -246,6 +322,14 @@ func generateSearchInstruction(l *ast.AstExpression, offset int, state *GenState
 }
 
 func generateLoop(l *ast.AstLoop, offset int, state *GenState) ([]SearchInstruction, error) {
+	if l.Possessive {
+		loop := *l
+		loop.Possessive = false
+		return generateAtomic(func(offset int) ([]SearchInstruction, error) {
+			return generateLoop(&loop, offset, state)
+		}, offset)
+	}
+
 	result := []SearchInstruction{}
 
 	current_offset := offset
@@ -334,6 +418,182 @@ func generateBranch(l *ast.AstBranch, offset int, state *GenState) ([]SearchInst
 	return insts, nil
 }
 
+func generateLookaround(l *ast.AstLookaround, offset int, state *GenState) ([]SearchInstruction, error) {
+	body, gen_error := generateSearchInstruction(&l.Body, offset+1, state)
+	if gen_error != nil {
+		return []SearchInstruction{}, gen_error
+	}
+
+	maxLength := -1
+	if l.Behind {
+		// trying every place an unbounded body could start from walks back to the start of the file at every position
+		maxLength = maxExpressionLength(l.Body, state)
+		if maxLength == -1 {
+			return []SearchInstruction{}, NewGenError(*l, "the body of a lookbehind must have a maximum length")
+		}
+	}
+
+	insts := []SearchInstruction{StartLookaround{
+		Not:       l.Not,
+		Behind:    l.Behind,
+		MaxLength: maxLength,
+		EndPC:     offset + len(body) + 1,
+	}}
+	insts = append(insts, body...)
+	insts = append(insts, EndLookaround{
+		Not:    l.Not,
+		Behind: l.Behind,
+	})
+	return insts, nil
+}
+
+func generateConditional(l *ast.AstConditional, offset int, state *GenState) ([]SearchInstruction, error) {
+	then_insts, gen_error := generateSubExpression(&ast.AstSubExpr{Body: l.Then}, offset+1, state)
+	if gen_error != nil {
+		return []SearchInstruction{}, gen_error
+	}
+	else_offset := offset + len(then_insts) + 2
+	else_insts, gen_error := generateSubExpression(&ast.AstSubExpr{Body: l.Else}, else_offset, state)
+	if gen_error != nil {
+		return []SearchInstruction{}, gen_error
+	}
+
+	insts := []SearchInstruction{IfVariable{
+		Name:   l.Variable,
+		ElsePC: else_offset,
+	}}
+	insts = append(insts, then_insts...)
+	insts = append(insts, Jump{
+		NewProgramCounter: else_offset + len(else_insts),
+	})
+	insts = append(insts, else_insts...)
+	return insts, nil
+}
+
+func generateFuzzy(l *ast.AstFuzzy, offset int, state *GenState) ([]SearchInstruction, error) {
+	result := MatchFuzzy{
+		MaxEdits: l.MaxEdits,
+		Swaps:    l.Swaps,
+		Caseless: state.caseless,
+		Name:     l.Name,
+	}
+
+	var target any = l.Target
+	switch t := target.(type) {
+	case *ast.AstString:
+		result.ToFind = t.Value
+		result.Caseless = t.Caseless || state.caseless
+	case *ast.AstVariable:
+		if val, prs := state.variables[t.Name]; !prs || val != -1 {
+			return []SearchInstruction{}, NewGenError(*t, "fuzzy matches only work on strings and variables")
+		}
+		result.Variable = t.Name
+	default:
+		return []SearchInstruction{}, NewGenError(*l, "unknown fuzzy target")
+	}
+
+	if l.Name != "" {
+		if _, prs := state.variables[l.Name]; prs {
+			return []SearchInstruction{}, NewGenError(*l, "name clash")
+		}
+		state.variables[l.Name] = -1
+	}
+	return []SearchInstruction{result}, nil
+}
+
+// maxExpressionLength returns the most runes an expression can consume or -1 if it is unbounded
+func maxExpressionLength(l ast.AstExpression, state *GenState) int {
+	switch e := l.(type) {
+	case *ast.AstLoop:
+		body := maxExpressionLength(e.Body, state)
+		if e.Max == -1 || body == -1 {
+			return -1
+		}
+		return e.Max * body
+	case *ast.AstBranch:
+		left := maxLiteralLength(e.Left, state)
+		right := maxExpressionLength(e.Right, state)
+		if left == -1 || right == -1 {
+			return -1
+		}
+		if left > right {
+			return left
+		}
+		return right
+	case *ast.AstLookaround:
+		return 0
+	case *ast.AstConditional:
+		then_length := maxSequenceLength(e.Then, state)
+		else_length := maxSequenceLength(e.Else, state)
+		if then_length == -1 || else_length == -1 {
+			return -1
+		}
+		if then_length > else_length {
+			return then_length
+		}
+		return else_length
+	case *ast.AstFuzzy:
+		if s, ok := e.Target.(*ast.AstString); ok {
+			return s.GetMaxSize() + e.MaxEdits
+		}
+		return -1
+	case *ast.AstDec:
+		return maxLiteralLength(e.Body, state)
+	case *ast.AstSub:
+		return maxSequenceLength(e.Body, state)
+	case *ast.AstList:
+		return e.GetMaxSize()
+	case *ast.AstTermList:
+		terms, err := readTermList(e, state.directory)
+		if err != nil {
+			return -1
+		}
+		longest := 0
+		for _, term := range terms {
+			if length := utf8.RuneCountInString(term); length > longest {
+				longest = length
+			}
+		}
+		return longest
+	case *ast.AstPrimary:
+		return maxLiteralLength(e.Literal, state)
+	}
+	return -1
+}
+
+func maxLiteralLength(l ast.AstLiteral, state *GenState) int {
+	switch e := l.(type) {
+	case *ast.AstString:
+		return e.GetMaxSize()
+	case *ast.AstCharacterClass:
+		return e.GetMaxSize()
+	case *ast.AstUnicodeClass:
+		return e.GetMaxSize()
+	case *ast.AstNumberRange:
+		return e.GetMaxSize()
+	case *ast.AstSubExpr:
+		return maxSequenceLength(e.Body, state)
+	case *ast.AstVariable:
+		// only calls to patterns that are already set have a known length
+		if pattern, found := state.globalSubroutines[e.Name]; found && state.variables[e.Name] != -1 {
+			return pattern.maxLength
+		}
+	}
+	return -1
+}
+
+func maxSequenceLength(body []ast.AstExpression, state *GenState) int {
+	total := 0
+	for _, expr := range body {
+		length := maxExpressionLength(expr, state)
+		if length == -1 {
+			return -1
+		}
+		total += length
+	}
+	return total
+}
+
 func generateVarDec(l *ast.AstDec, offset int, state *GenState) ([]SearchInstruction, error) {
 	insts := []SearchInstruction{}
 	// offset
@@ -415,6 +675,8 @@ func generateListable(l *ast.AstListable, offset int, state *GenState) ([]Search
 		return generateCharacterClass(li, offset, state)
 	case *ast.AstRange:
 		return generateRange(li, offset, state)
+	case *ast.AstUnicodeClass:
+		return generateUnicodeClass(li, offset, state)
 	}
 	return nil, NewGenError(*l, "Unknown listable")
 }
@@ -453,9 +715,11 @@ func generate_not_not(l *ast.AstList, offset int, state *GenState) ([]SearchInst
 }
 
 func generate_not(l *ast.AstList, offset int, state *GenState) ([]SearchInstruction, error) {
-	insts := []SearchInstruction{}
+	insts := []SearchInstruction{StartNotInList{
+		MaxSize: l.GetMaxSize(),
+	}}
 
-	pc := offset
+	pc := offset + 1
 	for _, item := range l.Contents {
 		item_insts, err := generateListable(&item, pc+1, state)
 		if err != nil {
@@ -477,6 +741,42 @@ func generate_not(l *ast.AstList, offset int, state *GenState) ([]SearchInstruct
 }
 
 func generateLiteral(l *ast.AstLiteral, offset int, state *GenState) ([]SearchInstruction, error) {
+	insts, err := generateLiteralKind(l, offset, state)
+	if err == nil {
+		claimSpan(*l, offset, insts, state)
+	}
+	return insts, err
+}
+
+// claimSpan gives the span of node to the instructions it generated that don't have one yet. The nodes inside
+// of node finish generating first so every instruction keeps the span of the innermost node that generated it.
+func claimSpan(node ast.AstNode, offset int, insts []SearchInstruction, state *GenState) {
+	span := node.Span()
+	if span == (ast.Span{}) {
+		return
+	}
+	for pc := offset; pc < offset+len(insts); pc++ {
+		if _, found := state.spans[pc]; !found {
+			state.spans[pc] = span
+		}
+	}
+}
+
+// commandSpans lines up a span with every instruction of body. Instructions that no node claimed, like the
+// jump over the patterns copied to the end of the command, get the span of the whole command.
+func commandSpans(body []SearchInstruction, command ast.AstNode, state *GenState) []ast.Span {
+	spans := make([]ast.Span, len(body))
+	for pc := range body {
+		span, found := state.spans[pc]
+		if !found {
+			span = command.Span()
+		}
+		spans[pc] = span
+	}
+	return spans
+}
+
+func generateLiteralKind(l *ast.AstLiteral, offset int, state *GenState) ([]SearchInstruction, error) {
 	var il any = *l
 	switch ll := il.(type) {
 	case *ast.AstString:
@@ -487,6 +787,10 @@ func generateLiteral(l *ast.AstLiteral, offset int, state *GenState) ([]SearchIn
 		return generateVariable(ll, offset, state)
 	case *ast.AstCharacterClass:
 		return generateCharacterClass(ll, offset, state)
+	case *ast.AstUnicodeClass:
+		return generateUnicodeClass(ll, offset, state)
+	case *ast.AstNumberRange:
+		return generateNumberRange(ll, offset, state)
 	}
 	return nil, NewGenError(*l, "unkonwn literal type")
 }
@@ -495,11 +799,62 @@ func generatePrimary(l *ast.AstPrimary, offset int, state *GenState) ([]SearchIn
 	return generateLiteral(&l.Literal, offset, state)
 }
 
+// readTermList gets the terms of l loading them from its file if it has one.
+// A relative file path is relative to directory.
+func readTermList(l *ast.AstTermList, directory string) ([]string, error) {
+	if l.File == "" {
+		return l.Terms, nil
+	}
+	path := l.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(directory, path)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// one term per line and blank lines are skipped
+	terms := []string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if term := strings.TrimSuffix(line, "\r"); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms, nil
+}
+
+func generateTermList(l *ast.AstTermList, offset int, state *GenState) ([]SearchInstruction, error) {
+	terms, err := readTermList(l, state.directory)
+	if err != nil {
+		return []SearchInstruction{}, NewGenError(*l, "couldn't read the term list file: "+err.Error())
+	}
+
+	trie := ds.NewTrie()
+	if l.Caseless || state.caseless {
+		trie = ds.NewCaselessTrie()
+	}
+	for _, term := range terms {
+		if term != "" {
+			trie.Insert(term)
+		}
+	}
+	if trie.Size() == 0 {
+		return []SearchInstruction{}, NewGenError(*l, "the term list doesn't have any terms")
+	}
+
+	result := MatchTerms{
+		Terms:     trie,
+		WholeWord: l.WholeWord,
+	}
+	return []SearchInstruction{result}, nil
+}
+
 func generateRange(l *ast.AstRange, offset int, state *GenState) ([]SearchInstruction, error) {
 	result := MatchRange{
-		From: l.From.Value,
-		To:   l.To.Value,
-		Not:  false,
+		From:     l.From.Value,
+		To:       l.To.Value,
+		Not:      false,
+		Caseless: state.caseless,
 	}
 	return []SearchInstruction{result}, nil
 }
@@ -508,14 +863,39 @@ func generateString(l *ast.AstString, offset int, state *GenState) ([]SearchInst
 	result := MatchLiteral{
 		ToFind:   l.Value,
 		Not:      l.Not,
-		Caseless: l.Caseless,
+		Caseless: l.Caseless || state.caseless,
 	}
 	return []SearchInstruction{result}, nil
 }
 
+// generateAtomic surrounds the instructions generated by body so nothing in them is backtracked into once they all match
+func generateAtomic(body func(offset int) ([]SearchInstruction, error), offset int) ([]SearchInstruction, error) {
+	insts, gen_error := body(offset + 1)
+	if gen_error != nil {
+		return []SearchInstruction{}, gen_error
+	}
+	result := []SearchInstruction{StartAtomic{}}
+	result = append(result, insts...)
+	result = append(result, EndAtomic{})
+	return result, nil
+}
+
 func generateSubExpression(l *ast.AstSubExpr, offset int, state *GenState) ([]SearchInstruction, error) {
+	if l.Atomic {
+		sub_expr := *l
+		sub_expr.Atomic = false
+		return generateAtomic(func(offset int) ([]SearchInstruction, error) {
+			return generateSubExpression(&sub_expr, offset, state)
+		}, offset)
+	}
+
 	result := []SearchInstruction{}
 
+	if l.Caseless && !state.caseless {
+		state.caseless = true
+		defer func() { state.caseless = false }()
+	}
+
 	loffset := offset
 	for _, expr := range l.Body {
 		expr_insts, gen_error := generateSearchInstruction(&expr, loffset, state)
@@ -531,50 +911,52 @@ func generateSubExpression(l *ast.AstSubExpr, offset int, state *GenState) ([]Se
 
 func generateVariable(l *ast.AstVariable, offset int, state *GenState) ([]SearchInstruction, error) {
 	val, prs := state.variables[l.Name]
-	if !prs {
-		// we don't have a variable check the subroutines
-		globalSub, globalPrs := state.globalSubroutines[l.Name]
-		if !globalPrs {
-			return []SearchInstruction{}, NewGenError(*l, "undefined identifier")
-		}
-
-		state.variables[l.Name] = offset
+	if prs && val == -1 {
+		return []SearchInstruction{MatchVariable{
+			Name:     l.Name,
+			Caseless: state.caseless,
+		}}, nil
+	}
 
-		bodyinsts := []SearchInstruction{}
-		loffset := offset + 1
-		for _, expr := range globalSub.search {
-			inst := expr.adjust(offset+1, state)
-			loffset += 1
-			bodyinsts = append(bodyinsts, inst)
-		}
+	// a pattern that was set gets a copy for calls that ignore case and one for calls that don't
+	if to, found := state.globalCalls[callKey(l.Name, state.caseless)]; found {
+		return []SearchInstruction{CallSubroutine{Name: l.Name, ToPC: to, Caseless: state.caseless}}, nil
+	}
+	if _, inlined := state.globalCalls[callKey(l.Name, !state.caseless)]; prs && !inlined {
+		return []SearchInstruction{CallSubroutine{Name: l.Name, ToPC: val}}, nil
+	}
 
-		jumpToSubroutine := StartSubroutine{
-			Id:        offset,
-			Name:      l.Name,
-			EndOffset: loffset,
+	// we don't have a variable check the subroutines
+	globalSub, globalPrs := state.globalSubroutines[l.Name]
+	if !globalPrs {
+		if state.patternNames[l.Name] {
+			// the pattern is set later on so we point the call at it once we know where it ends up
+			return []SearchInstruction{CallSubroutine{Name: l.Name, ToPC: unresolvedCall, Caseless: state.caseless}}, nil
 		}
+		return []SearchInstruction{}, NewGenError(*l, "undefined identifier")
+	}
 
-		returnFromSubroutine := EndSubroutine{
-			Name:     l.Name,
-			Validate: globalSub.validate,
-		}
+	if !prs {
+		state.variables[l.Name] = offset
+	}
+	return inlinePattern(l.Name, globalSub, offset, state), nil
+}
 
-		insts := []SearchInstruction{}
-		insts = append(insts, jumpToSubroutine)
-		insts = append(insts, bodyinsts...)
-		insts = append(insts, returnFromSubroutine)
-		return insts, nil
+// callKey is the key in globalCalls of the copy of a pattern that a call goes to
+func callKey(name string, caseless bool) string {
+	if caseless {
+		return name + " caseless"
 	}
-	var result SearchInstruction
-	if val == -1 {
-		result = MatchVariable{
-			Name: l.Name,
-		}
-	} else {
-		result = CallSubroutine{
-			Name: l.Name,
-			ToPC: val,
-		}
+	return name
+}
+
+func generateNumberRange(l *ast.AstNumberRange, offset int, state *GenState) ([]SearchInstruction, error) {
+	result := MatchNumber{
+		Min:          l.Min,
+		Max:          l.Max,
+		LeadingZeros: l.LeadingZeros,
+		Signs:        l.Signs,
+		Decimals:     l.Decimals,
 	}
 	return []SearchInstruction{result}, nil
 }
@@ -587,6 +969,18 @@ func generateCharacterClass(l *ast.AstCharacterClass, offset int, state *GenStat
 	return []SearchInstruction{result}, nil
 }
 
+func generateUnicodeClass(l *ast.AstUnicodeClass, offset int, state *GenState) ([]SearchInstruction, error) {
+	if _, found := ast.UnicodeTable(l.ClassType, l.Name); !found {
+		return []SearchInstruction{}, NewGenError(*l, "unknown unicode class")
+	}
+	result := MatchUnicodeClass{
+		Class: l.ClassType,
+		Name:  l.Name,
+		Not:   l.Not,
+	}
+	return []SearchInstruction{result}, nil
+}
+
 func generateReplaceInstruction(l *ast.AstAtom, offset int, state *GenState) ([]ReplaceInstruction, error) {
 	var il any = *l
 	switch ri := il.(type) {
@@ -619,3 +1013,59 @@ func generateReplaceVariable(l *ast.AstVariable, offset int, state *GenState) ([
 	}
 	return []ReplaceInstruction{result}, nil
 }
+
+// inlinePattern copies a pattern from a set command into the command at offset wrapped in a subroutine
+func inlinePattern(name string, pattern GeneratedPattern, offset int, state *GenState) []SearchInstruction {
+	state.globalCalls[callKey(name, state.caseless)] = offset
+	// the pattern was generated starting at 1 so its spans move over the same as its instructions
+	for pc, span := range pattern.spans {
+		state.spans[pc+offset] = span
+	}
+
+	insts := []SearchInstruction{StartSubroutine{
+		Id:        offset,
+		Name:      name,
+		EndOffset: offset + len(pattern.search) + 1,
+	}}
+	for _, inst := range pattern.search {
+		insts = append(insts, inst.adjust(offset, state))
+	}
+	insts = append(insts, EndSubroutine{
+		Name:     name,
+		Validate: pattern.validate,
+	})
+	return insts
+}
+
+// resolveCalls points the calls to patterns that were set after the pattern making the call at their subroutine.
+// A pattern that the command never used directly gets copied after the end of the command with a jump over it.
+func resolveCalls(body []SearchInstruction, command ast.AstNode, state *GenState) ([]SearchInstruction, error) {
+	skip := -1
+	for pc := 0; pc < len(body); pc++ {
+		call, ok := body[pc].(CallSubroutine)
+		if !ok || call.ToPC != unresolvedCall {
+			continue
+		}
+		key := callKey(call.Name, call.Caseless)
+		if _, found := state.globalCalls[key]; !found {
+			pattern, globalPrs := state.globalSubroutines[call.Name]
+			if !globalPrs {
+				return nil, NewGenError(command, "pattern '"+call.Name+"' is used before it is set")
+			}
+			if skip == -1 {
+				skip = len(body)
+				body = append(body, Jump{})
+			}
+			caseless := state.caseless
+			state.caseless = call.Caseless
+			body = append(body, inlinePattern(call.Name, pattern, len(body), state)...)
+			state.caseless = caseless
+		}
+		call.ToPC = state.globalCalls[key]
+		body[pc] = call
+	}
+	if skip != -1 {
+		body[skip] = Jump{NewProgramCounter: len(body)}
+	}
+	return body, nil
+}
@@ -16,7 +16,15 @@ func (g *GenError) Error() string {
 }
 
 func (g *GenError) Message() string {
-	return g.Error()
+	return g.message
+}
+
+func (g *GenError) Span() ast.Span {
+	return g.astNode.Span()
+}
+
+func (g *GenError) Code() string {
+	return "GenError"
 }
 
 func NewGenError(node ast.AstNode, msg string) *GenError {
@@ -11,10 +11,22 @@ type SemanticError struct {
 	message string
 }
 
-func (s SemanticError) Error() string {
+func (s *SemanticError) Error() string {
 	return fmt.Sprintf("SemanticError: %s at %s", s.message, s.astNode.NodeString())
 }
 
+func (s *SemanticError) Message() string {
+	return s.message
+}
+
+func (s *SemanticError) Span() ast.Span {
+	return s.astNode.Span()
+}
+
+func (s *SemanticError) Code() string {
+	return "SemanticError"
+}
+
 func NewSemanticError(node ast.AstNode, message string) *SemanticError {
 	return &SemanticError{node, message}
 }
@@ -241,6 +241,10 @@ func (v MapValue) String() string {
 	return string(bytes)
 }
 
+func (v MapValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Any())
+}
+
 func (v MapValue) Number() int {
 	return 0
 }
@@ -0,0 +1,130 @@
+package ds
+
+type Ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 | ~string
+}
+
+// PersistentMap is an immutable map backed by a balanced binary tree. Set returns a new
+// map that shares everything but the path to the changed key with the original.
+type PersistentMap[K Ordered, V any] struct {
+	root *persistentMapNode[K, V]
+	size int
+}
+
+type persistentMapNode[K Ordered, V any] struct {
+	key    K
+	value  V
+	height int
+	left   *persistentMapNode[K, V]
+	right  *persistentMapNode[K, V]
+}
+
+func NewPersistentMap[K Ordered, V any]() PersistentMap[K, V] {
+	return PersistentMap[K, V]{}
+}
+
+func (m PersistentMap[K, V]) Get(key K) (V, bool) {
+	node := m.root
+	for node != nil {
+		if key < node.key {
+			node = node.left
+		} else if key > node.key {
+			node = node.right
+		} else {
+			return node.value, true
+		}
+	}
+	var empty V
+	return empty, false
+}
+
+func (m PersistentMap[K, V]) Set(key K, value V) PersistentMap[K, V] {
+	root, added := m.root.set(key, value)
+	if added {
+		return PersistentMap[K, V]{root, m.size + 1}
+	}
+	return PersistentMap[K, V]{root, m.size}
+}
+
+func (m PersistentMap[K, V]) Size() int {
+	return m.size
+}
+
+func (m PersistentMap[K, V]) IsEmpty() bool {
+	return m.size == 0
+}
+
+// Entries returns the key value pairs ordered by key
+func (m PersistentMap[K, V]) Entries() []Pair[K, V] {
+	result := make([]Pair[K, V], 0, m.size)
+	var walk func(node *persistentMapNode[K, V])
+	walk = func(node *persistentMapNode[K, V]) {
+		if node == nil {
+			return
+		}
+		walk(node.left)
+		result = append(result, NewPair(node.key, node.value))
+		walk(node.right)
+	}
+	walk(m.root)
+	return result
+}
+
+func (n *persistentMapNode[K, V]) getHeight() int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func (n *persistentMapNode[K, V]) set(key K, value V) (*persistentMapNode[K, V], bool) {
+	if n == nil {
+		return &persistentMapNode[K, V]{key: key, value: value, height: 1}, true
+	}
+
+	result := *n
+	added := false
+	if key < n.key {
+		result.left, added = n.left.set(key, value)
+	} else if key > n.key {
+		result.right, added = n.right.set(key, value)
+	} else {
+		result.value = value
+		return &result, false
+	}
+	return result.balance(), added
+}
+
+func (n *persistentMapNode[K, V]) withChildren(left *persistentMapNode[K, V], right *persistentMapNode[K, V]) *persistentMapNode[K, V] {
+	result := *n
+	result.left = left
+	result.right = right
+	result.height = left.getHeight() + 1
+	if right.getHeight() >= left.getHeight() {
+		result.height = right.getHeight() + 1
+	}
+	return &result
+}
+
+// balance fixes up a freshly copied node whose children may differ in height by two
+func (n *persistentMapNode[K, V]) balance() *persistentMapNode[K, V] {
+	left := n.left.getHeight()
+	right := n.right.getHeight()
+	if left > right+1 {
+		l := n.left
+		if l.right.getHeight() > l.left.getHeight() {
+			l = l.right.withChildren(l.withChildren(l.left, l.right.left), l.right.right)
+		}
+		return l.withChildren(l.left, n.withChildren(l.right, n.right))
+	}
+	if right > left+1 {
+		r := n.right
+		if r.left.getHeight() > r.right.getHeight() {
+			r = r.left.withChildren(r.left.left, r.withChildren(r.left.right, r.right))
+		}
+		return r.withChildren(n.withChildren(n.left, r.left), r.right)
+	}
+	return n.withChildren(n.left, n.right)
+}
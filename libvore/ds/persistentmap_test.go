@@ -0,0 +1,69 @@
+package ds
+
+import (
+	"testing"
+
+	"github.com/jmeaster30/vore/libvore/testutils"
+)
+
+func TestPersistentMapGetSet(t *testing.T) {
+	m := NewPersistentMap[string, int]().Set("a", 1).Set("b", 2)
+
+	value, found := m.Get("a")
+	testutils.AssertTrue(t, found)
+	testutils.AssertEqual(t, 1, value)
+
+	_, found = m.Get("c")
+	testutils.AssertFalse(t, found)
+	testutils.AssertEqual(t, 2, m.Size())
+}
+
+func TestPersistentMapOverwrite(t *testing.T) {
+	original := NewPersistentMap[string, int]().Set("a", 1)
+	updated := original.Set("a", 5)
+
+	value, _ := original.Get("a")
+	testutils.AssertEqual(t, 1, value)
+	value, _ = updated.Get("a")
+	testutils.AssertEqual(t, 5, value)
+	testutils.AssertEqual(t, 1, updated.Size())
+}
+
+func TestPersistentMapKeepsOldVersions(t *testing.T) {
+	versions := []PersistentMap[int, int]{NewPersistentMap[int, int]()}
+	for i := 0; i < 100; i++ {
+		versions = append(versions, versions[i].Set((i*37)%100, i))
+	}
+
+	for i, version := range versions {
+		testutils.AssertEqual(t, i, version.Size())
+		for j := 0; j < 100; j++ {
+			value, found := version.Get((j * 37) % 100)
+			testutils.AssertEqual(t, j < i, found)
+			if found {
+				testutils.AssertEqual(t, j, value)
+			}
+		}
+	}
+}
+
+func TestPersistentMapEntriesAreOrdered(t *testing.T) {
+	m := NewPersistentMap[int, string]()
+	for _, key := range []int{5, 3, 8, 1, 4, 7, 9, 2, 6} {
+		m = m.Set(key, "")
+	}
+
+	keys := []int{}
+	for _, entry := range m.Entries() {
+		keys = append(keys, entry.Left())
+	}
+	testutils.AssertEqual(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}, keys)
+}
+
+func TestPersistentMapStaysBalanced(t *testing.T) {
+	m := NewPersistentMap[int, int]()
+	for i := 0; i < 1024; i++ {
+		m = m.Set(i, i)
+	}
+	testutils.AssertTrue(t, m.root.getHeight() <= 11)
+}
@@ -0,0 +1,62 @@
+package ds
+
+// PersistentStack is an immutable stack. Push and Pop return a new stack and leave the
+// original untouched so holding onto an old version is as cheap as copying the struct.
+type PersistentStack[T any] struct {
+	DefaultIndexable[T]
+	top  *persistentStackNode[T]
+	size int
+}
+
+type persistentStackNode[T any] struct {
+	value T
+	next  *persistentStackNode[T]
+}
+
+func NewPersistentStack[T any]() PersistentStack[T] {
+	return PersistentStack[T]{}
+}
+
+func (s PersistentStack[T]) Peek() Optional[T] {
+	if s.IsEmpty() {
+		return None[T]()
+	}
+	return Some(s.top.value)
+}
+
+func (s PersistentStack[T]) Push(value T) PersistentStack[T] {
+	return PersistentStack[T]{
+		top:  &persistentStackNode[T]{value, s.top},
+		size: s.size + 1,
+	}
+}
+
+// Pop returns the stack without its top value. Popping an empty stack gives back the empty stack.
+func (s PersistentStack[T]) Pop() PersistentStack[T] {
+	if s.IsEmpty() {
+		return s
+	}
+	return PersistentStack[T]{
+		top:  s.top.next,
+		size: s.size - 1,
+	}
+}
+
+func (s PersistentStack[T]) Index(index int) Optional[T] {
+	if index < 0 || index >= s.size {
+		return None[T]()
+	}
+	node := s.top
+	for ; index > 0; index-- {
+		node = node.next
+	}
+	return Some(node.value)
+}
+
+func (s PersistentStack[T]) IsEmpty() bool {
+	return s.size == 0
+}
+
+func (s PersistentStack[T]) Size() int {
+	return s.size
+}
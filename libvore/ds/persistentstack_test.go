@@ -0,0 +1,49 @@
+package ds
+
+import (
+	"testing"
+
+	"github.com/jmeaster30/vore/libvore/testutils"
+)
+
+func TestPersistentStackPush(t *testing.T) {
+	stack := NewPersistentStack[int]().Push(1).Push(2).Push(3)
+
+	testutils.AssertEqual(t, 3, stack.Size())
+	testutils.AssertEqual(t, Some(3), stack.Peek())
+}
+
+func TestPersistentStackPop(t *testing.T) {
+	stack := NewPersistentStack[int]().Push(1).Push(2)
+
+	stack = stack.Pop()
+	testutils.AssertEqual(t, Some(1), stack.Peek())
+	stack = stack.Pop()
+	testutils.AssertTrue(t, stack.IsEmpty())
+	testutils.AssertEqual(t, None[int](), stack.Peek())
+	stack = stack.Pop()
+	testutils.AssertEqual(t, 0, stack.Size())
+}
+
+func TestPersistentStackKeepsOldVersions(t *testing.T) {
+	original := NewPersistentStack[int]().Push(1).Push(2)
+	pushed := original.Push(3)
+	popped := original.Pop()
+
+	testutils.AssertEqual(t, 2, original.Size())
+	testutils.AssertEqual(t, Some(2), original.Peek())
+	testutils.AssertEqual(t, Some(3), pushed.Peek())
+	testutils.AssertEqual(t, Some(1), popped.Peek())
+}
+
+func TestPersistentStackIndex(t *testing.T) {
+	stack := NewPersistentStack[int]()
+	testutils.AssertEqual(t, None[int](), stack.Index(0))
+
+	stack = stack.Push(1).Push(2).Push(3)
+	testutils.AssertEqual(t, Some(3), stack.Index(0))
+	testutils.AssertEqual(t, Some(1), stack.Index(2))
+	testutils.AssertEqual(t, None[int](), stack.Index(3))
+	testutils.AssertEqual(t, None[int](), stack.Index(-1))
+	testutils.AssertEqual(t, []int{2, 1}, Subslice[int](stack, 1, 5))
+}
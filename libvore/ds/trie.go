@@ -0,0 +1,121 @@
+package ds
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Trie is a set of strings stored rune by rune so every string in the set that some text
+// starts with can be found in a single pass over the text.
+type Trie struct {
+	root     *trieNode
+	size     int
+	longest  int
+	caseless bool
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	end      bool
+}
+
+func NewTrie() *Trie {
+	return &Trie{root: &trieNode{children: map[rune]*trieNode{}}}
+}
+
+// NewCaselessTrie makes a trie that ignores case using Unicode simple case folding
+func NewCaselessTrie() *Trie {
+	trie := NewTrie()
+	trie.caseless = true
+	return trie
+}
+
+// fold maps every rune that is the same letter ignoring case to the same rune
+func (t *Trie) fold(r rune) rune {
+	if !t.caseless {
+		return r
+	}
+	folded := r
+	for other := unicode.SimpleFold(r); other != r; other = unicode.SimpleFold(other) {
+		if other < folded {
+			folded = other
+		}
+	}
+	return folded
+}
+
+// Caseless returns a copy of the trie that ignores case
+func (t *Trie) Caseless() *Trie {
+	if t.caseless {
+		return t
+	}
+	trie := NewCaselessTrie()
+	var walk func(node *trieNode, prefix []rune)
+	walk = func(node *trieNode, prefix []rune) {
+		if node.end {
+			trie.Insert(string(prefix))
+		}
+		for r, child := range node.children {
+			walk(child, append(prefix, r))
+		}
+	}
+	walk(t.root, []rune{})
+	return trie
+}
+
+func (t *Trie) Insert(value string) {
+	node := t.root
+	for _, c := range value {
+		r := t.fold(c)
+		child, found := node.children[r]
+		if !found {
+			child = &trieNode{children: map[rune]*trieNode{}}
+			node.children[r] = child
+		}
+		node = child
+	}
+	if node.end {
+		return
+	}
+	node.end = true
+	t.size += 1
+	if length := utf8.RuneCountInString(value); length > t.longest {
+		t.longest = length
+	}
+}
+
+func (t *Trie) Contains(value string) bool {
+	lengths := t.Prefixes(value)
+	return len(lengths) > 0 && lengths[len(lengths)-1] == utf8.RuneCountInString(value)
+}
+
+// Prefixes returns the length in runes of every string in the trie that text starts with, shortest first
+func (t *Trie) Prefixes(text string) []int {
+	lengths := []int{}
+	node := t.root
+	if node.end {
+		lengths = append(lengths, 0)
+	}
+	length := 0
+	for _, r := range text {
+		child, found := node.children[t.fold(r)]
+		if !found {
+			break
+		}
+		node = child
+		length += 1
+		if node.end {
+			lengths = append(lengths, length)
+		}
+	}
+	return lengths
+}
+
+func (t *Trie) Size() int {
+	return t.size
+}
+
+// Longest is the most runes in any string in the trie
+func (t *Trie) Longest() int {
+	return t.longest
+}
@@ -0,0 +1,92 @@
+package ds
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrieInsert(t *testing.T) {
+	trie := NewTrie()
+	trie.Insert("car")
+	trie.Insert("cart")
+	trie.Insert("car")
+	trie.Insert("dog")
+
+	if trie.Size() != 3 {
+		t.Errorf("The trie was expected to have 3 strings but actually had %d :(", trie.Size())
+	}
+
+	if trie.Longest() != 4 {
+		t.Errorf("The longest string was expected to be 4 runes but actually was %d :(", trie.Longest())
+	}
+}
+
+func TestTrieContains(t *testing.T) {
+	trie := NewTrie()
+	trie.Insert("car")
+	trie.Insert("cart")
+
+	if !trie.Contains("car") || !trie.Contains("cart") {
+		t.Errorf("The trie was expected to contain 'car' and 'cart' :(")
+	}
+
+	if trie.Contains("ca") || trie.Contains("carts") || trie.Contains("") {
+		t.Errorf("The trie was not expected to contain 'ca', 'carts' or '' :(")
+	}
+}
+
+func TestCaselessTrie(t *testing.T) {
+	trie := NewCaselessTrie()
+	trie.Insert("Straße")
+	trie.Insert("σ")
+
+	if !trie.Contains("STRAẞE") || !trie.Contains("straße") {
+		t.Errorf("The trie was expected to contain 'STRAẞE' and 'straße' :(")
+	}
+
+	if !trie.Contains("Σ") || !trie.Contains("ς") {
+		t.Errorf("The trie was expected to contain 'Σ' and 'ς' :(")
+	}
+}
+
+func TestTrieCaseless(t *testing.T) {
+	trie := NewTrie()
+	trie.Insert("car")
+	trie.Insert("Cart")
+
+	caseless := trie.Caseless()
+	if !caseless.Contains("CAR") || !caseless.Contains("cart") {
+		t.Errorf("The caseless copy was expected to contain 'CAR' and 'cart' :(")
+	}
+
+	if caseless.Size() != 2 || caseless.Longest() != 4 {
+		t.Errorf("The caseless copy was expected to have 2 strings and a longest of 4 but actually had %d and %d :(", caseless.Size(), caseless.Longest())
+	}
+
+	if trie.Contains("CAR") {
+		t.Errorf("The original trie was not expected to contain 'CAR' :(")
+	}
+}
+
+func TestTriePrefixes(t *testing.T) {
+	trie := NewTrie()
+	trie.Insert("c")
+	trie.Insert("car")
+	trie.Insert("cart")
+	trie.Insert("ça")
+
+	lengths := trie.Prefixes("carton")
+	if !reflect.DeepEqual(lengths, []int{1, 3, 4}) {
+		t.Errorf("The prefixes were expected to be [1 3 4] but actually were %v :(", lengths)
+	}
+
+	lengths = trie.Prefixes("çart")
+	if !reflect.DeepEqual(lengths, []int{2}) {
+		t.Errorf("The prefixes were expected to be [2] but actually were %v :(", lengths)
+	}
+
+	lengths = trie.Prefixes("dog")
+	if len(lengths) != 0 {
+		t.Errorf("There were not supposed to be any prefixes but there were %v :(", lengths)
+	}
+}
@@ -0,0 +1,551 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jmeaster30/vore/libvore/ast"
+	"github.com/jmeaster30/vore/libvore/bytecode"
+	"github.com/jmeaster30/vore/libvore/ds"
+	"github.com/jmeaster30/vore/libvore/files"
+)
+
+// Search instructions that never need the backtracker (no variables, subroutines,
+// lookaround or not-in lists) are lowered to a Thompson NFA and run as a Pike VM.
+// Threads are kept in priority order so the VM picks the same match the backtracker
+// would while reading each rune of the file only once.
+
+type nfaOp int
+
+const (
+	nfaRune nfaOp = iota
+	nfaSplit
+	nfaJump
+	nfaAssert
+	nfaFail
+	nfaMatch
+)
+
+type nfaInst struct {
+	op     nfaOp
+	accept func(r rune, raw string) bool
+	assert ast.AstCharacterClassType
+	not    bool
+	x      int
+	y      int
+}
+
+type automaton struct {
+	insts []nfaInst
+}
+
+// the biggest automaton we will build before leaving the program to the backtracker
+const maxAutomatonSize = 10000
+
+type lowering struct {
+	source []bytecode.SearchInstruction
+	insts  []nfaInst
+}
+
+func lowerToAutomaton(insts []bytecode.SearchInstruction) (*automaton, bool) {
+	l := &lowering{source: insts}
+	if _, ok := l.lower(0, len(insts)); !ok {
+		return nil, false
+	}
+	l.emit(nfaInst{op: nfaMatch})
+	return &automaton{l.insts}, true
+}
+
+func (l *lowering) emit(inst nfaInst) int {
+	l.insts = append(l.insts, inst)
+	return len(l.insts) - 1
+}
+
+// lower emits the search instructions in [start, end) and returns the fewest runes they can match
+func (l *lowering) lower(start int, end int) (int, bool) {
+	width := 0
+	pc := start
+	for pc < end {
+		w, next, ok := l.lowerInstruction(pc)
+		if !ok || next > end || len(l.insts) > maxAutomatonSize {
+			return 0, false
+		}
+		width += w
+		pc = next
+	}
+	return width, true
+}
+
+func (l *lowering) lowerInstruction(pc int) (int, int, bool) {
+	var ii any = l.source[pc]
+	switch i := ii.(type) {
+	case bytecode.MatchLiteral:
+		return l.lowerLiteral(i, pc)
+	case bytecode.MatchCharClass:
+		return l.lowerCharClass(i, pc)
+	case bytecode.MatchUnicodeClass:
+		table, found := ast.UnicodeTable(i.Class, i.Name)
+		if !found {
+			return 0, 0, false
+		}
+		l.emitRune(func(r rune) bool { return unicode.Is(table, r) }, i.Not)
+		return 1, pc + 1, true
+	case bytecode.MatchRange:
+		if utf8.RuneCountInString(i.From) != 1 || utf8.RuneCountInString(i.To) != 1 {
+			return 0, 0, false
+		}
+		from, to, not, caseless := i.From, i.To, i.Not, i.Caseless
+		l.emit(nfaInst{op: nfaRune, accept: func(r rune, raw string) bool {
+			return inRange(from, to, raw, caseless) != not
+		}})
+		return 1, pc + 1, true
+	case bytecode.Branch:
+		return l.lowerBranch(i, pc)
+	case bytecode.StartLoop:
+		return l.lowerLoop(i, pc)
+	}
+	return 0, 0, false
+}
+
+func (l *lowering) emitRune(predicate func(rune) bool, not bool) {
+	l.emit(nfaInst{op: nfaRune, accept: func(r rune, raw string) bool {
+		return predicate(r) != not
+	}})
+}
+
+func (l *lowering) lowerLiteral(i bytecode.MatchLiteral, pc int) (int, int, bool) {
+	if i.Not {
+		return 0, 0, false
+	}
+	if len(i.ToFind) == 0 {
+		// matching an empty literal always backtracks
+		l.emit(nfaInst{op: nfaFail})
+		return 0, pc + 1, true
+	}
+	width := 0
+	for _, c := range i.ToFind {
+		expected := string(c)
+		if i.Caseless {
+			l.emit(nfaInst{op: nfaRune, accept: func(r rune, raw string) bool {
+				return strings.EqualFold(expected, raw)
+			}})
+		} else {
+			l.emit(nfaInst{op: nfaRune, accept: func(r rune, raw string) bool {
+				return expected == raw
+			}})
+		}
+		width += 1
+	}
+	return width, pc + 1, true
+}
+
+func (l *lowering) lowerCharClass(i bytecode.MatchCharClass, pc int) (int, int, bool) {
+	switch i.Class {
+	case ast.ClassAny:
+		if i.Not {
+			l.emit(nfaInst{op: nfaFail})
+			return 0, pc + 1, true
+		}
+		l.emitRune(func(r rune) bool { return true }, false)
+	case ast.ClassWhitespace:
+		l.emitRune(func(r rune) bool { return r == ' ' || r == '\t' || r == '\n' || r == '\r' }, i.Not)
+	case ast.ClassDigit:
+		l.emitRune(IsDigit, i.Not)
+	case ast.ClassUpper:
+		l.emitRune(unicode.IsUpper, i.Not)
+	case ast.ClassLower:
+		l.emitRune(unicode.IsLower, i.Not)
+	case ast.ClassLetter:
+		l.emitRune(IsLetter, i.Not)
+	case ast.ClassWord:
+		l.emitRune(IsWordCharacter, i.Not)
+	case ast.ClassFileStart, ast.ClassFileEnd, ast.ClassLineStart, ast.ClassLineEnd, ast.ClassWordStart, ast.ClassWordEnd:
+		l.emit(nfaInst{op: nfaAssert, assert: i.Class, not: i.Not})
+		return 0, pc + 1, true
+	default:
+		return 0, 0, false
+	}
+	return 1, pc + 1, true
+}
+
+func (l *lowering) lowerBranch(i bytecode.Branch, pc int) (int, int, bool) {
+	bodies, end, ok := branchLayout(l.source, i, pc)
+	if !ok {
+		return 0, 0, false
+	}
+
+	width := -1
+	exits := []int{}
+	for k, body := range bodies {
+		split := -1
+		if k+1 < len(bodies) {
+			split = l.emit(nfaInst{op: nfaSplit})
+			l.insts[split].x = len(l.insts)
+		}
+		w, ok := l.lower(body.Start, body.End)
+		if !ok {
+			return 0, 0, false
+		}
+		if width == -1 || w < width {
+			width = w
+		}
+		if split != -1 {
+			exits = append(exits, l.emit(nfaInst{op: nfaJump}))
+			l.insts[split].y = len(l.insts)
+		}
+	}
+	for _, exit := range exits {
+		l.insts[exit].x = len(l.insts)
+	}
+	return width, end, true
+}
+
+// branchLayout finds the instructions of each alternative of the branch at pc and the pc after the whole branch.
+// A branch is laid out as its alternatives one after another, each followed by a jump to the end.
+func branchLayout(insts []bytecode.SearchInstruction, i bytecode.Branch, pc int) ([]ds.Range, int, bool) {
+	if len(i.Branches) == 0 || i.Branches[0] != pc+1 {
+		return nil, 0, false
+	}
+	// only lists make a branch with a single alternative and that alternative is always one instruction
+	endJump := pc + 2
+	if len(i.Branches) > 1 {
+		endJump = i.Branches[1] - 1
+	}
+	if endJump >= len(insts) {
+		return nil, 0, false
+	}
+	jump, isJump := insts[endJump].(bytecode.Jump)
+	if !isJump {
+		return nil, 0, false
+	}
+	end := jump.NewProgramCounter
+
+	bodies := []ds.Range{}
+	for k := range i.Branches {
+		bodyEnd := end - 1
+		if k+1 < len(i.Branches) {
+			bodyEnd = i.Branches[k+1] - 1
+		}
+		if bodyEnd < i.Branches[k] || bodyEnd >= len(insts) {
+			return nil, 0, false
+		}
+		if j, ok := insts[bodyEnd].(bytecode.Jump); !ok || j.NewProgramCounter != end {
+			return nil, 0, false
+		}
+		bodies = append(bodies, *ds.NewRange(i.Branches[k], bodyEnd))
+	}
+	return bodies, end, true
+}
+
+func (l *lowering) lowerLoop(i bytecode.StartLoop, pc int) (int, int, bool) {
+	if i.Name != "" || i.ExitLoop >= len(l.source) {
+		return 0, 0, false
+	}
+	if stop, ok := l.source[i.ExitLoop].(bytecode.StopLoop); !ok || stop.StartLoop != pc {
+		return 0, 0, false
+	}
+
+	// the backtracker refuses loop iterations that match nothing so only take bodies that always consume something
+	width := 0
+	for k := 0; k < i.MinLoops; k++ {
+		w, ok := l.lower(pc+1, i.ExitLoop)
+		if !ok || w == 0 {
+			return 0, 0, false
+		}
+		width += w
+	}
+
+	split := func() int {
+		return l.emit(nfaInst{op: nfaSplit})
+	}
+	// body and exit are the two sides of a split in priority order
+	setSplit := func(s int, body int, exit int) {
+		if i.Fewest {
+			l.insts[s].x, l.insts[s].y = exit, body
+		} else {
+			l.insts[s].x, l.insts[s].y = body, exit
+		}
+	}
+
+	if i.MaxLoops == -1 {
+		s := split()
+		w, ok := l.lower(pc+1, i.ExitLoop)
+		if !ok || w == 0 {
+			return 0, 0, false
+		}
+		l.emit(nfaInst{op: nfaJump, x: s})
+		setSplit(s, s+1, len(l.insts))
+		return width, i.ExitLoop + 1, true
+	}
+
+	splits := []int{}
+	for k := i.MinLoops; k < i.MaxLoops; k++ {
+		s := split()
+		splits = append(splits, s)
+		w, ok := l.lower(pc+1, i.ExitLoop)
+		if !ok || w == 0 {
+			return 0, 0, false
+		}
+	}
+	for _, s := range splits {
+		setSplit(s, s+1, len(l.insts))
+	}
+	return width, i.ExitLoop + 1, true
+}
+
+type nfaThread struct {
+	pc    int
+	start int
+}
+
+type threadList struct {
+	threads []nfaThread
+	visited []int
+	onList  []bool
+}
+
+func newThreadList(size int) *threadList {
+	return &threadList{onList: make([]bool, size)}
+}
+
+func (tl *threadList) clear() {
+	for _, pc := range tl.visited {
+		tl.onList[pc] = false
+	}
+	tl.visited = tl.visited[:0]
+	tl.threads = tl.threads[:0]
+}
+
+// automatonInput keeps a window of the file around the offsets the VM is looking at
+type automatonInput struct {
+	reader      *files.Reader
+	buffer      string
+	bufferStart int
+	// line and column numbers are found by walking forward to each offset we report
+	cursorOffset int
+	lineNumber   int
+	columnNumber int
+}
+
+func newAutomatonInput(reader *files.Reader) *automatonInput {
+	return &automatonInput{reader: reader, lineNumber: 1, columnNumber: 1}
+}
+
+func (in *automatonInput) advanceTo(offset int) {
+	for in.cursorOffset < offset {
+		r, raw := in.runeAt(in.cursorOffset)
+		in.cursorOffset += len(raw)
+		in.columnNumber += 1
+		if r == '\n' {
+			in.lineNumber += 1
+			in.columnNumber = 1
+		}
+	}
+}
+
+// positionAt finds the line and column of an offset after the cursor without moving the cursor
+// so an overlapping match can still start before the end of the last one
+func (in *automatonInput) positionAt(offset int) (int, int) {
+	lookahead := *in
+	lookahead.advanceTo(offset)
+	return lookahead.lineNumber, lookahead.columnNumber
+}
+
+const automatonWindowSize = 1 << 16
+
+func (in *automatonInput) window(offset int, length int) string {
+	if offset < 0 {
+		length += offset
+		offset = 0
+	}
+	length = in.reader.Clamp(offset, length)
+	if length <= 0 {
+		return ""
+	}
+	if offset < in.bufferStart || offset+length > in.bufferStart+len(in.buffer) {
+		in.bufferStart = offset - utf8.UTFMax
+		if in.bufferStart < 0 {
+			in.bufferStart = 0
+		}
+		size := in.reader.Clamp(in.bufferStart, automatonWindowSize)
+		in.buffer = in.reader.ReadAt(size, in.bufferStart)
+	}
+	return in.buffer[offset-in.bufferStart : offset-in.bufferStart+length]
+}
+
+func (in *automatonInput) runeAt(offset int) (rune, string) {
+	r, width := utf8.DecodeRuneInString(in.window(offset, utf8.UTFMax))
+	return r, in.window(offset, width)
+}
+
+func (in *automatonInput) runeBefore(offset int) string {
+	_, width := utf8.DecodeLastRuneInString(in.window(offset-utf8.UTFMax, utf8.UTFMax))
+	return in.window(offset-width, width)
+}
+
+func (in *automatonInput) assert(class ast.AstCharacterClassType, offset int) bool {
+	atEnd := in.reader.AtEnd(offset)
+	switch class {
+	case ast.ClassFileStart:
+		return offset == 0
+	case ast.ClassFileEnd:
+		return atEnd
+	case ast.ClassLineStart:
+		return offset == 0 || in.window(offset-1, 1) == "\n"
+	case ast.ClassLineEnd:
+		return atEnd || in.window(offset, 1) == "\n" || in.window(offset, 2) == "\r\n"
+	case ast.ClassWordStart:
+		if atEnd {
+			return true
+		}
+		_, current := in.runeAt(offset)
+		if offset == 0 {
+			return isWord(current)
+		}
+		return isWord(current) && !isWord(in.runeBefore(offset))
+	case ast.ClassWordEnd:
+		if offset == 0 || atEnd {
+			return true
+		}
+		_, current := in.runeAt(offset)
+		return !isWord(current) && isWord(in.runeBefore(offset))
+	}
+	return false
+}
+
+func (a *automaton) addThread(list *threadList, pc int, start int, offset int, input *automatonInput) {
+	if list.onList[pc] {
+		return
+	}
+	list.onList[pc] = true
+	list.visited = append(list.visited, pc)
+	inst := a.insts[pc]
+	switch inst.op {
+	case nfaJump:
+		a.addThread(list, inst.x, start, offset, input)
+	case nfaSplit:
+		a.addThread(list, inst.x, start, offset, input)
+		a.addThread(list, inst.y, start, offset, input)
+	case nfaAssert:
+		if input.assert(inst.assert, offset) != inst.not {
+			a.addThread(list, pc+1, start, offset, input)
+		}
+	case nfaFail:
+	default:
+		list.threads = append(list.threads, nfaThread{pc, start})
+	}
+}
+
+// find returns the first non-empty match that starts at or after fromOffset. It gives up without a match when ctx is done.
+func (a *automaton) find(ctx context.Context, input *automatonInput, fromOffset int, filter *prefilter) (int, int, bool) {
+	current := newThreadList(len(a.insts))
+	next := newThreadList(len(a.insts))
+	matched := false
+	matchStart, matchEnd := 0, 0
+
+	for offset, steps := fromOffset, 1; ; steps++ {
+		if steps%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return 0, 0, false
+		}
+		if !matched && filter != nil && len(current.threads) == 0 {
+			candidate := filter.next(input.reader, offset)
+			if candidate == -1 {
+				break
+			}
+			for offset < candidate {
+				_, raw := input.runeAt(offset)
+				offset += len(raw)
+			}
+		}
+		if !matched && len(current.threads) == 0 && input.reader.Streaming() {
+			// no match can start before here so a stream doesn't need to hold onto it
+			input.advanceTo(offset)
+			input.reader.Release(offset - utf8.UTFMax)
+		}
+		if !matched && !input.reader.AtEnd(offset) {
+			a.addThread(current, 0, offset, offset, input)
+		}
+		if len(current.threads) == 0 && (matched || input.reader.AtEnd(offset)) {
+			break
+		}
+
+		r, raw := input.runeAt(offset)
+		cutStart := -1
+		for _, t := range current.threads {
+			if t.start == cutStart {
+				continue
+			}
+			inst := a.insts[t.pc]
+			if inst.op == nfaMatch {
+				if t.start == offset {
+					// the backtracker throws out empty matches so this start position is done
+					cutStart = t.start
+					continue
+				}
+				matched = true
+				matchStart, matchEnd = t.start, offset
+				break
+			}
+			if len(raw) != 0 && inst.accept(r, raw) {
+				a.addThread(next, t.pc+1, t.start, offset+len(raw), input)
+			}
+		}
+
+		if len(raw) == 0 {
+			break
+		}
+		offset += len(raw)
+		current.clear()
+		current, next = next, current
+	}
+	return matchStart, matchEnd, matched
+}
+
+func findAutomatonMatches(ctx context.Context, a *automaton, all bool, skip int, take int, last int, overlapping bool, filename string, reader *files.Reader, filter *prefilter) (Matches, error) {
+	matches := ds.NewQueue[Match]()
+	matchNumber := 0
+	fileOffset := 0
+	input := newAutomatonInput(reader)
+
+	for all || matchNumber < skip+take {
+		if err := ctx.Err(); err != nil {
+			return matches.Contents(), err
+		}
+		start, end, found := a.find(ctx, input, fileOffset, filter)
+		if !found {
+			return matches.Contents(), ctx.Err()
+		}
+
+		if matchNumber >= skip {
+			state := CreateState(filename, reader, start, 0, 0)
+			input.advanceTo(start)
+			state.startLineNum, state.startColumnNum = input.lineNumber, input.columnNumber
+			state.currentLineNum, state.currentColumnNum = input.positionAt(end)
+			state.currentFileOffset = end
+			state.currentMatch = reader.ReadAt(end-start, start)
+			matches.Push(state.MakeMatch(matchNumber + 1))
+			if last != 0 {
+				matches.Limit(last)
+			}
+			if overlapping {
+				_, raw := input.runeAt(start)
+				fileOffset = start + len(raw)
+			} else {
+				input.advanceTo(end)
+				fileOffset = end
+			}
+		} else {
+			_, raw := input.runeAt(start)
+			fileOffset = start + len(raw)
+		}
+		matchNumber += 1
+
+		if reader.AtEnd(fileOffset) {
+			break
+		}
+	}
+
+	return matches.Contents(), nil
+}
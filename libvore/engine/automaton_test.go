@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/jmeaster30/vore/libvore/ast"
+	"github.com/jmeaster30/vore/libvore/bytecode"
+	"github.com/jmeaster30/vore/libvore/files"
+	"github.com/jmeaster30/vore/libvore/testutils"
+)
+
+func compileFind(t *testing.T, source string) bytecode.FindCommand {
+	t.Helper()
+	commands, err := ast.ParseReader(strings.NewReader(source))
+	testutils.CheckNoError(t, err)
+	program, err := bytecode.GenerateBytecode(commands)
+	testutils.CheckNoError(t, err)
+	var command any = program.Bytecode[0]
+	find, ok := command.(bytecode.FindCommand)
+	testutils.AssertTrue(t, ok)
+	return find
+}
+
+func checkSameMatches(t *testing.T, source string, find bytecode.FindCommand, a *automaton, input string) {
+	t.Helper()
+	expected, err := findBacktrackMatches(context.Background(), find.Body, find.Spans, find.All, find.Skip, find.Take, find.Last, find.Overlapping, find.Tree, "text", files.ReaderFromString(input), Limits{}, nil)
+	testutils.CheckNoError(t, err)
+	actual, err := findAutomatonMatches(context.Background(), a, find.All, find.Skip, find.Take, find.Last, find.Overlapping, "text", files.ReaderFromString(input), nil)
+	testutils.CheckNoError(t, err)
+	if len(expected) != len(actual) {
+		t.Fatalf("%s on %q: expected %d matches but got %d", source, input, len(expected), len(actual))
+	}
+	for i := range expected {
+		e, a := expected[i], actual[i]
+		if e.MatchNumber != a.MatchNumber || e.Value != a.Value || e.Offset != a.Offset || e.Line != a.Line || e.Column != a.Column {
+			t.Fatalf("%s on %q: expected match %+v but got %+v", source, input, e, a)
+		}
+	}
+}
+
+var automatonPatterns = []string{
+	"find all 'a'",
+	"find all 'ab' or 'a'",
+	"find all at least 1 'a'",
+	"find all at least 1 'a' fewest",
+	"find all between 2 and 3 'a'",
+	"find all between 1 and 3 'a' fewest 'b'",
+	"find all at least 1 (at least 1 'a') 'b'",
+	"find all at most 2 ('a' or 'b') 'x'",
+	"find all maybe 'a' 'b'",
+	"find all in 'a', 'b'",
+	"find all in 'a' to 'c', whitespace",
+	"find all caseless 'ab'",
+	"find all at least 1 letter",
+	"find all at least 1 word",
+	"find all at least 1 digit",
+	"find all at least 1 not whitespace",
+	"find all word start at least 1 any fewest word end",
+	"find all line start at least 1 any fewest line end",
+	"find all file start 'a'",
+	"find all 'b' file end",
+	"find all at least 1 upper",
+	"find all at least 1 lower",
+	"find all not word start 'a'",
+	"find skip 1 take 2 'a'",
+	"find last 2 at least 1 'a'",
+	"find all maybe 'a'",
+	"find all at least 0 'a' fewest",
+	"find all @/a+b|c/",
+	"find all @/[a-c]{2,4}?x/",
+	"find all @/\\w+\\b/",
+	"find all overlapping at least 1 'a'",
+	"find all overlapping 'a' at least 1 any fewest 'b'",
+	"find skip 1 take 2 overlapping 'aa'",
+	"find last 2 overlapping exactly 2 letter",
+	"find all overlapping word start at least 1 letter",
+	"find all caseless (at least 1 in 'a' to 'c', 'x')",
+	"find all caseless ('ab' or (in 'x' to 'z'))",
+	"find all caseless (in 'é' to 'ê') 'b'",
+}
+
+func TestAutomatonLowering(t *testing.T) {
+	for _, source := range automatonPatterns {
+		find := compileFind(t, source)
+		_, ok := lowerToAutomaton(find.Body)
+		testutils.AssertEqualLabel(t, source, true, ok)
+	}
+}
+
+func TestAutomatonNotLowered(t *testing.T) {
+	for _, source := range []string{
+		"find all 'a' = x x",
+		"find all not in 'a', 'b'",
+		"find all 'a' followed by 'b'",
+		"find all at least 1 'a' named run",
+		"find all not 'ab'",
+		"find all (at least 0 'a') = x",
+		"find all at least 1 maybe 'a'",
+		"find all whole line",
+		"find all maybe ('a' = x) if x then 'b' end",
+	} {
+		find := compileFind(t, source)
+		_, ok := lowerToAutomaton(find.Body)
+		testutils.AssertEqualLabel(t, source, false, ok)
+	}
+}
+
+func TestAutomatonMatchesBacktracker(t *testing.T) {
+	inputs := []string{
+		"a",
+		"ab",
+		"aab b ab abx aaax",
+		"ba\nab\r\naaa\nb",
+		"Élodie x_y 123 AbC",
+		"caab cabx  bbx\n\nab",
+	}
+	for _, source := range automatonPatterns {
+		find := compileFind(t, source)
+		a, ok := lowerToAutomaton(find.Body)
+		testutils.AssertTrue(t, ok)
+		for _, input := range inputs {
+			checkSameMatches(t, source, find, a, input)
+		}
+	}
+}
+
+func TestAutomatonMatchesBacktrackerRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(42))
+	alphabet := []string{"a", "b", "c", "x", "A", " ", "\n", "\r\n", "_", "1", "É"}
+	for _, source := range automatonPatterns {
+		find := compileFind(t, source)
+		a, ok := lowerToAutomaton(find.Body)
+		testutils.AssertTrue(t, ok)
+		for i := 0; i < 50; i++ {
+			var input strings.Builder
+			length := random.Intn(20)
+			for j := 0; j < length; j++ {
+				input.WriteString(alphabet[random.Intn(len(alphabet))])
+			}
+			checkSameMatches(t, source, find, a, input.String())
+		}
+	}
+}
@@ -1,67 +1,164 @@
 package engine
 
 import (
+	"context"
+	"io"
 	"os"
+	"runtime"
 
 	"github.com/jmeaster30/vore/libvore/bytecode"
 	"github.com/jmeaster30/vore/libvore/files"
 )
 
-func Run(bytecode *bytecode.Bytecode, searchText string) Matches {
+func Run(bytecode *bytecode.Bytecode, searchText string, limits Limits) (Matches, error) {
+	return RunContext(context.Background(), bytecode, searchText, limits)
+}
+
+// RunContext is Run but it stops when ctx is done and returns the matches found so far along with ctx.Err()
+func RunContext(ctx context.Context, bytecode *bytecode.Bytecode, searchText string, limits Limits) (Matches, error) {
+	return runText(ctx, bytecode, searchText, "text", limits)
+}
+
+func runText(ctx context.Context, bytecode *bytecode.Bytecode, searchText string, name string, limits Limits) (Matches, error) {
 	result := Matches{}
 	for _, command := range bytecode.Bytecode {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
 		reader := files.ReaderFromString(searchText)
-		result = append(result, search(&command, "text", reader, NOTHING)...)
+		foundMatches, err := search(ctx, &command, name, reader, NOTHING, limits)
+		result = append(result, foundMatches...)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// RunReader searches a source of unknown size like stdin. When there is a single find command the
+// source is streamed through and only the part the search can still reach is kept in memory. Anything
+// else has to go over the text more than once so the whole source is read in first.
+func RunReader(bytecode *bytecode.Bytecode, source io.Reader, name string, limits Limits) (Matches, error) {
+	return RunReaderContext(context.Background(), bytecode, source, name, limits)
+}
+
+// RunReaderContext is RunReader but it stops when ctx is done and returns the matches found so far along with ctx.Err()
+func RunReaderContext(ctx context.Context, bytecode *bytecode.Bytecode, source io.Reader, name string, limits Limits) (Matches, error) {
+	if !singlePass(bytecode) {
+		contents, err := io.ReadAll(source)
+		if err != nil {
+			return Matches{}, err
+		}
+		return runText(ctx, bytecode, string(contents), name, limits)
+	}
+
+	result := Matches{}
+	reader := files.ReaderFromReader(source)
+	for _, command := range bytecode.Bytecode {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		foundMatches, err := search(ctx, &command, name, reader, NOTHING, limits)
+		result = append(result, foundMatches...)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, reader.Err()
+}
+
+// singlePass reports whether the commands only need to read the search text once from start to end
+func singlePass(program *bytecode.Bytecode) bool {
+	finds := 0
+	for _, command := range program.Bytecode {
+		var ci any = command
+		switch ci.(type) {
+		case bytecode.SetCommand:
+		case bytecode.FindCommand:
+			finds += 1
+		default:
+			return false
+		}
 	}
-	return result
+	return finds <= 1
 }
 
-func RunFiles(bytecode *bytecode.Bytecode, filenames []string, mode ReplaceMode, processFilenames bool) Matches {
+func RunFiles(bytecode *bytecode.Bytecode, filenames []string, mode ReplaceMode, processFilenames bool, limits Limits, jobs int) (Matches, error) {
+	return RunFilesContext(context.Background(), bytecode, filenames, mode, processFilenames, limits, jobs)
+}
+
+// RunFilesContext is RunFiles but it stops when ctx is done and returns the matches found so far along with ctx.Err()
+//
+// Each command searches up to jobs files at the same time or one per CPU when jobs is 0 or less. The matches
+// are in the same order as when the files are searched one at a time and searches that read or write the
+// same file still run one after another.
+//
+// A file that can't be searched doesn't stop the others. The matches of every file that could be searched are
+// returned along with a FileErrors that has the error of each file that couldn't.
+func RunFilesContext(ctx context.Context, bytecode *bytecode.Bytecode, filenames []string, mode ReplaceMode, processFilenames bool, limits Limits, jobs int) (Matches, error) {
 	actualMode := mode
 	if processFilenames {
 		actualMode = NOTHING
 	}
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
 	result := Matches{}
+	actualFiles, errs := expandFilenames(filenames)
 	for _, command := range bytecode.Bytecode {
 		// command.print()
-		for _, filename := range filenames {
-			actualFiles := []string{}
-			info, err := os.Stat(filename)
-			if err != nil {
-				panic(err)
-			}
-			fixedFilename := filename
-			if info.IsDir() {
-				if filename[len(filename)-1] != '/' || filename[len(filename)-1] != '\\' {
-					fixedFilename += "/"
+		searched := searchFiles(ctx, &command, actualFiles, processFilenames, actualMode, limits, jobs)
+		for i, actualFilename := range actualFiles {
+			foundMatches := searched[i].matches
+			result = append(result, foundMatches...)
+			if searched[i].err != nil {
+				if err := ctx.Err(); err != nil {
+					return result, err
 				}
-				entries, err := os.ReadDir(filename)
+				errs = append(errs, searched[i].err)
+				continue
+			}
+			if processFilenames && len(foundMatches) != 0 && len(foundMatches[0].Replacement.GetValueOrDefault("")) != 0 {
+				err := os.Rename(actualFilename, foundMatches[0].Replacement.GetValueOrDefault(""))
 				if err != nil {
-					panic(err)
+					errs = append(errs, NewFileError(actualFilename, err))
 				}
-				for _, entry := range entries {
-					actualFiles = append(actualFiles, fixedFilename+entry.Name())
-				}
-			} else {
-				actualFiles = append(actualFiles, fixedFilename)
 			}
-			for _, actualFilename := range actualFiles {
-				var reader *files.Reader
-				if processFilenames {
-					reader = files.ReaderFromString(actualFilename)
-				} else {
-					reader = files.ReaderFromFile(actualFilename)
-				}
-				foundMatches := search(&command, actualFilename, reader, actualMode)
-				result = append(result, foundMatches...)
-				if processFilenames && len(foundMatches) != 0 && len(foundMatches[0].Replacement.GetValueOrDefault("")) != 0 {
-					err := os.Rename(actualFilename, foundMatches[0].Replacement.GetValueOrDefault(""))
-					if err != nil {
-						os.Stderr.WriteString("Failed to rename file '" + actualFilename + "' to '" + foundMatches[0].Replacement.GetValueOrDefault("") + "'\n")
-					}
-				}
+		}
+	}
+	if len(errs) != 0 {
+		return result, errs
+	}
+	return result, nil
+}
+
+// expandFilenames replaces each directory with the files in it. Paths that can't be read are left out and
+// their errors are returned.
+func expandFilenames(filenames []string) ([]string, FileErrors) {
+	actualFiles := []string{}
+	errs := FileErrors{}
+	for _, filename := range filenames {
+		info, err := os.Stat(filename)
+		if err != nil {
+			errs = append(errs, NewFileError(filename, err))
+			continue
+		}
+		fixedFilename := filename
+		if info.IsDir() {
+			if filename[len(filename)-1] != '/' && filename[len(filename)-1] != '\\' {
+				fixedFilename += "/"
+			}
+			entries, err := os.ReadDir(filename)
+			if err != nil {
+				errs = append(errs, NewFileError(filename, err))
+				continue
+			}
+			for _, entry := range entries {
+				actualFiles = append(actualFiles, fixedFilename+entry.Name())
 			}
+		} else {
+			actualFiles = append(actualFiles, fixedFilename)
 		}
 	}
-	return result
+	return actualFiles, errs
 }
@@ -11,6 +11,7 @@ type ExecError struct {
 	processState ProcessState
 	instruction  bytecode.ProcInstruction
 	message      string
+	filename     string
 }
 
 func max(a int, b int) int {
@@ -51,10 +52,23 @@ func (err ExecError) Message() string {
 	return err.message
 }
 
-func NewExecError(message string, instruction bytecode.ProcInstruction, processState ProcessState) ExecError {
-	return ExecError{
-		processState,
-		instruction,
-		message,
+// Filename is the file the match being validated or replaced came from.
+func (err ExecError) Filename() string {
+	return err.filename
+}
+
+func NewExecError(message string, instruction bytecode.ProcInstruction, processState ProcessState) *ExecError {
+	return &ExecError{
+		processState: processState,
+		instruction:  instruction,
+		message:      message,
+	}
+}
+
+// inFile sets the filename of err when it is an ExecError
+func inFile(err error, filename string) error {
+	if execErr, ok := err.(*ExecError); ok {
+		execErr.filename = filename
 	}
+	return err
 }
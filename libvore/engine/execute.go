@@ -286,6 +286,9 @@ func executeDivide(inst bytecode.Divide, state *ProcessState) error {
 
 	b := state.stack.Pop().GetValue().Number()
 	a := state.stack.Pop().GetValue().Number()
+	if b == 0 {
+		return NewExecError("Divided by zero", inst, *state)
+	}
 	state.stack.Push(bytecode.NewNumber(a / b))
 	return nil
 }
@@ -297,6 +300,9 @@ func executeModulo(inst bytecode.Modulo, state *ProcessState) error {
 
 	b := state.stack.Pop().GetValue().Number()
 	a := state.stack.Pop().GetValue().Number()
+	if b == 0 {
+		return NewExecError("Took the modulo by zero", inst, *state)
+	}
 	state.stack.Push(bytecode.NewNumber(a % b))
 	return nil
 }
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FileError is an error opening, reading or writing a file that was being searched.
+type FileError struct {
+	filename string
+	err      error
+}
+
+func (err FileError) Error() string {
+	return fmt.Sprintf("FileError: %s", err.Message())
+}
+
+func (err FileError) Message() string {
+	return fmt.Sprintf("Couldn't search '%s': %s", err.filename, err.err.Error())
+}
+
+func (err FileError) Filename() string {
+	return err.filename
+}
+
+func (err FileError) Unwrap() error {
+	return err.err
+}
+
+func NewFileError(filename string, err error) *FileError {
+	return &FileError{
+		filename: filename,
+		err:      err,
+	}
+}
+
+// InternalError is a bug in vore that stopped a compile or a search. It is returned in place of the panic
+// so one broken program doesn't take down everything else running in the same process.
+type InternalError struct {
+	filename string
+	value    any
+}
+
+func (err InternalError) Error() string {
+	return fmt.Sprintf("InternalError: %s", err.Message())
+}
+
+func (err InternalError) Message() string {
+	return fmt.Sprintf("Something went wrong inside of vore with '%s': %v", err.filename, err.value)
+}
+
+func (err InternalError) Filename() string {
+	return err.filename
+}
+
+// Value is what was passed to panic.
+func (err InternalError) Value() any {
+	return err.value
+}
+
+func NewInternalError(filename string, value any) *InternalError {
+	return &InternalError{
+		filename: filename,
+		value:    value,
+	}
+}
+
+// FileErrors holds the error of every file that failed in the order the files were given.
+// errors.Is and errors.As look through each of them.
+type FileErrors []error
+
+func (errs FileErrors) Error() string {
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (errs FileErrors) Unwrap() []error {
+	return errs
+}
+
+// Is looks through each error itself since the errors package only unwraps a list of errors from Go 1.20 on
+func (errs FileErrors) Is(target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As looks through each error itself since the errors package only unwraps a list of errors from Go 1.20 on
+func (errs FileErrors) As(target any) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
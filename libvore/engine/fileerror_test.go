@@ -0,0 +1,26 @@
+package engine
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/jmeaster30/vore/libvore/testutils"
+)
+
+func TestFileErrorsAs(t *testing.T) {
+	errs := FileErrors{NewFileError("a.txt", fs.ErrNotExist), NewLimitError(StepLimit, 10, nil, nil, 0, CreateState("b.txt", nil, 0, 1, 1))}
+
+	var limitErr *LimitError
+	testutils.AssertTrue(t, errs.As(&limitErr))
+	testutils.AssertEqual(t, "b.txt", limitErr.Filename())
+
+	var fileErr *FileError
+	testutils.AssertTrue(t, errs.As(&fileErr))
+	testutils.AssertEqual(t, "a.txt", fileErr.Filename())
+}
+
+func TestFileErrorsIs(t *testing.T) {
+	errs := FileErrors{NewFileError("a.txt", fs.ErrNotExist)}
+	testutils.AssertTrue(t, errs.Is(fs.ErrNotExist))
+	testutils.AssertFalse(t, errs.Is(fs.ErrPermission))
+}
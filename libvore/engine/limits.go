@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/jmeaster30/vore/libvore/ast"
+	"github.com/jmeaster30/vore/libvore/bytecode"
+)
+
+// Limits bounds how much work the search engine does before giving up with a LimitError.
+// A field left at zero means that resource is not limited.
+//
+// Find commands that don't need to backtrack normally run on an automaton that takes time linear in the input.
+// The limits are counted in the work of the backtracking engine so setting any of them other than MaxCallDepth
+// runs every command on the backtracking engine where they can be checked.
+//
+// A program starts out with DefaultLimits which only bounds the call depth so a recursive pattern can't keep
+// calling itself until it runs out of memory.
+type Limits struct {
+	MaxSteps          int // instructions executed by a single match attempt
+	MaxFileSteps      int // instructions executed by all match attempts of a command on one file
+	MaxBacktrackDepth int // checkpoints waiting on the backtrack stack
+	MaxMemory         int // approximate bytes held by the backtrack stack
+	MaxCallDepth      int // subroutine calls running inside of each other
+}
+
+// DefaultMaxCallDepth is the call depth programs are limited to when their limits haven't been set
+const DefaultMaxCallDepth = 10000
+
+func DefaultLimits() Limits {
+	return Limits{MaxCallDepth: DefaultMaxCallDepth}
+}
+
+type LimitKind int
+
+const (
+	StepLimit LimitKind = iota
+	FileStepLimit
+	BacktrackDepthLimit
+	MemoryLimit
+	CallDepthLimit
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case StepLimit:
+		return "step"
+	case FileStepLimit:
+		return "file step"
+	case BacktrackDepthLimit:
+		return "backtrack depth"
+	case MemoryLimit:
+		return "memory"
+	case CallDepthLimit:
+		return "call depth"
+	}
+	return "unknown"
+}
+
+type LimitError struct {
+	kind         LimitKind
+	limit        int
+	filename     string
+	instructions []bytecode.SearchInstruction
+	pc           int
+	span         ast.Span
+	fileOffset   int
+	lineNum      int
+	columnNum    int
+}
+
+func (err LimitError) Error() string {
+	message := fmt.Sprintf("LimitError: %s\n", err.Message())
+	message += "Instructions ---\n"
+	startIdx := err.pc
+	if startIdx >= len(err.instructions) {
+		startIdx = len(err.instructions) - 1
+	}
+	endIdx := max(startIdx-5, 0)
+	for idx, inst := range err.instructions[endIdx : startIdx+1] {
+		if idx+endIdx == err.pc {
+			message += fmt.Sprintf("   @@[%d] %v\n", idx+endIdx, inst)
+		} else {
+			message += fmt.Sprintf("     [%d] %v\n", idx+endIdx, inst)
+		}
+	}
+	return message
+}
+
+func (err LimitError) Message() string {
+	return fmt.Sprintf("Exceeded the %s limit of %d at query line %d column %d in '%s' while matching from line %d column %d (offset %d).", err.kind, err.limit, err.span.Line.Start, err.span.Column.Start, err.filename, err.lineNum, err.columnNum, err.fileOffset)
+}
+
+// Span is the part of the query that generated the instruction running when the limit was hit
+func (err LimitError) Span() ast.Span {
+	return err.span
+}
+
+func (err LimitError) Kind() LimitKind {
+	return err.kind
+}
+
+func (err LimitError) Limit() int {
+	return err.limit
+}
+
+func (err LimitError) Filename() string {
+	return err.filename
+}
+
+// ProgramCounter is the index of the search instruction that was running when the limit was hit.
+func (err LimitError) ProgramCounter() int {
+	return err.pc
+}
+
+func (err LimitError) Instruction() bytecode.SearchInstruction {
+	if err.pc < 0 || err.pc >= len(err.instructions) {
+		return nil
+	}
+	return err.instructions[err.pc]
+}
+
+// Offset is the file offset the failing match attempt started from.
+func (err LimitError) Offset() int {
+	return err.fileOffset
+}
+
+func (err LimitError) Line() int {
+	return err.lineNum
+}
+
+func (err LimitError) Column() int {
+	return err.columnNum
+}
+
+func NewLimitError(kind LimitKind, limit int, instructions []bytecode.SearchInstruction, spans []ast.Span, pc int, state *SearchEngineState) *LimitError {
+	span := ast.Span{}
+	if pc >= 0 && pc < len(spans) {
+		span = spans[pc]
+	}
+	return &LimitError{
+		kind:         kind,
+		limit:        limit,
+		filename:     state.filename,
+		instructions: instructions,
+		pc:           pc,
+		span:         span,
+		fileOffset:   state.startFileOffset,
+		lineNum:      state.startLineNum,
+		columnNum:    state.startColumnNum,
+	}
+}
+
+// limitsBacktracking is true when a limit is set that the automaton can't check. Programs that run on the
+// automaton don't make calls so MaxCallDepth doesn't matter to it.
+func (l Limits) limitsBacktracking() bool {
+	return l.MaxSteps > 0 || l.MaxFileSteps > 0 || l.MaxBacktrackDepth > 0 || l.MaxMemory > 0
+}
+
+// check is run after the instruction at pc so a limit gets blamed on the instruction that went over it
+func (l Limits) check(steps int, fileSteps int, instructions []bytecode.SearchInstruction, spans []ast.Span, pc int, state *SearchEngineState) error {
+	if l.MaxSteps > 0 && steps > l.MaxSteps {
+		return NewLimitError(StepLimit, l.MaxSteps, instructions, spans, pc, state)
+	}
+	if l.MaxFileSteps > 0 && fileSteps > l.MaxFileSteps {
+		return NewLimitError(FileStepLimit, l.MaxFileSteps, instructions, spans, pc, state)
+	}
+	if l.MaxBacktrackDepth > 0 && state.backtrack.Size() > l.MaxBacktrackDepth {
+		return NewLimitError(BacktrackDepthLimit, l.MaxBacktrackDepth, instructions, spans, pc, state)
+	}
+	if l.MaxMemory > 0 && state.backtrackMemory > l.MaxMemory {
+		return NewLimitError(MemoryLimit, l.MaxMemory, instructions, spans, pc, state)
+	}
+	if l.MaxCallDepth > 0 && state.callStack.Size() > l.MaxCallDepth {
+		return NewLimitError(CallDepthLimit, l.MaxCallDepth, instructions, spans, pc, state)
+	}
+	return nil
+}
+
+// checkpointSize estimates how many bytes a copy of the state holds onto while it sits on the backtrack stack.
+// The stacks and maps are shared with the live state so only the struct and the match text count.
+func (es *SearchEngineState) checkpointSize() int {
+	return int(unsafe.Sizeof(*es)) + len(es.currentMatch)
+}
@@ -18,21 +18,20 @@ func (m Matches) Print() {
 	}
 }
 
-func (m Matches) Json() string {
-	var mi any = m
-	data, err := json.Marshal(mi.([]Match))
+func (m Matches) Json() (string, error) {
+	data, err := json.Marshal(m)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return string(data)
+	return string(data), nil
 }
 
-func (m Matches) FormattedJson() string {
+func (m Matches) FormattedJson() (string, error) {
 	data, err := json.MarshalIndent(m, "", "\t")
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return string(data)
+	return string(data), nil
 }
 
 type Match struct {
@@ -44,22 +43,72 @@ type Match struct {
 	Value       string
 	Replacement ds.Optional[string]
 	Variables   bytecode.MapValue
+	Captures    map[string]Capture
+	Tree        []ParseNode // the subroutine calls that made up the match when the find asked for a tree
+}
+
+// ParseNode is a subroutine call that was part of a match along with the calls it made to other subroutines
+type ParseNode struct {
+	Name     string
+	Offset   ds.Range
+	Line     ds.Range
+	Column   ds.Range
+	Value    string
+	Children []ParseNode
 }
 
-func (m Match) Json() string {
+func (n ParseNode) MarshalJSON() ([]byte, error) {
+	result := make(map[string]any)
+	result["name"] = n.Name
+	result["offset"] = n.Offset
+	result["line"] = n.Line
+	result["column"] = n.Column
+	result["value"] = n.Value
+	result["children"] = n.Children
+	return json.Marshal(result)
+}
+
+func (n ParseNode) print(indent string) {
+	fmt.Printf("%s%s = '%s' %d-%d %d-%d %d-%d\n", indent, n.Name, n.Value, n.Offset.Start, n.Offset.End, n.Line.Start, n.Line.End, n.Column.Start, n.Column.End)
+	for _, child := range n.Children {
+		child.print(indent + "  ")
+	}
+}
+
+// Capture is where in the file a variable was captured. A named loop covers the whole loop and
+// also has the captures made in each of its iterations.
+type Capture struct {
+	Offset     ds.Range
+	Line       ds.Range
+	Column     ds.Range
+	Iterations []map[string]Capture
+}
+
+func (c Capture) MarshalJSON() ([]byte, error) {
+	result := make(map[string]any)
+	result["offset"] = c.Offset
+	result["line"] = c.Line
+	result["column"] = c.Column
+	if c.Iterations != nil {
+		result["iterations"] = c.Iterations
+	}
+	return json.Marshal(result)
+}
+
+func (m Match) Json() (string, error) {
 	data, err := json.Marshal(m)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return string(data)
+	return string(data), nil
 }
 
-func (m Match) FormattedJson() string {
+func (m Match) FormattedJson() (string, error) {
 	data, err := json.MarshalIndent(m, "", "\t")
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return string(data)
+	return string(data), nil
 }
 
 func (m Match) MarshalJSON() ([]byte, error) {
@@ -74,6 +123,10 @@ func (m Match) MarshalJSON() ([]byte, error) {
 		result["replacement"] = m.Replacement.GetValue()
 	}
 	result["variables"] = m.Variables
+	result["captures"] = m.Captures
+	if m.Tree != nil {
+		result["tree"] = m.Tree
+	}
 	return json.Marshal(result)
 }
 
@@ -93,6 +146,19 @@ func (m Match) Print() {
 	for key, value := range m.Variables.Map() {
 		fmt.Printf("  %s = %s\n", key, value)
 	}
+	fmt.Println("Captures:")
+	fmt.Println("  [key] = [offset] [line] [column]")
+
+	for key, capture := range m.Captures {
+		fmt.Printf("  %s = %d-%d %d-%d %d-%d\n", key, capture.Offset.Start, capture.Offset.End, capture.Line.Start, capture.Line.End, capture.Column.Start, capture.Column.End)
+	}
+	if m.Tree != nil {
+		fmt.Println("Tree:")
+		fmt.Println("  [name] = [value] [offset] [line] [column]")
+		for _, node := range m.Tree {
+			node.print("  ")
+		}
+	}
 
 	fmt.Println()
 }
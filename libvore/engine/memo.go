@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jmeaster30/vore/libvore/bytecode"
+)
+
+// subroutineMemo remembers every offset a subroutine call can end at so calling the same subroutine from the same
+// offset again replays those ends instead of running the subroutine again. This keeps patterns built out of many
+// patterns calling each other from redoing the same work every time they backtrack.
+type subroutineMemo struct {
+	memoizable map[int]bool
+	entries    map[memoKey]*memoEntry
+}
+
+// memoKey is everything a call's ends depend on. A subroutine can't be started again from the offset it is already
+// running from so the calls running from the offset change where the call can end.
+type memoKey struct {
+	id      int
+	offset  int
+	running string
+}
+
+type memoEntry struct {
+	ends  []int
+	trees []ParseNode // the parse node of the first way through the call that reached each end
+	seen  map[int]bool
+	done  bool // every way through the subroutine has been tried so ends has all of them
+}
+
+func newSubroutineMemo(insts []bytecode.SearchInstruction) *subroutineMemo {
+	return &subroutineMemo{
+		memoizable: memoizableSubroutines(insts),
+		entries:    map[memoKey]*memoEntry{},
+	}
+}
+
+func (m *subroutineMemo) enabled(id int) bool {
+	return m != nil && m.memoizable[id]
+}
+
+// reset forgets the entries of the last match attempt so the memo only ever holds the part of the file
+// the current attempt is looking at
+func (m *subroutineMemo) reset() {
+	m.entries = map[memoKey]*memoEntry{}
+}
+
+func (e *memoEntry) record(end int, node ParseNode) {
+	if !e.seen[end] {
+		e.seen[end] = true
+		e.ends = append(e.ends, end)
+		e.trees = append(e.trees, node)
+	}
+}
+
+// memoizableSubroutines finds the subroutines that can be memoized. A subroutine that reads a variable or calls a
+// subroutine that does can end somewhere different depending on what its caller captured so it is left out.
+// A subroutine that captures a variable is left out too since replaying it wouldn't give its caller the variable.
+func memoizableSubroutines(insts []bytecode.SearchInstruction) map[int]bool {
+	result := map[int]bool{}
+	for pc, inst := range insts {
+		if _, ok := inst.(bytecode.StartSubroutine); ok {
+			result[pc] = usesNoVariables(insts, pc, map[int]bool{})
+		}
+	}
+	return result
+}
+
+func usesNoVariables(insts []bytecode.SearchInstruction, id int, visiting map[int]bool) bool {
+	if visiting[id] {
+		return true
+	}
+	visiting[id] = true
+	start, ok := insts[id].(bytecode.StartSubroutine)
+	if !ok {
+		return false
+	}
+	for pc := id + 1; pc < start.EndOffset && pc < len(insts); pc++ {
+		switch inst := insts[pc].(type) {
+		case bytecode.MatchVariable, bytecode.IfVariable, bytecode.StartVarDec:
+			return false
+		case bytecode.StartLoop:
+			if inst.Name != "" {
+				return false
+			}
+		case bytecode.MatchFuzzy:
+			if inst.Variable != "" {
+				return false
+			}
+		case bytecode.CallSubroutine:
+			if !usesNoVariables(insts, inst.ToPC, visiting) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// runningCalls lists the subroutines running from the current offset in a way that can be used as part of a memoKey
+func (es *SearchEngineState) runningCalls() string {
+	ids := []int{}
+	for frames := es.callStack; !frames.IsEmpty(); frames = frames.Pop() {
+		frame := frames.Peek().GetValue()
+		if frame.startFileOffset != es.currentFileOffset {
+			break
+		}
+		ids = append(ids, frame.id)
+	}
+	sort.Ints(ids)
+	return fmt.Sprint(ids)
+}
+
+// REPLAY matches up to where a memoized call ended along with the parse node the call made on its way there
+func (es *SearchEngineState) REPLAY(end int, node ParseNode) {
+	es.ADVANCE(es.READAT(es.currentFileOffset, end-es.currentFileOffset))
+	if es.buildTree {
+		es.tree = es.tree.Push(node)
+	}
+	es.NEXT()
+}
+
+// MEMOCALL calls a memoized subroutine. The first call from an offset runs the subroutine and records each offset it
+// returns at. A checkpoint below everything the call leaves on the backtrack stack marks the entry as done once the
+// search backtracks past it. Calls after that match each recorded end in order without running the subroutine.
+func (es *SearchEngineState) MEMOCALL(id int, returnOffset int) {
+	if es.exhausted != nil {
+		es.exhausted.done = true
+		es.exhausted = nil
+		es.BACKTRACK()
+		return
+	}
+
+	key := memoKey{id: id, offset: es.currentFileOffset, running: es.runningCalls()}
+	entry, found := es.memo.entries[key]
+	if found && entry.done {
+		if len(entry.ends) == 0 {
+			es.BACKTRACK()
+			return
+		}
+		for i := len(entry.ends) - 1; i > 0; i-- {
+			checkpoint := *es
+			checkpoint.REPLAY(entry.ends[i], entry.trees[i])
+			es.PUSHCHECKPOINT(checkpoint)
+		}
+		es.REPLAY(entry.ends[0], entry.trees[0])
+		return
+	}
+
+	checkpoint := *es
+	if !es.CALL(id, returnOffset) {
+		es.BACKTRACK()
+		return
+	}
+	if !found {
+		// when the entry is already there the same call is still being recorded further down the backtrack stack
+		entry = &memoEntry{seen: map[int]bool{}}
+		es.memo.entries[key] = entry
+		checkpoint.exhausted = entry
+		es.PUSHCHECKPOINT(checkpoint)
+		frame := es.callStack.Peek().GetValue()
+		frame.memo = entry
+		es.callStack = es.callStack.Pop().Push(frame)
+	}
+	es.JUMP(id)
+}
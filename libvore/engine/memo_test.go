@@ -0,0 +1,42 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/jmeaster30/vore/libvore/bytecode"
+	"github.com/jmeaster30/vore/libvore/testutils"
+)
+
+func memoizableByName(insts []bytecode.SearchInstruction) map[string]bool {
+	result := map[string]bool{}
+	memoizable := memoizableSubroutines(insts)
+	for pc, inst := range insts {
+		if start, ok := inst.(bytecode.StartSubroutine); ok {
+			result[start.Name] = memoizable[pc]
+		}
+	}
+	return result
+}
+
+func TestMemoizableSubroutine(t *testing.T) {
+	memoizable := memoizableByName(compileFind(t, "find all {'(' maybe parens ')'} = parens").Body)
+	testutils.AssertTrue(t, memoizable["parens"])
+}
+
+func TestMemoizableSubroutineWithBackreference(t *testing.T) {
+	memoizable := memoizableByName(compileFind(t, "find all ('a' = first) {first maybe again} = again").Body)
+	testutils.AssertFalse(t, memoizable["again"])
+}
+
+func TestMemoizableSubroutineCallingBackreference(t *testing.T) {
+	memoizable := memoizableByName(compileFind(t, "find all ('a' = first) {'b' {first maybe inner} = inner maybe outer} = outer {'c' maybe other} = other").Body)
+	testutils.AssertFalse(t, memoizable["inner"])
+	testutils.AssertFalse(t, memoizable["outer"])
+	testutils.AssertTrue(t, memoizable["other"])
+}
+
+func TestMemoizableSubroutineWithCapture(t *testing.T) {
+	memoizable := memoizableByName(compileFind(t, "find all {(letter) = inner maybe capturing} = capturing {'a' maybe plain} = plain").Body)
+	testutils.AssertFalse(t, memoizable["capturing"])
+	testutils.AssertTrue(t, memoizable["plain"])
+}
@@ -0,0 +1,223 @@
+package engine
+
+import (
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/jmeaster30/vore/libvore/ast"
+	"github.com/jmeaster30/vore/libvore/bytecode"
+	"github.com/jmeaster30/vore/libvore/files"
+)
+
+// prefilter is a literal that every match has to contain. When the literal is always
+// a bounded distance from the start of the match we can skip straight to just before its
+// next occurrence instead of trying every offset in between.
+type prefilter struct {
+	literal   string
+	maxOffset int // most bytes between the start of a match and the literal, -1 when unbounded
+	found     int // offset of the last occurrence we looked up
+}
+
+func buildPrefilter(insts []bytecode.SearchInstruction) *prefilter {
+	var best *prefilter
+	consider := func(literal string, width int) {
+		if literal == "" {
+			return
+		}
+		maxOffset := -1
+		if width != -1 {
+			maxOffset = width * utf8.UTFMax
+		}
+		if best == nil || (best.maxOffset == -1 && maxOffset != -1) || ((best.maxOffset == -1) == (maxOffset == -1) && len(literal) > len(best.literal)) {
+			best = &prefilter{literal, maxOffset, -2}
+		}
+	}
+
+	// walk the instructions every match has to go through and collect runs of literals
+	width := 0
+	run := ""
+	runWidth := 0
+	for pc := 0; pc < len(insts); {
+		switch i := insts[pc].(type) {
+		case bytecode.MatchLiteral:
+			if !i.Not && !i.Caseless {
+				if run == "" {
+					runWidth = width
+				}
+				run += i.ToFind
+				width = addWidth(width, utf8.RuneCountInString(i.ToFind))
+				pc += 1
+				continue
+			}
+		case bytecode.StartVarDec, bytecode.EndVarDec, bytecode.StartSubroutine, bytecode.EndSubroutine, bytecode.StartAtomic, bytecode.EndAtomic:
+			pc += 1
+			continue
+		}
+
+		consider(run, runWidth)
+		run = ""
+		next, w, ok := instructionWidth(insts, pc)
+		if !ok {
+			return best
+		}
+		width = addWidth(width, w)
+		pc = next
+	}
+	consider(run, runWidth)
+	return best
+}
+
+func addWidth(a int, b int) int {
+	if a == -1 || b == -1 {
+		return -1
+	}
+	return a + b
+}
+
+// instructionWidth returns the pc after the instruction (or structure) at pc and the most runes it can match, -1 when unbounded
+func instructionWidth(insts []bytecode.SearchInstruction, pc int) (int, int, bool) {
+	var ii any = insts[pc]
+	switch i := ii.(type) {
+	case bytecode.MatchLiteral:
+		return pc + 1, utf8.RuneCountInString(i.ToFind), true
+	case bytecode.MatchCharClass:
+		switch i.Class {
+		case ast.ClassAny, ast.ClassWhitespace, ast.ClassDigit, ast.ClassUpper, ast.ClassLower, ast.ClassLetter, ast.ClassWord:
+			return pc + 1, 1, true
+		case ast.ClassWholeFile, ast.ClassWholeLine, ast.ClassWholeWord:
+			return pc + 1, -1, true
+		default:
+			return pc + 1, 0, true
+		}
+	case bytecode.MatchUnicodeClass:
+		return pc + 1, 1, true
+	case bytecode.MatchRange:
+		width := utf8.RuneCountInString(i.To)
+		if from := utf8.RuneCountInString(i.From); from > width {
+			width = from
+		}
+		return pc + 1, width, true
+	case bytecode.MatchTerms:
+		return pc + 1, i.Terms.Longest(), true
+	case bytecode.MatchNumber:
+		if i.LeadingZeros || i.Decimals {
+			return pc + 1, -1, true
+		}
+		width := len(strconv.Itoa(i.Max))
+		if lower := len(strconv.Itoa(i.Min)); lower > width {
+			width = lower
+		}
+		if i.Signs {
+			width += 1
+		}
+		return pc + 1, width, true
+	case bytecode.MatchFuzzy:
+		if i.Variable != "" {
+			return pc + 1, -1, true
+		}
+		return pc + 1, utf8.RuneCountInString(i.ToFind) + i.MaxEdits, true
+	case bytecode.MatchVariable, bytecode.CallSubroutine:
+		return pc + 1, -1, true
+	case bytecode.StartVarDec, bytecode.EndVarDec, bytecode.StartSubroutine, bytecode.EndSubroutine, bytecode.StartAtomic, bytecode.EndAtomic:
+		return pc + 1, 0, true
+	case bytecode.StartLookaround:
+		return i.EndPC + 1, 0, true
+	case bytecode.IfVariable:
+		jump, ok := insts[i.ElsePC-1].(bytecode.Jump)
+		if !ok {
+			return 0, 0, false
+		}
+		thenWidth, ok := sequenceWidth(insts, pc+1, i.ElsePC-1)
+		if !ok {
+			return 0, 0, false
+		}
+		elseWidth, ok := sequenceWidth(insts, i.ElsePC, jump.NewProgramCounter)
+		if !ok {
+			return 0, 0, false
+		}
+		if thenWidth == -1 || elseWidth == -1 {
+			return jump.NewProgramCounter, -1, true
+		}
+		if elseWidth > thenWidth {
+			return jump.NewProgramCounter, elseWidth, true
+		}
+		return jump.NewProgramCounter, thenWidth, true
+	case bytecode.StartNotInList:
+		return instructionWidth(insts, pc+1)
+	case bytecode.StartNotIn:
+		next := i.NextCheckpointPC
+		for next < len(insts) {
+			switch n := insts[next].(type) {
+			case bytecode.StartNotIn:
+				next = n.NextCheckpointPC
+				continue
+			case bytecode.EndNotIn:
+				return next + 1, n.MaxSize, true
+			}
+			break
+		}
+		return 0, 0, false
+	case bytecode.Branch:
+		bodies, end, ok := branchLayout(insts, i, pc)
+		if !ok {
+			return 0, 0, false
+		}
+		width := 0
+		for _, body := range bodies {
+			w, ok := sequenceWidth(insts, body.Start, body.End)
+			if !ok {
+				return 0, 0, false
+			}
+			if w == -1 || width == -1 {
+				width = -1
+			} else if w > width {
+				width = w
+			}
+		}
+		return end, width, true
+	case bytecode.StartLoop:
+		if i.ExitLoop >= len(insts) {
+			return 0, 0, false
+		}
+		w, ok := sequenceWidth(insts, pc+1, i.ExitLoop)
+		if !ok {
+			return 0, 0, false
+		}
+		if w == -1 || i.MaxLoops == -1 {
+			return i.ExitLoop + 1, -1, true
+		}
+		return i.ExitLoop + 1, w * i.MaxLoops, true
+	}
+	return 0, 0, false
+}
+
+func sequenceWidth(insts []bytecode.SearchInstruction, start int, end int) (int, bool) {
+	width := 0
+	for pc := start; pc < end; {
+		next, w, ok := instructionWidth(insts, pc)
+		if !ok || next > end {
+			return 0, false
+		}
+		width = addWidth(width, w)
+		pc = next
+	}
+	return width, true
+}
+
+// next returns the first offset at or after offset where a match could start or -1 when no match can.
+// The offsets passed in must never go backwards since we reuse the last occurrence we found.
+func (p *prefilter) next(reader *files.Reader, offset int) int {
+	if p.found == -1 {
+		return -1
+	}
+	if p.found < offset {
+		p.found = reader.Index(offset, p.literal)
+		if p.found == -1 {
+			return -1
+		}
+	}
+	if p.maxOffset == -1 || p.found-p.maxOffset < offset {
+		return offset
+	}
+	return p.found - p.maxOffset
+}
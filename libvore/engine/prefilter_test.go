@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jmeaster30/vore/libvore/files"
+	"github.com/jmeaster30/vore/libvore/testutils"
+)
+
+func TestPrefilterLiteralPrefix(t *testing.T) {
+	filter := buildPrefilter(compileFind(t, "find all 'hello' ' ' at least 1 letter").Body)
+	testutils.AssertTrue(t, filter != nil)
+	testutils.AssertEqual(t, "hello ", filter.literal)
+	testutils.AssertEqual(t, 0, filter.maxOffset)
+}
+
+func TestPrefilterBoundedOffset(t *testing.T) {
+	filter := buildPrefilter(compileFind(t, "find all between 1 and 3 digit 'px'").Body)
+	testutils.AssertTrue(t, filter != nil)
+	testutils.AssertEqual(t, "px", filter.literal)
+	testutils.AssertEqual(t, 12, filter.maxOffset)
+}
+
+func TestPrefilterPrefersBoundedLiteral(t *testing.T) {
+	filter := buildPrefilter(compileFind(t, "find all 'a' at least 1 letter 'longer'").Body)
+	testutils.AssertTrue(t, filter != nil)
+	testutils.AssertEqual(t, "a", filter.literal)
+	testutils.AssertEqual(t, 0, filter.maxOffset)
+}
+
+func TestPrefilterEmail(t *testing.T) {
+	filter := buildPrefilter(compileFind(t, `find all
+  word start
+  at least 1 in letter, digit, ".", "_", "%", "+", "-"
+  "@"
+  at least 1 in letter, digit, ".", "-"
+  "."
+  at least 2 letter
+  word end`).Body)
+	testutils.AssertTrue(t, filter != nil)
+	testutils.AssertEqual(t, "@", filter.literal)
+	testutils.AssertEqual(t, -1, filter.maxOffset)
+}
+
+func TestPrefilterThroughVariables(t *testing.T) {
+	filter := buildPrefilter(compileFind(t, "find all ('ab' digit) = x ':' x").Body)
+	testutils.AssertTrue(t, filter != nil)
+	testutils.AssertEqual(t, "ab", filter.literal)
+	testutils.AssertEqual(t, 0, filter.maxOffset)
+}
+
+func TestPrefilterNone(t *testing.T) {
+	for _, source := range []string{
+		"find all at least 1 letter",
+		"find all 'a' or 'b'",
+		"find all maybe 'a'",
+		"find all caseless 'abc'",
+		"find all 'a' followed by 'b' = x",
+	} {
+		filter := buildPrefilter(compileFind(t, source).Body)
+		if source == "find all 'a' followed by 'b' = x" {
+			testutils.AssertTrue(t, filter != nil)
+			testutils.AssertEqual(t, "a", filter.literal)
+			continue
+		}
+		testutils.AssertEqualLabel(t, source, true, filter == nil)
+	}
+}
+
+func TestPrefilterKeepsMatches(t *testing.T) {
+	inputs := []string{
+		"hello world hello there",
+		"1px 22px 333px 4444px px",
+		"ab1:ab1 ab2:ab3 xab9:ab9",
+		"mail me at lilith@example.com or at bob_2@mail.co.uk",
+		"éé@a.bc ab\n@\nx@y.zz",
+		"",
+	}
+	patterns := []string{
+		"find all 'hello' ' ' at least 1 letter",
+		"find all between 1 and 3 digit 'px'",
+		"find all ('ab' digit) = x ':' x",
+		"find skip 1 take 1 at least 1 letter '@'",
+		"find last 1 at least 1 any fewest '@'",
+		"find all (at least 1 in letter, digit, '.', '_') = user '@' at least 1 in letter, '.' '.' at least 2 letter",
+		"find all word start at least 1 in letter, digit, '.', '_', '%', '+', '-' '@' at least 1 in letter, digit, '.', '-' '.' at least 2 letter word end",
+	}
+	for _, source := range patterns {
+		find := compileFind(t, source)
+		filter := buildPrefilter(find.Body)
+		testutils.AssertTrue(t, filter != nil)
+		a, lowered := lowerToAutomaton(find.Body)
+		for _, input := range inputs {
+			expected, err := findBacktrackMatches(context.Background(), find.Body, find.Spans, find.All, find.Skip, find.Take, find.Last, find.Overlapping, find.Tree, "text", files.ReaderFromString(input), Limits{}, nil)
+			testutils.CheckNoError(t, err)
+			filtered := buildPrefilter(find.Body)
+			actual, err := findBacktrackMatches(context.Background(), find.Body, find.Spans, find.All, find.Skip, find.Take, find.Last, find.Overlapping, find.Tree, "text", files.ReaderFromString(input), Limits{}, filtered)
+			testutils.CheckNoError(t, err)
+			sameMatches(t, source+" on "+input, expected, actual)
+			if lowered {
+				actual, err = findAutomatonMatches(context.Background(), a, find.All, find.Skip, find.Take, find.Last, find.Overlapping, "text", files.ReaderFromString(input), buildPrefilter(find.Body))
+				testutils.CheckNoError(t, err)
+				sameMatches(t, source+" on "+input+" (automaton)", expected, actual)
+			}
+		}
+	}
+}
+
+func TestPrefilterKeepsMatchesInFile(t *testing.T) {
+	contents, err := os.ReadFile("../../docs/examples/frankenstein/frankenstein.txt")
+	testutils.CheckNoError(t, err)
+	for _, source := range []string{
+		"find all 'Elizabeth' at least 1 whitespace",
+		"find all ('my' ' ' at least 1 letter) = phrase",
+	} {
+		find := compileFind(t, source)
+		expected, err := findBacktrackMatches(context.Background(), find.Body, find.Spans, find.All, find.Skip, find.Take, find.Last, find.Overlapping, find.Tree, "text", files.ReaderFromString(string(contents)), Limits{}, nil)
+		testutils.CheckNoError(t, err)
+		actual, err := findMatches(context.Background(), find.Body, find.Spans, find.All, find.Skip, find.Take, find.Last, find.Overlapping, find.Tree, "text", files.ReaderFromString(string(contents)), Limits{})
+		testutils.CheckNoError(t, err)
+		sameMatches(t, source, expected, actual)
+	}
+}
+
+func sameMatches(t *testing.T, label string, expected Matches, actual Matches) {
+	t.Helper()
+	testutils.AssertEqualLabel(t, label, len(expected), len(actual))
+	for i := range expected {
+		testutils.AssertEqualLabel(t, label, expected[i].MatchNumber, actual[i].MatchNumber)
+		testutils.AssertEqualLabel(t, label, expected[i].Value, actual[i].Value)
+		testutils.AssertEqualLabel(t, label, expected[i].Offset, actual[i].Offset)
+		testutils.AssertEqualLabel(t, label, expected[i].Line, actual[i].Line)
+		testutils.AssertEqualLabel(t, label, expected[i].Column, actual[i].Column)
+	}
+}
@@ -1,7 +1,10 @@
 package engine
 
 import (
+	"context"
 	"fmt"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/jmeaster30/vore/libvore/ast"
 	"github.com/jmeaster30/vore/libvore/bytecode"
@@ -9,39 +12,126 @@ import (
 	"github.com/jmeaster30/vore/libvore/files"
 )
 
-func search(command *bytecode.Command, filename string, reader *files.Reader, mode ReplaceMode) Matches {
+func search(ctx context.Context, command *bytecode.Command, filename string, reader *files.Reader, mode ReplaceMode, limits Limits) (result Matches, err error) {
+	// a bug in the engine shouldn't crash whatever program is using it
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = Matches{}, NewInternalError(filename, r)
+		}
+	}()
+
 	var ci any = *command
 	switch com := ci.(type) {
 	case bytecode.FindCommand:
-		return searchFind(&com, filename, reader, mode)
+		return searchFind(ctx, &com, filename, reader, mode, limits)
 	case bytecode.ReplaceCommand:
-		return searchReplace(&com, filename, reader, mode)
+		return searchReplace(ctx, &com, filename, reader, mode, limits)
 	case bytecode.SetCommand:
-		return Matches{}
+		return Matches{}, nil
 	}
 	panic(fmt.Sprintf("Unknown command %T", ci))
 }
 
-func findMatches(insts []bytecode.SearchInstruction, all bool, skip int, take int, last int, filename string, reader *files.Reader) Matches {
+// findMatches runs match attempts from the start of the file. Normally the next attempt starts where the last match
+// ended but when overlapping is set it starts one rune after where the last match started.
+func findMatches(ctx context.Context, insts []bytecode.SearchInstruction, spans []ast.Span, all bool, skip int, take int, last int, overlapping bool, tree bool, filename string, reader *files.Reader, limits Limits) (Matches, error) {
+	if reader.AtEnd(0) {
+		return Matches{}, nil
+	}
+	var filter *prefilter
+	// the prefilter can look arbitrarily far ahead which would make a stream hold onto everything in between
+	if !reader.Streaming() {
+		filter = buildPrefilter(insts)
+	}
+	// the automaton runs in linear time but it doesn't keep track of calls for a tree and the limits are counted
+	// in the work the backtracker does so they can only be checked there
+	if a, ok := lowerToAutomaton(insts); ok && !tree && !limits.limitsBacktracking() {
+		return findAutomatonMatches(ctx, a, all, skip, take, last, overlapping, filename, reader, filter)
+	}
+	return findBacktrackMatches(ctx, insts, spans, all, skip, take, last, overlapping, tree, filename, reader, limits, filter)
+}
+
+func skipRune(reader *files.Reader, fileOffset int, lineNumber int, columnNumber int) (int, int, int) {
+	skipC, width := reader.ReadRuneAt(fileOffset)
+	if width == 0 {
+		panic("WOW THAT IS NOT GOOD :(")
+	}
+	fileOffset += width
+	columnNumber += 1
+	if skipC == rune('\n') {
+		lineNumber += 1
+		columnNumber = 1
+	}
+	return fileOffset, lineNumber, columnNumber
+}
+
+// how many instructions a match attempt runs between checks for cancellation
+const cancelCheckInterval = 1 << 10
+
+func findBacktrackMatches(ctx context.Context, insts []bytecode.SearchInstruction, spans []ast.Span, all bool, skip int, take int, last int, overlapping bool, tree bool, filename string, reader *files.Reader, limits Limits, filter *prefilter) (Matches, error) {
 	matches := ds.NewQueue[Match]()
 	matchNumber := 0
 	fileOffset := 0
 	lineNumber := 1
 	columnNumber := 1
-
-	if reader.Size() == 0 {
-		return Matches{}
+	fileSteps := 0
+	reach, lookbehind := lookbehindReach(insts)
+	lines := lineStarts{0}
+	scanned := 0
+	memo := newSubroutineMemo(insts)
+
+	if reader.AtEnd(0) {
+		return Matches{}, nil
 	}
 
 	for all || matchNumber < skip+take {
+		if err := ctx.Err(); err != nil {
+			return matches.Contents(), err
+		}
+
+		if filter != nil {
+			next := filter.next(reader, fileOffset)
+			if next == -1 {
+				break
+			}
+			for fileOffset < next {
+				fileOffset, lineNumber, columnNumber = skipRune(reader, fileOffset, lineNumber, columnNumber)
+			}
+		}
+
+		if reach != -1 {
+			release := fileOffset - reach
+			if lookbehind {
+				lines = lines.advance(reader, scanned, fileOffset)
+				scanned = fileOffset
+				lines, release = lines.before(release)
+			}
+			reader.Release(release)
+		}
+
 		currentState := CreateState(filename, reader, fileOffset, lineNumber, columnNumber)
+		memo.reset()
+		currentState.memo = memo
+		currentState.buildTree = tree
+		steps := 0
 		for currentState.status == INPROCESS {
-			inst := insts[currentState.programCounter]
-			currentState = matchInstruction(inst, currentState)
+			pc := currentState.programCounter
+			inst := insts[pc]
+			matchInstruction(inst, currentState)
 			// fmt.Printf("PC: %d INST: %+v STATE: %+v\n", currentState.programCounter, inst, currentState)
 			if currentState.status == INPROCESS && currentState.programCounter >= len(insts) {
 				currentState.SUCCESS()
 			}
+			steps += 1
+			fileSteps += 1
+			if err := limits.check(steps, fileSteps, insts, spans, pc, currentState); err != nil {
+				return matches.Contents(), err
+			}
+			if steps%cancelCheckInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return matches.Contents(), err
+				}
+			}
 		}
 
 		if currentState.status == SUCCESS && len(currentState.currentMatch) != 0 && matchNumber >= skip {
@@ -51,48 +141,105 @@ func findMatches(insts []bytecode.SearchInstruction, all bool, skip int, take in
 			if last != 0 {
 				matches.Limit(last)
 			}
-			fileOffset = currentState.currentFileOffset
-			lineNumber = currentState.currentLineNum
-			columnNumber = currentState.currentColumnNum
+			if overlapping {
+				fileOffset, lineNumber, columnNumber = skipRune(reader, fileOffset, lineNumber, columnNumber)
+			} else {
+				fileOffset = currentState.currentFileOffset
+				lineNumber = currentState.currentLineNum
+				columnNumber = currentState.currentColumnNum
+			}
 			matchNumber += 1
 		} else {
 			// fmt.Println("====== FAILED  ======")
 			if currentState.status == SUCCESS && len(currentState.currentMatch) != 0 {
 				matchNumber += 1
 			}
-			skipC := reader.ReadAt(1, fileOffset)
-			if len(skipC) != 1 {
-				panic("WOW THAT IS NOT GOOD :(")
-			}
-			fileOffset += 1
-			columnNumber += 1
-			if rune(skipC[0]) == rune('\n') {
-				lineNumber += 1
-				columnNumber = 1
-			}
+			fileOffset, lineNumber, columnNumber = skipRune(reader, fileOffset, lineNumber, columnNumber)
 		}
 
-		if fileOffset >= reader.Size() {
+		if currentState.err != nil {
+			return matches.Contents(), currentState.err
+		}
+
+		if reader.AtEnd(fileOffset) {
 			break
 		}
 	}
 
-	return matches.Contents()
+	return matches.Contents(), nil
+}
+
+// lookbehindReach is how many bytes before the start of a match attempt the instructions can read or -1 when there is
+// no bound. It also says if there is a lookbehind since those need the start of the line they end up on as well.
+func lookbehindReach(insts []bytecode.SearchInstruction) (int, bool) {
+	// anchors look at the rune right before the current offset
+	reach := utf8.UTFMax
+	lookbehind := false
+	for _, inst := range insts {
+		var ii any = inst
+		if lookaround, ok := ii.(bytecode.StartLookaround); ok && lookaround.Behind {
+			if lookaround.MaxLength == -1 {
+				return -1, true
+			}
+			// a lookbehind inside of another one starts from wherever the outer one went back to so they add up
+			reach += lookaround.MaxLength * utf8.UTFMax
+			lookbehind = true
+		}
+	}
+	return reach, lookbehind
+}
+
+// lineStarts are the offsets of the lines a lookbehind can still walk back into. A lookbehind that crosses a newline
+// finds its column by reading back to the start of the line it ends up on so a stream has to keep those lines.
+type lineStarts []int
+
+// advance adds the lines that start between from and to
+func (l lineStarts) advance(reader *files.Reader, from int, to int) lineStarts {
+	if to <= from {
+		return l
+	}
+	text := reader.ReadAt(to-from, from)
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			l = append(l, from+i+1)
+		}
+	}
+	return l
+}
+
+// before forgets the lines that end before offset and gives the start of the line offset is on
+func (l lineStarts) before(offset int) (lineStarts, int) {
+	for len(l) > 1 && l[1] <= offset {
+		l = l[1:]
+	}
+	return l, l[0]
 }
 
-func searchFind(c *bytecode.FindCommand, filename string, reader *files.Reader, mode ReplaceMode) Matches {
-	return findMatches(c.Body, c.All, c.Skip, c.Take, c.Last, filename, reader)
+func searchFind(ctx context.Context, c *bytecode.FindCommand, filename string, reader *files.Reader, mode ReplaceMode, limits Limits) (Matches, error) {
+	return findMatches(ctx, c.Body, c.Spans, c.All, c.Skip, c.Take, c.Last, c.Overlapping, c.Tree, filename, reader, limits)
 }
 
-func searchReplace(c *bytecode.ReplaceCommand, filename string, reader *files.Reader, mode ReplaceMode) Matches {
-	foundMatches := findMatches(c.Body, c.All, c.Skip, c.Take, c.Last, filename, reader)
+func searchReplace(ctx context.Context, c *bytecode.ReplaceCommand, filename string, reader *files.Reader, mode ReplaceMode, limits Limits) (Matches, error) {
+	foundMatches, err := findMatches(ctx, c.Body, c.Spans, c.All, c.Skip, c.Take, c.Last, false, false, filename, reader, limits)
+	if err == nil && reader.Err() != nil {
+		err = NewFileError(filename, reader.Err())
+	}
+	if err != nil {
+		// don't write out a partially replaced file
+		reader.Close()
+		return Matches{}, err
+	}
 
 	replacedMatches := Matches{}
 	for _, match := range foundMatches {
 		current_state := InitReplacerState(match, len(foundMatches))
 		for current_state.programCounter < len(c.Replacer) {
 			inst := c.Replacer[current_state.programCounter]
-			current_state = executeReplace(inst, current_state)
+			current_state, err = executeReplace(inst, current_state)
+			if err != nil {
+				reader.Close()
+				return Matches{}, inFile(err, filename)
+			}
 		}
 		replacedMatches = append(replacedMatches, current_state.match)
 	}
@@ -101,16 +248,24 @@ func searchReplace(c *bytecode.ReplaceCommand, filename string, reader *files.Re
 	replaceReader := reader
 	switch mode {
 	case NEW:
-		writer = files.WriterFromFile(filename + ".vored")
+		writer, err = files.WriterFromFile(filename + ".vored")
 	case OVERWRITE:
 		// If we are overwriting the file we have to load the original
 		// into memory since we will be writing over areas of text that
 		// we need to read from
-		replaceReader = files.ReaderFromFileToMemory(filename)
-		writer = files.WriterFromFile(filename)
-	case NOTHING:
+		reader.Close()
+		replaceReader, err = files.ReaderFromFileToMemory(filename)
+		if err != nil {
+			return Matches{}, NewFileError(filename, err)
+		}
+		writer, err = files.WriterFromFile(filename)
+	default:
 		writer = files.WriterFromMemory()
 	}
+	if err != nil {
+		replaceReader.Close()
+		return Matches{}, NewFileError(filename, err)
+	}
 
 	lastReaderOffset := 0
 	currentWriterOffset := 0
@@ -118,275 +273,335 @@ func searchReplace(c *bytecode.ReplaceCommand, filename string, reader *files.Re
 		// read from where we left off to the next replacedMatch
 		currentReaderLength := replacedMatches[i].Offset.Start - lastReaderOffset
 		orig := replaceReader.ReadAt(currentReaderLength, lastReaderOffset)
-		writer.WriteAt(currentWriterOffset, orig)
+		err = writer.WriteAt(currentWriterOffset, orig)
+		if err != nil {
+			break
+		}
 		currentWriterOffset += currentReaderLength
 		lastReaderOffset += currentReaderLength
 
 		// write the replacement. We have to update the lastReaderOffset with the part of the string that was matched
-		writer.WriteAt(currentWriterOffset, replacedMatches[i].Replacement.GetValueOrDefault(""))
+		err = writer.WriteAt(currentWriterOffset, replacedMatches[i].Replacement.GetValueOrDefault(""))
+		if err != nil {
+			break
+		}
 		currentWriterOffset += len(replacedMatches[i].Replacement.GetValueOrDefault(""))
 		lastReaderOffset += len(replacedMatches[i].Value)
 	}
-	if lastReaderOffset < replaceReader.Size() {
-		outputValue := replaceReader.ReadAt(reader.Size()-lastReaderOffset, lastReaderOffset)
-		writer.WriteAt(currentWriterOffset, outputValue)
+	if err == nil && lastReaderOffset < replaceReader.Size() {
+		outputValue := replaceReader.ReadAt(replaceReader.Size()-lastReaderOffset, lastReaderOffset)
+		err = writer.WriteAt(currentWriterOffset, outputValue)
+	}
+	if err == nil {
+		err = replaceReader.Err()
 	}
 
-	writer.Close()
+	closeErr := writer.Close()
 	replaceReader.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Matches{}, NewFileError(filename, err)
+	}
 
-	return replacedMatches
+	return replacedMatches, nil
 }
 
-func matchInstruction(i bytecode.SearchInstruction, current_state *SearchEngineState) *SearchEngineState {
+func matchInstruction(i bytecode.SearchInstruction, state *SearchEngineState) {
 	var ii any = i
 	switch si := ii.(type) {
 	case bytecode.MatchLiteral:
-		return matchLiteral(si, current_state)
+		matchLiteral(si, state)
 	case bytecode.MatchCharClass:
-		return matchCharClass(si, current_state)
+		matchCharClass(si, state)
+	case bytecode.MatchUnicodeClass:
+		matchUnicodeClass(si, state)
 	case bytecode.MatchVariable:
-		return matchVariable(si, current_state)
+		matchVariable(si, state)
 	case bytecode.MatchRange:
-		return matchRange(si, current_state)
+		matchRange(si, state)
+	case bytecode.MatchFuzzy:
+		matchFuzzy(si, state)
+	case bytecode.MatchNumber:
+		matchNumber(si, state)
+	case bytecode.MatchTerms:
+		matchTerms(si, state)
+	case bytecode.StartAtomic:
+		matchStartAtomic(si, state)
+	case bytecode.EndAtomic:
+		matchEndAtomic(si, state)
 	case bytecode.CallSubroutine:
-		return matchCallSubroutine(si, current_state)
+		matchCallSubroutine(si, state)
 	case bytecode.Branch:
-		return matchBranch(si, current_state)
+		matchBranch(si, state)
+	case bytecode.StartNotInList:
+		matchStartNotInList(si, state)
 	case bytecode.StartNotIn:
-		return matchStartNotIn(si, current_state)
+		matchStartNotIn(si, state)
 	case bytecode.EndNotIn:
-		return matchEndNotIn(si, current_state)
+		matchEndNotIn(si, state)
 	case bytecode.FailNotIn:
-		return matchFailNotIn(si, current_state)
+		matchFailNotIn(si, state)
+	case bytecode.StartLookaround:
+		matchStartLookaround(si, state)
+	case bytecode.EndLookaround:
+		matchEndLookaround(si, state)
 	case bytecode.StartLoop:
-		return matchStartLoop(si, current_state)
+		matchStartLoop(si, state)
 	case bytecode.StopLoop:
-		return matchStopLoop(si, current_state)
+		matchStopLoop(si, state)
+	case bytecode.IfVariable:
+		matchIfVariable(si, state)
 	case bytecode.StartVarDec:
-		return matchStartVarDec(si, current_state)
+		matchStartVarDec(si, state)
 	case bytecode.EndVarDec:
-		return matchEndVarDec(si, current_state)
+		matchEndVarDec(si, state)
 	case bytecode.StartSubroutine:
-		return matchStartSubroutine(si, current_state)
+		matchStartSubroutine(si, state)
 	case bytecode.EndSubroutine:
-		return matchEndSubroutine(si, current_state)
+		matchEndSubroutine(si, state)
 	case bytecode.Jump:
-		return matchJump(si, current_state)
+		matchJump(si, state)
+	default:
+		panic(fmt.Sprintf("Unknown search instruction %T", ii))
 	}
-	panic(fmt.Sprintf("Unknown search instruction %T", ii))
 }
 
-func matchLiteral(i bytecode.MatchLiteral, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	next_state.MATCH(i.ToFind, i.Not, i.Caseless)
-	return next_state
+func matchLiteral(i bytecode.MatchLiteral, state *SearchEngineState) {
+	state.MATCH(i.ToFind, i.Not, i.Caseless)
 }
 
-func matchCharClass(i bytecode.MatchCharClass, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
+func matchCharClass(i bytecode.MatchCharClass, state *SearchEngineState) {
 	switch i.Class {
 	case ast.ClassAny:
-		next_state.MATCHANY(i.Not)
+		state.MATCHANY(i.Not)
 	case ast.ClassWhitespace:
-		next_state.MATCHOPTIONS([]string{" ", "\t", "\n", "\r"}, i.Not)
+		state.MATCHOPTIONS([]string{" ", "\t", "\n", "\r"}, i.Not)
 	case ast.ClassDigit:
-		next_state.MATCHRANGE("0", "9", i.Not)
+		state.MATCHRANGE("0", "9", i.Not, false)
 	case ast.ClassUpper:
-		next_state.MATCHRANGE("A", "Z", i.Not)
+		state.MATCHRUNE(unicode.IsUpper, i.Not)
 	case ast.ClassLower:
-		next_state.MATCHRANGE("a", "z", i.Not)
+		state.MATCHRUNE(unicode.IsLower, i.Not)
 	case ast.ClassLetter:
-		next_state.MATCHLETTER(i.Not)
+		state.MATCHRUNE(IsLetter, i.Not)
+	case ast.ClassWord:
+		state.MATCHRUNE(IsWordCharacter, i.Not)
 	case ast.ClassFileStart:
-		next_state.MATCHFILESTART(i.Not)
+		state.MATCHFILESTART(i.Not)
 	case ast.ClassFileEnd:
-		next_state.MATCHFILEEND(i.Not)
+		state.MATCHFILEEND(i.Not)
 	case ast.ClassLineStart:
-		next_state.MATCHLINESTART(i.Not)
+		state.MATCHLINESTART(i.Not)
 	case ast.ClassLineEnd:
-		next_state.MATCHLINEEND(i.Not)
+		state.MATCHLINEEND(i.Not)
 	case ast.ClassWordStart:
-		next_state.MATCHWORDSTART(i.Not)
+		state.MATCHWORDSTART(i.Not)
 	case ast.ClassWordEnd:
-		next_state.MATCHWORDEND(i.Not)
+		state.MATCHWORDEND(i.Not)
 	case ast.ClassWholeFile:
-		next_state.MATCHWHOLEFILE(i.Not)
+		state.MATCHWHOLEFILE(i.Not)
 	case ast.ClassWholeLine:
-		next_state.MATCHWHOLELINE(i.Not)
+		state.MATCHWHOLELINE(i.Not)
 	case ast.ClassWholeWord:
-		next_state.MATCHWHOLEWORD(i.Not)
+		state.MATCHWHOLEWORD(i.Not)
 	default:
 		panic("Unexpected character class type")
 	}
-	return next_state
 }
 
-func matchVariable(i bytecode.MatchVariable, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	next_state.MATCHVAR(i.Name)
-	return next_state
+func matchUnicodeClass(i bytecode.MatchUnicodeClass, state *SearchEngineState) {
+	table, _ := ast.UnicodeTable(i.Class, i.Name)
+	state.MATCHRUNE(func(r rune) bool {
+		return unicode.Is(table, r)
+	}, i.Not)
 }
 
-func matchRange(i bytecode.MatchRange, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	next_state.MATCHRANGE(i.From, i.To, i.Not)
-	return next_state
+func matchVariable(i bytecode.MatchVariable, state *SearchEngineState) {
+	state.MATCHVAR(i.Name, i.Caseless)
 }
 
-func matchCallSubroutine(i bytecode.CallSubroutine, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	next_state.CALL(i.ToPC, next_state.programCounter+1)
-	next_state.JUMP(i.ToPC)
-	return next_state
+func matchRange(i bytecode.MatchRange, state *SearchEngineState) {
+	state.MATCHRANGE(i.From, i.To, i.Not, i.Caseless)
 }
 
-func matchBranch(i bytecode.Branch, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
+func matchStartAtomic(i bytecode.StartAtomic, state *SearchEngineState) {
+	state.STARTATOMIC()
+}
+
+func matchEndAtomic(i bytecode.EndAtomic, state *SearchEngineState) {
+	state.ENDATOMIC()
+}
+
+func matchTerms(i bytecode.MatchTerms, state *SearchEngineState) {
+	state.MATCHTERMS(i.Terms, i.WholeWord)
+}
+
+func matchNumber(i bytecode.MatchNumber, state *SearchEngineState) {
+	state.MATCHNUMBER(i.Min, i.Max, i.LeadingZeros, i.Signs, i.Decimals)
+}
+
+func matchFuzzy(i bytecode.MatchFuzzy, state *SearchEngineState) {
+	if i.Variable == "" {
+		state.MATCHFUZZY(i.ToFind, i.MaxEdits, i.Swaps, i.Caseless, i.Name)
+		return
+	}
+	variable, found := state.LOOKUPVARIABLE(i.Variable)
+	if !found || variable.value.Type() == bytecode.ValueType_Map {
+		state.BACKTRACK()
+		return
+	}
+	state.MATCHFUZZY(variable.value.String(), i.MaxEdits, i.Swaps, i.Caseless, i.Name)
+}
+
+func matchCallSubroutine(i bytecode.CallSubroutine, state *SearchEngineState) {
+	if state.memo.enabled(i.ToPC) {
+		state.MEMOCALL(i.ToPC, state.programCounter+1)
+		return
+	}
+	if !state.CALL(i.ToPC, state.programCounter+1) {
+		state.BACKTRACK()
+		return
+	}
+	state.JUMP(i.ToPC)
+}
+
+func matchBranch(i bytecode.Branch, state *SearchEngineState) {
 	flipped := []int{}
 	for k := range i.Branches {
 		flipped = append(flipped, i.Branches[len(i.Branches)-1-k])
 	}
 
 	for _, f := range flipped[:len(flipped)-1] {
-		next_state.JUMP(f)
-		next_state.CHECKPOINT()
+		state.JUMP(f)
+		state.CHECKPOINT()
 	}
 
-	next_state.JUMP(i.Branches[0])
-	return next_state
+	state.JUMP(i.Branches[0])
 }
 
-func matchStartNotIn(i bytecode.StartNotIn, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	next_state.JUMP(i.NextCheckpointPC)
-	next_state.CHECKPOINT()
-	next_state.JUMP(current_state.programCounter + 1)
-	return next_state
+func matchStartNotInList(i bytecode.StartNotInList, state *SearchEngineState) {
+	state.STARTNOTIN(i.MaxSize)
 }
 
-func matchFailNotIn(i bytecode.FailNotIn, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	next_state.BACKTRACK()
-	next_state.BACKTRACK()
-	return next_state
+func matchStartNotIn(i bytecode.StartNotIn, state *SearchEngineState) {
+	pc := state.GETPC()
+	state.JUMP(i.NextCheckpointPC)
+	state.CHECKPOINT()
+	state.JUMP(pc + 1)
 }
 
-func matchEndNotIn(i bytecode.EndNotIn, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	// TODO this should actually let the rest of the expression backtrack from max size to min size (could just be to 1 since things less than the min are not in)
-	cfo := next_state.currentFileOffset
-	next_state.CONSUME(i.MaxSize)
-	// FIXME: This was added to make it so we don't have an infinite loop when using "not in" in an un-bounded loop
-	//        I think a better fix would be to come up with a different way to handle the end of the file
-	if cfo == next_state.currentFileOffset {
-		next_state.BACKTRACK()
+func matchFailNotIn(i bytecode.FailNotIn, state *SearchEngineState) {
+	state.FAILNOTIN()
+}
+
+func matchEndNotIn(i bytecode.EndNotIn, state *SearchEngineState) {
+	state.ENDNOTIN()
+}
+
+func matchStartLookaround(i bytecode.StartLookaround, state *SearchEngineState) {
+	if i.Behind {
+		state.STARTLOOKBEHIND(i.Not, i.MaxLength, i.EndPC)
 	} else {
-		next_state.NEXT()
+		state.STARTLOOKAHEAD(i.Not, i.EndPC)
 	}
-	return next_state
 }
 
-func matchStartLoop(i bytecode.StartLoop, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
+func matchEndLookaround(i bytecode.EndLookaround, state *SearchEngineState) {
+	state.ENDLOOKAROUND(i.Not, i.Behind)
+}
 
-	inited := next_state.INITLOOPSTACK(i.Id, i.Name)
+func matchStartLoop(i bytecode.StartLoop, state *SearchEngineState) {
+	inited := state.INITLOOPSTACK(i.Id, i.Name)
 	if !inited {
-		if next_state.CHECKZEROMATCHLOOP() {
-			next_state.BACKTRACK()
-			return next_state
+		if state.CHECKZEROMATCHLOOP() {
+			state.BACKTRACK()
+			return
 		}
-		next_state.INCLOOPSTACK()
+		state.INCLOOPSTACK()
 	}
-	currentIteration := next_state.GETITERATIONSTEP()
+	currentIteration := state.GETITERATIONSTEP()
 
 	if currentIteration < i.MinLoops {
-		next_state.NEXT()
+		state.NEXT()
 	} else if (i.MaxLoops == -1 || currentIteration <= i.MaxLoops) && i.Fewest {
-		next_state.NEXT()
-		next_state.CHECKPOINT()
-		next_state.POPLOOPSTACK()
-		next_state.JUMP(i.ExitLoop + 1)
+		state.NEXT()
+		state.CHECKPOINT()
+		state.POPLOOPSTACK()
+		state.JUMP(i.ExitLoop + 1)
 	} else if (i.MaxLoops == -1 || currentIteration <= i.MaxLoops) && !i.Fewest {
-		loop_state := next_state.POPLOOPSTACK()
-		pc := next_state.GETPC()
-		next_state.JUMP(i.ExitLoop + 1)
-		next_state.CHECKPOINT()
-		next_state.PUSHLOOPSTACK(loop_state)
-		next_state.JUMP(pc + 1)
+		loop_state := state.POPLOOPSTACK()
+		pc := state.GETPC()
+		state.JUMP(i.ExitLoop + 1)
+		state.CHECKPOINT()
+		state.PUSHLOOPSTACK(loop_state)
+		state.JUMP(pc + 1)
 	} else {
-		next_state.BACKTRACK()
+		state.BACKTRACK()
 	}
-
-	return next_state
 }
 
-func matchStopLoop(i bytecode.StopLoop, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	next_state.JUMP(i.StartLoop)
-	return next_state
+func matchStopLoop(i bytecode.StopLoop, state *SearchEngineState) {
+	state.JUMP(i.StartLoop)
 }
 
-func matchStartVarDec(i bytecode.StartVarDec, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	next_state.STARTVAR(i.Name)
-	return next_state
+func matchIfVariable(i bytecode.IfVariable, state *SearchEngineState) {
+	if state.HASVARIABLE(i.Name) {
+		state.NEXT()
+	} else {
+		state.JUMP(i.ElsePC)
+	}
 }
 
-func matchEndVarDec(i bytecode.EndVarDec, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	next_state.ENDVAR(i.Name)
-	return next_state
+func matchStartVarDec(i bytecode.StartVarDec, state *SearchEngineState) {
+	state.STARTVAR(i.Name)
 }
 
-func matchStartSubroutine(i bytecode.StartSubroutine, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	next_state.VALIDATECALL(i.Id, i.EndOffset+1)
-	next_state.NEXT()
-	return next_state
+func matchEndVarDec(i bytecode.EndVarDec, state *SearchEngineState) {
+	state.ENDVAR(i.Name)
 }
 
-func matchEndSubroutine(i bytecode.EndSubroutine, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
+func matchStartSubroutine(i bytecode.StartSubroutine, state *SearchEngineState) {
+	state.VALIDATECALL(i.Id, i.EndOffset+1)
+	state.NEXT()
+}
 
+func matchEndSubroutine(i bytecode.EndSubroutine, state *SearchEngineState) {
 	if len(i.Validate) == 0 {
-		next_state.RETURN()
+		state.RETURN(i.Name)
 	} else {
 		env := bytecode.NewEmptyMap()
-		subMatch := current_state.currentMatch[current_state.callStack.Peek().GetValue().startMatchOffset:]
+		subMatch := state.currentMatch[state.callStack.Peek().GetValue().startMatchOffset:]
 		env.Set("match", bytecode.NewString(subMatch))
 		env.Set("matchLength", bytecode.NewNumber(len(subMatch)))
 		// TODO add more variables here!
 
 		finalValue, err := executeProcessInstructions(i.Validate, env)
 		if err != nil {
-			// fmt.Printf("ERROR ERROR ERROR [%T] %s\n", err, (err.(ExecError)).Message())
-			panic(err)
+			state.ERROR(inFile(err, state.filename))
+			return
 		}
 
 		if finalValue.GetValueOrDefault(bytecode.NewBoolean(true)).Boolean() {
-			next_state.RETURN()
+			state.RETURN(i.Name)
 		} else {
-			next_state.BACKTRACK()
+			state.BACKTRACK()
 		}
 	}
-
-	return next_state
 }
 
-func matchJump(i bytecode.Jump, current_state *SearchEngineState) *SearchEngineState {
-	next_state := current_state.Copy()
-	next_state.JUMP(i.NewProgramCounter)
-	return next_state
+func matchJump(i bytecode.Jump, state *SearchEngineState) {
+	state.JUMP(i.NewProgramCounter)
 }
 
-func executeReplace(i bytecode.ReplaceInstruction, current_state *ReplacerState) *ReplacerState {
+func executeReplace(i bytecode.ReplaceInstruction, current_state *ReplacerState) (*ReplacerState, error) {
 	var ii any = i
 	switch ri := ii.(type) {
 	case bytecode.ReplaceString:
-		return executeReplaceString(ri, current_state)
+		return executeReplaceString(ri, current_state), nil
 	case bytecode.ReplaceVariable:
-		return executeReplaceVariable(ri, current_state)
+		return executeReplaceVariable(ri, current_state), nil
 	case bytecode.ReplaceProcess:
 		return executeReplaceProcess(ri, current_state)
 	}
@@ -407,7 +622,7 @@ func executeReplaceVariable(i bytecode.ReplaceVariable, current_state *ReplacerS
 	return next_state
 }
 
-func executeReplaceProcess(i bytecode.ReplaceProcess, current_state *ReplacerState) *ReplacerState {
+func executeReplaceProcess(i bytecode.ReplaceProcess, current_state *ReplacerState) (*ReplacerState, error) {
 	next_state := current_state.Copy()
 
 	// execute AST
@@ -419,10 +634,10 @@ func executeReplaceProcess(i bytecode.ReplaceProcess, current_state *ReplacerSta
 
 	finalValue, err := executeProcessInstructions(i.Process, env)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	next_state.WRITESTRING(finalValue.GetValueOrDefault(bytecode.NewString("")).String())
 	next_state.NEXT()
-	return next_state
+	return next_state, nil
 }
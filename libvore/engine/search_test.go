@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jmeaster30/vore/libvore/files"
+	"github.com/jmeaster30/vore/libvore/testutils"
+)
+
+func TestLookbehindReach(t *testing.T) {
+	reach, lookbehind := lookbehindReach(compileFind(t, "find all 'a'").Body)
+	testutils.AssertEqual(t, 4, reach)
+	testutils.AssertFalse(t, lookbehind)
+
+	reach, lookbehind = lookbehindReach(compileFind(t, "find all preceded by (between 1 and 3 'a') 'b'").Body)
+	testutils.AssertEqual(t, 16, reach)
+	testutils.AssertTrue(t, lookbehind)
+}
+
+func TestLineStarts(t *testing.T) {
+	reader := files.ReaderFromString("ab\ncd\nef")
+	lines := lineStarts{0}.advance(reader, 0, 8)
+	testutils.AssertEqual(t, 3, len(lines))
+	lines, start := lines.before(4)
+	testutils.AssertEqual(t, 3, start)
+	testutils.AssertEqual(t, 2, len(lines))
+	_, start = lines.before(7)
+	testutils.AssertEqual(t, 6, start)
+}
+
+func TestStreamedLookbehindReleasesInput(t *testing.T) {
+	find := compileFind(t, "find all preceded by 'a' 'b'")
+	reader := files.ReaderFromReader(strings.NewReader(strings.Repeat("ab\n", 100000)))
+	results, err := findMatches(context.Background(), find.Body, find.Spans, find.All, find.Skip, find.Take, find.Last, false, false, "stdin", reader, Limits{})
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 100000, len(results))
+	testutils.AssertEqual(t, 100000, results[99999].Line.Start)
+	testutils.AssertEqual(t, 2, results[99999].Column.Start)
+	// the start of the input has been let go of
+	testutils.AssertEqual(t, "", reader.ReadAt(1, 0))
+}
@@ -1,8 +1,11 @@
 package engine
 
 import (
+	"math/big"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/jmeaster30/vore/libvore/bytecode"
 	"github.com/jmeaster30/vore/libvore/ds"
@@ -23,26 +26,78 @@ type LoopState struct {
 	iterationStep       int
 	name                string
 	loopMatchIndexStart int
-	variables           bytecode.MapValue
+	startFileOffset     int
+	startLineNum        int
+	startColumnNum      int
+	variables           ds.PersistentMap[int, ds.PersistentMap[string, capturedValue]]
 }
 
 type VariableRecord struct {
-	name        string
-	startOffset int
+	name            string
+	startOffset     int
+	startFileOffset int
+	startLineNum    int
+	startColumnNum  int
 }
 
+// capturedValue is a variable's value along with where in the file it was captured
+type capturedValue struct {
+	value    bytecode.Value
+	capture  Capture
+	exported bool // captured by a call that returned instead of by the scope holding it
+}
+
+// CallState is a running subroutine. Every call gets its own variables and the variables of the caller
+// are set aside until the call returns so a recursive call can't overwrite what its caller captured.
+// When the call returns its variables are exported to the caller. See EXPORTVARIABLE.
 type CallState struct {
-	id               int
-	returnOffset     int
-	startMatchOffset int
+	id                int
+	returnOffset      int
+	startMatchOffset  int
+	startFileOffset   int
+	startLineNum      int
+	startColumnNum    int
+	treeSize          int // finished parse nodes from before the call, the ones after it are its children
+	callerEnvironment ds.PersistentMap[string, capturedValue]
+	memo              *memoEntry // where the offsets the call returns at get recorded
+}
+
+// NotInState is the text a not in list is checking. Lists can't hold other lists so there is only ever one.
+type NotInState struct {
+	matchStart int
+	length     int // runes being checked, 0 when we aren't checking a list
+}
+
+// AtomicState is where the backtrack stack was when an atomic group started
+type AtomicState struct {
+	backtrackDepth  int
+	backtrackMemory int
 }
 
+type LookaroundState struct {
+	backtrackDepth  int
+	backtrackMemory int
+	fileOffset      int
+	lineNum         int
+	columnNum       int
+	match           string
+	tree            ds.PersistentStack[ParseNode]
+}
+
+// SearchEngineState only holds persistent stacks and maps so a checkpoint is a plain copy
+// of the struct. The backtrack stack is the one mutable part and it is shared by every
+// checkpoint of a match attempt since restoring a checkpoint only ever pops from it.
 type SearchEngineState struct {
-	loopStack     *ds.Stack[LoopState]
-	backtrack     *ds.Stack[SearchEngineState]
-	variableStack *ds.Stack[VariableRecord]
-	callStack     *ds.Stack[CallState]
-	environment   bytecode.MapValue
+	loopStack       ds.PersistentStack[LoopState]
+	backtrack       *ds.Stack[SearchEngineState]
+	backtrackMemory int
+	variableStack   ds.PersistentStack[VariableRecord]
+	callStack       ds.PersistentStack[CallState]
+	lookaroundStack ds.PersistentStack[LookaroundState]
+	atomicStack     ds.PersistentStack[AtomicState]
+	tree            ds.PersistentStack[ParseNode] // finished subroutine calls that haven't been given to their caller yet
+	notIn           NotInState
+	environment     ds.PersistentMap[string, capturedValue]
 
 	status            Status
 	programCounter    int
@@ -55,6 +110,10 @@ type SearchEngineState struct {
 	startColumnNum    int
 	reader            *files.Reader
 	filename          string
+	memo              *subroutineMemo
+	exhausted         *memoEntry // set on the checkpoint that is restored once a recorded call has tried everything
+	buildTree         bool
+	err               error // what stopped the match attempt when it couldn't keep going
 }
 
 func (es *SearchEngineState) SEEK() {
@@ -75,8 +134,38 @@ func (es *SearchEngineState) READAT(offset int, length int) string {
 	return es.reader.Read(length)
 }
 
-func (es *SearchEngineState) CONSUME(amount int) {
-	value := es.READ(amount)
+func (es *SearchEngineState) READRUNES(count int) string {
+	es.SEEK()
+	return es.reader.ReadRunes(count)
+}
+
+// READRUNESUPTO reads count runes or as many as are left when there are fewer
+func (es *SearchEngineState) READRUNESUPTO(count int) string {
+	for ; count > 0; count-- {
+		if value := es.READRUNES(count); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (es *SearchEngineState) ATEND() bool {
+	return es.reader.AtEnd(es.currentFileOffset)
+}
+
+func (es *SearchEngineState) PEEKBEHIND() string {
+	r, width := es.reader.ReadRuneBefore(es.currentFileOffset)
+	if width == 0 {
+		return ""
+	}
+	return string(r)
+}
+
+func (es *SearchEngineState) CONSUME(count int) {
+	es.ADVANCE(es.READRUNES(count))
+}
+
+func (es *SearchEngineState) ADVANCE(value string) {
 	es.currentMatch += value
 	es.currentFileOffset += len(value)
 	for _, c := range value {
@@ -101,6 +190,12 @@ func (es *SearchEngineState) FAIL() {
 	es.status = FAILED
 }
 
+// ERROR stops the match attempt so the search can return err
+func (es *SearchEngineState) ERROR(err error) {
+	es.err = err
+	es.FAIL()
+}
+
 func (es *SearchEngineState) SUCCESS() {
 	es.status = SUCCESS
 }
@@ -122,7 +217,7 @@ func (es *SearchEngineState) MATCHFILESTART(not bool) {
 }
 
 func (es *SearchEngineState) MATCHFILEEND(not bool) {
-	if es.currentFileOffset == es.reader.Size() {
+	if es.ATEND() {
 		if not {
 			es.BACKTRACK()
 		} else {
@@ -166,7 +261,7 @@ func (es *SearchEngineState) MATCHLINESTART(not bool) {
 func (es *SearchEngineState) MATCHLINEEND(not bool) {
 	nextChar := es.READ(1)
 	nextTwoChar := es.READ(2)
-	if nextChar == "\n" || nextTwoChar == "\r\n" || es.currentFileOffset == es.reader.Size() {
+	if nextChar == "\n" || nextTwoChar == "\r\n" || es.ATEND() {
 		if not {
 			es.BACKTRACK()
 		} else {
@@ -181,12 +276,25 @@ func (es *SearchEngineState) MATCHLINEEND(not bool) {
 	}
 }
 
-func IsLetter(value string) bool {
-	return ("a" <= value && value <= "z") || ("A" <= value && value <= "Z") || ("0" <= value && value <= "9") || value == "_"
+func IsLetter(r rune) bool {
+	return unicode.IsLetter(r)
+}
+
+func IsDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+func IsWordCharacter(r rune) bool {
+	return IsLetter(r) || IsDigit(r) || r == '_'
+}
+
+func isWord(value string) bool {
+	r, width := utf8.DecodeRuneInString(value)
+	return width != 0 && IsWordCharacter(r)
 }
 
 func (es *SearchEngineState) MATCHWORDSTART(not bool) {
-	if es.currentFileOffset == es.reader.Size() {
+	if es.ATEND() {
 		if not {
 			es.BACKTRACK()
 		} else {
@@ -195,9 +303,9 @@ func (es *SearchEngineState) MATCHWORDSTART(not bool) {
 		return
 	}
 
-	current := es.READ(1)
+	current := es.READRUNES(1)
 	if es.currentFileOffset == 0 {
-		if IsLetter(current) {
+		if isWord(current) {
 			if not {
 				es.BACKTRACK()
 			} else {
@@ -213,8 +321,8 @@ func (es *SearchEngineState) MATCHWORDSTART(not bool) {
 		return
 	}
 
-	previous := es.READAT(es.currentFileOffset-1, 1)
-	if IsLetter(current) && !IsLetter(previous) {
+	previous := es.PEEKBEHIND()
+	if isWord(current) && !isWord(previous) {
 		if not {
 			es.BACKTRACK()
 		} else {
@@ -239,9 +347,9 @@ func (es *SearchEngineState) MATCHWORDEND(not bool) {
 		return
 	}
 
-	current := es.READ(1)
-	if es.currentFileOffset == es.reader.Size() {
-		if !IsLetter(current) {
+	current := es.READRUNES(1)
+	if es.ATEND() {
+		if !isWord(current) {
 			if not {
 				es.BACKTRACK()
 			} else {
@@ -257,9 +365,9 @@ func (es *SearchEngineState) MATCHWORDEND(not bool) {
 		return
 	}
 
-	previous := es.READAT(es.currentFileOffset-1, 1)
+	previous := es.PEEKBEHIND()
 
-	if !IsLetter(current) && IsLetter(previous) {
+	if !isWord(current) && isWord(previous) {
 		if not {
 			es.BACKTRACK()
 		} else {
@@ -292,12 +400,12 @@ func (es *SearchEngineState) MATCHWHOLEFILE(not bool) {
 	}
 
 	// TODO This is probably going to be a performance concern
-	es.CONSUME(es.reader.Size())
+	es.ADVANCE(es.READ(es.reader.Size()))
 	es.NEXT()
 }
 
 func (es *SearchEngineState) MATCHWHOLELINE(not bool) {
-	if (es.currentFileOffset != 0 && es.READAT(es.currentFileOffset-1, 1) != "\n") || es.currentFileOffset == es.reader.Size() {
+	if (es.currentFileOffset != 0 && es.READAT(es.currentFileOffset-1, 1) != "\n") || es.ATEND() {
 		if not {
 			es.NEXT()
 		} else {
@@ -314,13 +422,13 @@ func (es *SearchEngineState) MATCHWHOLELINE(not bool) {
 	// we know we are at the start of a line and we can read a character
 	for {
 		es.CONSUME(1)
-		if es.currentFileOffset == es.reader.Size() {
+		if es.ATEND() {
 			break
 		}
 
 		nextChar := es.READ(1)
 		nextTwoChar := es.READ(2)
-		if nextChar == "\n" || nextTwoChar == "\r\n" || es.currentFileOffset == es.reader.Size() {
+		if nextChar == "\n" || nextTwoChar == "\r\n" || es.ATEND() {
 			break
 		}
 	}
@@ -329,7 +437,7 @@ func (es *SearchEngineState) MATCHWHOLELINE(not bool) {
 }
 
 func (es *SearchEngineState) MATCHWHOLEWORD(not bool) {
-	if (es.currentFileOffset != 0 && (!IsLetter(es.READ(1)) || IsLetter(es.READAT(es.currentFileOffset-1, 1)))) || es.currentFileOffset == es.reader.Size() {
+	if (es.currentFileOffset != 0 && (!isWord(es.READRUNES(1)) || isWord(es.PEEKBEHIND()))) || es.ATEND() {
 		if not {
 			es.NEXT()
 		} else {
@@ -346,14 +454,14 @@ func (es *SearchEngineState) MATCHWHOLEWORD(not bool) {
 	// we know we are at the start of a line and we can read a character
 	for {
 		es.CONSUME(1)
-		if es.currentFileOffset == es.reader.Size() {
+		if es.ATEND() {
 			break
 		}
 
-		current := es.READ(1)
-		previous := es.READAT(es.currentFileOffset-1, 1)
+		current := es.READRUNES(1)
+		previous := es.PEEKBEHIND()
 
-		if !IsLetter(current) && IsLetter(previous) {
+		if !isWord(current) && isWord(previous) {
 			break
 		}
 	}
@@ -366,23 +474,35 @@ func (es *SearchEngineState) MATCHANY(not bool) {
 		es.BACKTRACK()
 		return
 	}
-	value := es.READ(1)
+	value := es.READRUNES(1)
 	if value == "" {
 		es.BACKTRACK()
 	} else {
-		es.CONSUME(1)
+		es.ADVANCE(value)
 		es.NEXT()
 	}
 }
 
-func (es *SearchEngineState) MATCHRANGE(from string, to string, not bool) {
-	min := len(from)
-	max := len(to)
+func (es *SearchEngineState) MATCHRANGE(from string, to string, not bool, caseless bool) {
+	min := utf8.RuneCountInString(from)
+	max := utf8.RuneCountInString(to)
+	if es.notIn.length != 0 {
+		// a range in a not in list only checks the length the list is trying
+		if es.notIn.length < min || es.notIn.length > max {
+			es.BACKTRACK()
+			return
+		}
+		min = es.notIn.length
+		max = es.notIn.length
+	}
 
 	for i := max; i >= min; i-- {
-		value := es.READ(i)
-		if (from <= value && value <= to && !not) || ((from > value || value > to) && not) {
-			es.CONSUME(i)
+		value := es.READRUNES(i)
+		if value == "" {
+			continue
+		}
+		if inRange(from, to, value, caseless) != not {
+			es.ADVANCE(value)
 			es.NEXT()
 			return
 		}
@@ -391,19 +511,23 @@ func (es *SearchEngineState) MATCHRANGE(from string, to string, not bool) {
 	es.BACKTRACK()
 }
 
-func (es *SearchEngineState) MATCHLETTER(not bool) {
-	// TODO I would prefer if I had a generic way to do these multirange searches
-	value := es.READ(1)
-	if ("a" <= value && value <= "z") || ("A" <= value && value <= "Z") {
+func (es *SearchEngineState) MATCHRUNE(predicate func(rune) bool, not bool) {
+	value := es.READRUNES(1)
+	if value == "" {
+		es.BACKTRACK()
+		return
+	}
+	r, _ := utf8.DecodeRuneInString(value)
+	if predicate(r) {
 		if not {
 			es.BACKTRACK()
 		} else {
-			es.CONSUME(1)
+			es.ADVANCE(value)
 			es.NEXT()
 		}
 	} else {
 		if not {
-			es.CONSUME(1)
+			es.ADVANCE(value)
 			es.NEXT()
 		} else {
 			es.BACKTRACK()
@@ -412,7 +536,7 @@ func (es *SearchEngineState) MATCHLETTER(not bool) {
 }
 
 func (es *SearchEngineState) MATCHOPTIONS(options []string, not bool) {
-	value := es.READ(1)
+	value := es.READRUNES(1)
 	if value == "" {
 		es.BACKTRACK()
 		return
@@ -424,7 +548,7 @@ func (es *SearchEngineState) MATCHOPTIONS(options []string, not bool) {
 				es.BACKTRACK()
 				return
 			} else {
-				es.CONSUME(1)
+				es.ADVANCE(value)
 				es.NEXT()
 				return
 			}
@@ -432,13 +556,38 @@ func (es *SearchEngineState) MATCHOPTIONS(options []string, not bool) {
 	}
 
 	if not {
-		es.CONSUME(1)
+		es.ADVANCE(value)
 		es.NEXT()
 	} else {
 		es.BACKTRACK()
 	}
 }
 
+// inRange checks if value is between from and to. When caseless a single rune is also checked in each of
+// its other cases under Unicode simple case folding and longer text is checked all lower and all upper case.
+func inRange(from string, to string, value string, caseless bool) bool {
+	if from <= value && value <= to {
+		return true
+	}
+	if !caseless {
+		return false
+	}
+	if r, width := utf8.DecodeRuneInString(value); width == len(value) {
+		for folded := unicode.SimpleFold(r); folded != r; folded = unicode.SimpleFold(folded) {
+			if other := string(folded); from <= other && other <= to {
+				return true
+			}
+		}
+		return false
+	}
+	for _, other := range []string{strings.ToLower(value), strings.ToUpper(value)} {
+		if from <= other && other <= to {
+			return true
+		}
+	}
+	return false
+}
+
 func compare(a string, b string, caseless bool) bool {
 	if caseless {
 		return strings.EqualFold(a, b)
@@ -448,7 +597,7 @@ func compare(a string, b string, caseless bool) bool {
 }
 
 func (es *SearchEngineState) MATCH(value string, not bool, caseless bool) {
-	comp := es.READ(len(value))
+	comp := es.READRUNES(utf8.RuneCountInString(value))
 
 	if len(comp) == 0 {
 		es.BACKTRACK()
@@ -456,26 +605,327 @@ func (es *SearchEngineState) MATCH(value string, not bool, caseless bool) {
 	}
 
 	if !not && compare(value, comp, caseless) {
-		es.CONSUME(len(value))
+		es.ADVANCE(comp)
 		es.NEXT()
 	} else if not && !compare(value, comp, caseless) {
-		es.CONSUME(len(value))
+		es.ADVANCE(comp)
 		es.NEXT()
 	} else {
 		es.BACKTRACK()
 	}
 }
 
-func (es *SearchEngineState) MATCHVAR(name string) {
-	value, found := es.environment.Get(name)
+func (es *SearchEngineState) MATCHVAR(name string, caseless bool) {
+	variable, found := es.LOOKUPVARIABLE(name)
+	value := variable.value
 	if !found {
 		es.BACKTRACK()
 	} else if value.Type() == bytecode.ValueType_Map {
 		// TODO add syntax for indexing hash maps but also I want something a bit better than just failing here
 		es.BACKTRACK()
 	} else {
-		es.MATCH(value.String(), false, false)
+		es.MATCH(value.String(), false, caseless)
+	}
+}
+
+// ADVANCEEACH matches the first of options and leaves a checkpoint that matches each of the others in order
+func (es *SearchEngineState) ADVANCEEACH(options []string) {
+	for i := len(options) - 1; i > 0; i-- {
+		checkpoint := *es
+		checkpoint.ADVANCE(options[i])
+		checkpoint.NEXT()
+		es.PUSHCHECKPOINT(checkpoint)
+	}
+	es.ADVANCE(options[0])
+	es.NEXT()
+}
+
+// MATCHTERMS matches the longest term at the current offset and leaves a checkpoint for each shorter one.
+// A whole word term can't have a word character right before or right after it.
+func (es *SearchEngineState) MATCHTERMS(terms *ds.Trie, wholeWord bool) {
+	if wholeWord {
+		if isWord(es.PEEKBEHIND()) {
+			es.BACKTRACK()
+			return
+		}
+	}
+
+	text := []rune(es.READRUNESUPTO(terms.Longest()))
+	allowed := []string{}
+	lengths := terms.Prefixes(string(text))
+	for i := len(lengths) - 1; i >= 0; i-- {
+		if lengths[i] == 0 {
+			continue
+		}
+		if wholeWord {
+			after, width := es.reader.ReadRuneAt(es.currentFileOffset + len(string(text[:lengths[i]])))
+			if width != 0 && IsWordCharacter(after) {
+				continue
+			}
+		}
+		allowed = append(allowed, string(text[:lengths[i]]))
+	}
+	if len(allowed) == 0 {
+		es.BACKTRACK()
+		return
+	}
+
+	es.ADVANCEEACH(allowed)
+}
+
+// READNUMBER reads a sign, up to maxDigits digits (any number when -1) and, when decimals are allowed,
+// a '.' and the digits after it. The text is only read as far as a number could go.
+func (es *SearchEngineState) READNUMBER(maxDigits int, decimals bool) string {
+	offset := es.currentFileOffset
+	peek := func() byte {
+		r, width := es.reader.ReadRuneAt(offset)
+		if width != 1 {
+			return 0
+		}
+		return byte(r)
+	}
+
+	text := ""
+	if c := peek(); c == '+' || c == '-' {
+		text += string(c)
+		offset++
+	}
+	digits := 0
+	for c := peek(); IsDigit(rune(c)) && (maxDigits == -1 || digits < maxDigits); c = peek() {
+		text += string(c)
+		offset++
+		digits++
+	}
+	if !decimals || digits == 0 || peek() != '.' {
+		return text
+	}
+	text += "."
+	offset++
+	for c := peek(); IsDigit(rune(c)); c = peek() {
+		text += string(c)
+		offset++
+	}
+	return text
+}
+
+// numberCandidates returns the numbers at the start of text from longest to shortest. A candidate is a sign
+// when there is one, every digit before the '.' and, when decimals are allowed, the '.' and every digit after
+// it, so a candidate never ends in the middle of a run of digits.
+func numberCandidates(text string, decimals bool) []string {
+	end := 0
+	if end < len(text) && (text[end] == '+' || text[end] == '-') {
+		end++
+	}
+	digitsStart := end
+	for end < len(text) && IsDigit(rune(text[end])) {
+		end++
+	}
+	if end == digitsStart {
+		return []string{}
+	}
+	candidates := []string{text[:end]}
+	if decimals && end+1 < len(text) && text[end] == '.' && IsDigit(rune(text[end+1])) {
+		candidates = append([]string{text}, candidates...)
+	}
+	return candidates
+}
+
+// numberAllowed checks the written form of a number along with its value
+func numberAllowed(number string, min *big.Rat, max *big.Rat, leadingZeros bool, signs bool) bool {
+	digits := strings.TrimLeft(number, "+-")
+	if !leadingZeros && len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+		return false
+	}
+	value, ok := new(big.Rat).SetString(number)
+	if !ok {
+		return false
+	}
+	// '-' is always allowed on negative numbers but any other sign needs signs turned on
+	if len(digits) != len(number) && !signs && (number[0] == '+' || value.Sign() >= 0) {
+		return false
+	}
+	return value.Cmp(min) >= 0 && value.Cmp(max) <= 0
+}
+
+// MATCHNUMBER matches the longest number at the current offset that is from min to max and leaves a checkpoint
+// for the whole part of a decimal number. A number is never split from a run of digits, so "2556" doesn't match
+// as "255" and then "6".
+func (es *SearchEngineState) MATCHNUMBER(min int, max int, leadingZeros bool, signs bool, decimals bool) {
+	if r, width := es.reader.ReadRuneBefore(es.currentFileOffset); width == 1 && IsDigit(r) {
+		es.BACKTRACK()
+		return
 	}
+	// without leading zeros a number in range never has more digits than the bounds
+	maxDigits := -1
+	if !leadingZeros {
+		maxDigits = len(strings.TrimPrefix(strconv.Itoa(max), "-"))
+		if digits := len(strings.TrimPrefix(strconv.Itoa(min), "-")); digits > maxDigits {
+			maxDigits = digits
+		}
+	}
+	text := es.READNUMBER(maxDigits, decimals)
+	// the digits only stop short of the text when there are more of them than any number in range has
+	if r, width := es.reader.ReadRuneAt(es.currentFileOffset + len(text)); width == 1 && IsDigit(r) {
+		es.BACKTRACK()
+		return
+	}
+
+	minValue := new(big.Rat).SetInt64(int64(min))
+	maxValue := new(big.Rat).SetInt64(int64(max))
+	allowed := []string{}
+	for _, candidate := range numberCandidates(text, decimals) {
+		if numberAllowed(candidate, minValue, maxValue, leadingZeros, signs) {
+			allowed = append(allowed, candidate)
+		}
+	}
+	if len(allowed) == 0 {
+		es.BACKTRACK()
+		return
+	}
+
+	es.ADVANCEEACH(allowed)
+}
+
+// editDistances returns the edit distance between target and every prefix of text. With swaps, swapping two
+// neighbouring runes counts as a single edit (the optimal string alignment distance).
+func editDistances(target []rune, text []rune, swaps bool, caseless bool) []int {
+	equal := func(a rune, b rune) bool {
+		return a == b || (caseless && strings.EqualFold(string(a), string(b)))
+	}
+
+	// columns[j][i] is the distance between the first i runes of target and the first j runes of text
+	columns := make([][]int, len(text)+1)
+	columns[0] = make([]int, len(target)+1)
+	for i := range columns[0] {
+		columns[0][i] = i
+	}
+	for j := 1; j <= len(text); j++ {
+		column := make([]int, len(target)+1)
+		column[0] = j
+		for i := 1; i <= len(target); i++ {
+			cost := 1
+			if equal(target[i-1], text[j-1]) {
+				cost = 0
+			}
+			best := columns[j-1][i-1] + cost
+			if deletion := column[i-1] + 1; deletion < best {
+				best = deletion
+			}
+			if insertion := columns[j-1][i] + 1; insertion < best {
+				best = insertion
+			}
+			if swaps && i > 1 && j > 1 && equal(target[i-1], text[j-2]) && equal(target[i-2], text[j-1]) {
+				if swap := columns[j-2][i-2] + 1; swap < best {
+					best = swap
+				}
+			}
+			column[i] = best
+		}
+		columns[j] = column
+	}
+
+	distances := make([]int, len(text)+1)
+	for j, column := range columns {
+		distances[j] = column[len(target)]
+	}
+	return distances
+}
+
+// MATCHFUZZY matches the text starting here that is within maxEdits edits of value. It tries the lowest
+// distance first and the longest text of that distance, and leaves a checkpoint for every other text that is
+// close enough. When the fuzzy match starts the command a text whose first rune is an insertion is skipped since
+// the search at the next offset matches the rest of it with one edit less.
+func (es *SearchEngineState) MATCHFUZZY(value string, maxEdits int, swaps bool, caseless bool, name string) {
+	target := []rune(value)
+	text := []rune(es.READRUNESUPTO(len(target) + maxEdits))
+	distances := editDistances(target, text, swaps, caseless)
+	if es.programCounter == 0 && len(text) > 0 {
+		rest := editDistances(target, text[1:], swaps, caseless)
+		for length := 1; length <= len(text); length++ {
+			if rest[length-1] < distances[length] {
+				distances[length] = maxEdits + 1
+			}
+		}
+	}
+
+	type candidate struct {
+		length   int
+		distance int
+	}
+	candidates := []candidate{}
+	for distance := 0; distance <= maxEdits; distance++ {
+		for length := len(text); length > 0; length-- {
+			if distances[length] == distance {
+				candidates = append(candidates, candidate{length, distance})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		es.BACKTRACK()
+		return
+	}
+
+	for i := len(candidates) - 1; i > 0; i-- {
+		checkpoint := *es
+		checkpoint.fuzzyMatched(string(text[:candidates[i].length]), candidates[i].distance, name)
+		es.PUSHCHECKPOINT(checkpoint)
+	}
+	es.fuzzyMatched(string(text[:candidates[0].length]), candidates[0].distance, name)
+}
+
+func (es *SearchEngineState) fuzzyMatched(matched string, distance int, name string) {
+	startFileOffset := es.currentFileOffset
+	startLineNum := es.currentLineNum
+	startColumnNum := es.currentColumnNum
+	es.ADVANCE(matched)
+	if name != "" {
+		es.INSERTVARIABLE(name, capturedValue{
+			value: bytecode.NewNumber(distance),
+			capture: Capture{
+				Offset: *ds.NewRange(startFileOffset, es.currentFileOffset),
+				Line:   *ds.NewRange(startLineNum, es.currentLineNum),
+				Column: *ds.NewRange(startColumnNum, es.currentColumnNum),
+			},
+		})
+	}
+	es.NEXT()
+}
+
+// STARTNOTIN checks the longest length first and leaves a checkpoint for each shorter length
+func (es *SearchEngineState) STARTNOTIN(maxSize int) {
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	available := utf8.RuneCountInString(es.READRUNESUPTO(maxSize))
+	if available == 0 {
+		es.BACKTRACK()
+		return
+	}
+	es.NEXT()
+	es.notIn.matchStart = len(es.currentMatch)
+	for length := 1; length < available; length++ {
+		es.notIn.length = length
+		es.CHECKPOINT()
+	}
+	es.notIn.length = available
+}
+
+// FAILNOTIN runs after an item of the list matched. The text is only in the list when the item matched all of it
+// and then we skip the rest of the items and the length, otherwise we move on to the next item.
+func (es *SearchEngineState) FAILNOTIN() {
+	inList := utf8.RuneCountInString(es.currentMatch[es.notIn.matchStart:]) == es.notIn.length
+	es.BACKTRACK()
+	if inList {
+		es.BACKTRACK()
+	}
+}
+
+// ENDNOTIN matches the text since none of the items matched it
+func (es *SearchEngineState) ENDNOTIN() {
+	length := es.notIn.length
+	es.notIn = NotInState{}
+	es.CONSUME(length)
+	es.NEXT()
 }
 
 func (es *SearchEngineState) NEXT() {
@@ -499,10 +949,13 @@ func (es *SearchEngineState) INITLOOPSTACK(loopId int64, name string) bool {
 			callLevel:           int(es.callStack.Size()),
 			iterationStep:       0,
 			loopMatchIndexStart: len(es.currentMatch),
-			variables:           bytecode.NewEmptyMap(),
+			startFileOffset:     es.currentFileOffset,
+			startLineNum:        es.currentLineNum,
+			startColumnNum:      es.currentColumnNum,
+			variables:           ds.NewPersistentMap[int, ds.PersistentMap[string, capturedValue]](),
 		}
-		lstate.variables.Set("0", bytecode.NewEmptyMap())
-		es.loopStack.Push(lstate)
+		lstate.variables = lstate.variables.Set(0, ds.NewPersistentMap[string, capturedValue]())
+		es.loopStack = es.loopStack.Push(lstate)
 		return true
 	}
 	return false
@@ -512,11 +965,11 @@ func (es *SearchEngineState) INCLOOPSTACK() {
 	if es.loopStack.IsEmpty() {
 		panic("oh crap :(")
 	}
-	old := es.loopStack.Pop().GetValue()
+	old := es.loopStack.Peek().GetValue()
 	old.iterationStep += 1
 	old.loopMatchIndexStart = len(es.currentMatch)
-	old.variables.Set(strconv.Itoa(old.iterationStep), bytecode.NewEmptyMap())
-	es.loopStack.Push(old)
+	old.variables = old.variables.Set(old.iterationStep, ds.NewPersistentMap[string, capturedValue]())
+	es.loopStack = es.loopStack.Pop().Push(old)
 }
 
 func (es *SearchEngineState) GETITERATIONSTEP() int {
@@ -537,98 +990,377 @@ func (es *SearchEngineState) POPLOOPSTACK() LoopState {
 	if es.loopStack.IsEmpty() {
 		panic("oh crap :(")
 	}
-	top := es.loopStack.Pop().GetValue()
+	top := es.loopStack.Peek().GetValue()
+	es.loopStack = es.loopStack.Pop()
 	if top.name != "" {
-		es.INSERTVARIABLE(top.name, top.variables)
+		es.INSERTVARIABLE(top.name, capturedValue{value: top.VariablesValue(), capture: top.Capture(es)})
 	}
 	return top
 }
 
 func (es *SearchEngineState) PUSHLOOPSTACK(loopState LoopState) {
-	es.loopStack.Push(loopState)
+	es.loopStack = es.loopStack.Push(loopState)
+}
+
+// VariablesValue builds the map value of a named loop with one entry per iteration
+func (ls LoopState) VariablesValue() bytecode.MapValue {
+	result := bytecode.NewEmptyMap()
+	for _, iteration := range ls.variables.Entries() {
+		result.Set(strconv.Itoa(iteration.Left()), environmentValue(iteration.Right()))
+	}
+	return result
+}
+
+// Capture covers the whole loop up to where the state is now along with the captures of each iteration
+func (ls LoopState) Capture(es *SearchEngineState) Capture {
+	result := Capture{
+		Offset:     *ds.NewRange(ls.startFileOffset, es.currentFileOffset),
+		Line:       *ds.NewRange(ls.startLineNum, es.currentLineNum),
+		Column:     *ds.NewRange(ls.startColumnNum, es.currentColumnNum),
+		Iterations: []map[string]Capture{},
+	}
+	for _, iteration := range ls.variables.Entries() {
+		result.Iterations = append(result.Iterations, environmentCaptures(iteration.Right()))
+	}
+	return result
+}
+
+func environmentValue(environment ds.PersistentMap[string, capturedValue]) bytecode.MapValue {
+	result := bytecode.NewEmptyMap()
+	for _, variable := range environment.Entries() {
+		result.Set(variable.Left(), variable.Right().value)
+	}
+	return result
+}
+
+func environmentCaptures(environment ds.PersistentMap[string, capturedValue]) map[string]Capture {
+	result := map[string]Capture{}
+	for _, variable := range environment.Entries() {
+		result[variable.Left()] = variable.Right().capture
+	}
+	return result
 }
 
 func (es *SearchEngineState) STARTVAR(name string) {
 	record := VariableRecord{
-		name:        name,
-		startOffset: len(es.currentMatch),
+		name:            name,
+		startOffset:     len(es.currentMatch),
+		startFileOffset: es.currentFileOffset,
+		startLineNum:    es.currentLineNum,
+		startColumnNum:  es.currentColumnNum,
 	}
-	es.variableStack.Push(record)
+	es.variableStack = es.variableStack.Push(record)
 	es.NEXT()
 }
 
 func (es *SearchEngineState) ENDVAR(name string) {
-	record := es.variableStack.Pop().GetValue()
+	record := es.variableStack.Peek().GetValue()
+	es.variableStack = es.variableStack.Pop()
 	if record.name != name {
 		panic("UHOH BAD INSTRUCTIONS I TRIED RESOLVING A VARIABLE THAT I WASN'T EXPECTING")
 	}
 	value := es.currentMatch[record.startOffset:]
-	es.INSERTVARIABLE(name, bytecode.NewString(value))
+	es.INSERTVARIABLE(name, capturedValue{
+		value: bytecode.NewString(value),
+		capture: Capture{
+			Offset: *ds.NewRange(record.startFileOffset, es.currentFileOffset),
+			Line:   *ds.NewRange(record.startLineNum, es.currentLineNum),
+			Column: *ds.NewRange(record.startColumnNum, es.currentColumnNum),
+		},
+	})
 	es.NEXT()
 }
 
-func (es *SearchEngineState) INSERTVARIABLE(name string, value bytecode.Value) {
-	var lowestScope ds.Optional[LoopState] = ds.None[LoopState]()
-	for i := int(es.loopStack.Size()) - 1; i >= 0; i-- {
-		lowestScope = es.loopStack.Index(i)
-		if lowestScope.GetValue().name != "" {
-			break
+func (es *SearchEngineState) INSERTVARIABLE(name string, value capturedValue) {
+	// variables go in the current iteration of the outermost named loop or the environment when there isn't one
+	loops := ds.Subslice[LoopState](es.loopStack, 0, es.loopStack.Size()-1)
+	scope := -1
+	for i, loop := range loops {
+		// loops started by a caller belong to the caller's variables
+		if loop.name != "" && loop.callLevel == es.callStack.Size() {
+			scope = i
 		}
 	}
 
-	if !lowestScope.HasValue() || lowestScope.GetValue().name == "" {
-		es.environment.Set(name, value)
-	} else {
-		variables := lowestScope.GetValue().variables
-		index := strconv.Itoa(lowestScope.GetValue().iterationStep)
-		v, prs := lowestScope.GetValue().variables.Get(index)
-		if !prs {
-			m := bytecode.NewMap(map[string]any{})
-			m.Set(name, value)
-			variables.Set(index, m)
-		} else {
-			// fmt.Printf("aa %s %+v\n", name, v)
-			m := bytecode.ToMapValue(v)
-			m.Set(name, value)
-			variables.Set(index, m)
+	if scope == -1 {
+		es.environment = es.environment.Set(name, value)
+		return
+	}
+
+	// the loop states are immutable so rebuild the stack from the scope we changed upwards
+	stack := es.loopStack
+	for i := 0; i <= scope; i++ {
+		stack = stack.Pop()
+	}
+	scopeState := loops[scope]
+	iteration, _ := scopeState.variables.Get(scopeState.iterationStep)
+	scopeState.variables = scopeState.variables.Set(scopeState.iterationStep, iteration.Set(name, value))
+	stack = stack.Push(scopeState)
+	for i := scope - 1; i >= 0; i-- {
+		stack = stack.Push(loops[i])
+	}
+	es.loopStack = stack
+}
+
+// LOOKUPVARIABLE looks through the scope of the running call and then the scopes of its callers. A scope is the
+// current iteration of its outermost named loop, where INSERTVARIABLE would have put the variable, and then the
+// variables captured outside of any named loop.
+func (es *SearchEngineState) LOOKUPVARIABLE(name string) (capturedValue, bool) {
+	loops := ds.Subslice[LoopState](es.loopStack, 0, es.loopStack.Size()-1)
+	frames := es.callStack
+	environment := es.environment
+	for level := es.callStack.Size(); level >= 0; level-- {
+		for i := len(loops) - 1; i >= 0; i-- {
+			if loops[i].name == "" || loops[i].callLevel != level {
+				continue
+			}
+			iteration, _ := loops[i].variables.Get(loops[i].iterationStep)
+			if variable, found := iteration.Get(name); found {
+				return variable, true
+			}
+			break
+		}
+		if variable, found := environment.Get(name); found {
+			return variable, true
+		}
+		if level > 0 {
+			environment = frames.Peek().GetValue().callerEnvironment
+			frames = frames.Pop()
 		}
 	}
+	return capturedValue{}, false
+}
+
+func (es *SearchEngineState) HASVARIABLE(name string) bool {
+	_, found := es.LOOKUPVARIABLE(name)
+	return found
 }
 
+// VALIDATECALL starts a call for a subroutine we reached where it is written unless we got there through a call
 func (es *SearchEngineState) VALIDATECALL(id int, returnOffset int) {
 	top := es.callStack.Peek()
 	if !top.HasValue() || top.GetValue().id != id {
-		es.CALL(id, returnOffset)
+		es.PUSHCALL(id, returnOffset)
 	}
 }
 
-func (es *SearchEngineState) CALL(id int, returnOffset int) {
-	es.callStack.Push(CallState{
-		id:           id,
-		returnOffset: returnOffset,
+// CALL returns false without calling when the subroutine is already running from the current offset
+// since it would keep calling itself forever without matching anything
+func (es *SearchEngineState) CALL(id int, returnOffset int) bool {
+	// calls only ever start at or after the offset of their caller so we can stop at the first one that started earlier
+	for frames := es.callStack; !frames.IsEmpty(); frames = frames.Pop() {
+		frame := frames.Peek().GetValue()
+		if frame.startFileOffset != es.currentFileOffset {
+			break
+		}
+		if frame.id == id {
+			return false
+		}
+	}
+	es.PUSHCALL(id, returnOffset)
+	return true
+}
+
+func (es *SearchEngineState) PUSHCALL(id int, returnOffset int) {
+	es.callStack = es.callStack.Push(CallState{
+		id:                id,
+		returnOffset:      returnOffset,
+		startMatchOffset:  len(es.currentMatch),
+		startFileOffset:   es.currentFileOffset,
+		startLineNum:      es.currentLineNum,
+		startColumnNum:    es.currentColumnNum,
+		treeSize:          es.tree.Size(),
+		callerEnvironment: es.environment,
 	})
+	es.environment = ds.NewPersistentMap[string, capturedValue]()
 }
 
-func (es *SearchEngineState) RETURN() {
-	top := es.callStack.Pop()
+func (es *SearchEngineState) RETURN(name string) {
+	top := es.callStack.Peek()
 	if !top.HasValue() {
 		panic("BAD CALL STACK :(")
 	}
-	es.programCounter = top.GetValue().returnOffset
+	frame := top.GetValue()
+	captured := es.environment
+	es.callStack = es.callStack.Pop()
+	es.environment = frame.callerEnvironment
+	node := ParseNode{}
+	if es.buildTree {
+		node = es.PARSENODE(name, frame)
+		es.tree = es.tree.Push(node)
+	}
+	if frame.memo != nil {
+		frame.memo.record(es.currentFileOffset, node)
+	}
+	for _, variable := range captured.Entries() {
+		es.EXPORTVARIABLE(variable.Left(), variable.Right())
+	}
+	es.programCounter = frame.returnOffset
+}
+
+// EXPORTVARIABLE gives the caller a variable captured by a call that just returned. Every call exports its variables
+// so when a pattern is used more than once the last call to capture a variable wins. A variable the caller captured
+// itself is kept though so a recursive call can't change what its caller's backreferences match.
+func (es *SearchEngineState) EXPORTVARIABLE(name string, value capturedValue) {
+	if current, found := es.ownVariable(name); found && !current.exported {
+		return
+	}
+	value.exported = true
+	es.INSERTVARIABLE(name, value)
+}
+
+// ownVariable looks for a variable in the scope of the running call without looking at the scopes of its callers
+func (es *SearchEngineState) ownVariable(name string) (capturedValue, bool) {
+	loops := ds.Subslice[LoopState](es.loopStack, 0, es.loopStack.Size()-1)
+	for i := len(loops) - 1; i >= 0; i-- {
+		if loops[i].name == "" || loops[i].callLevel != es.callStack.Size() {
+			continue
+		}
+		iteration, _ := loops[i].variables.Get(loops[i].iterationStep)
+		if variable, found := iteration.Get(name); found {
+			return variable, true
+		}
+		break
+	}
+	return es.environment.Get(name)
+}
+
+func (es *SearchEngineState) STARTLOOKAHEAD(not bool, endPC int) {
+	depth := es.backtrack.Size()
+	memory := es.backtrackMemory
+	if not {
+		pc := es.GETPC()
+		es.JUMP(endPC + 1)
+		es.CHECKPOINT()
+		es.JUMP(pc)
+	}
+	es.lookaroundStack = es.lookaroundStack.Push(LookaroundState{
+		backtrackDepth:  depth,
+		backtrackMemory: memory,
+		fileOffset:      es.currentFileOffset,
+		lineNum:         es.currentLineNum,
+		columnNum:       es.currentColumnNum,
+		match:           es.currentMatch,
+		tree:            es.tree,
+	})
+	es.NEXT()
+}
+
+func (es *SearchEngineState) STARTLOOKBEHIND(not bool, maxLength int, endPC int) {
+	es.STARTLOOKAHEAD(not, endPC)
+
+	// the body has to end where we are now so try every place it could start from, nearest first
+	type candidate struct {
+		offset    int
+		lineNum   int
+		columnNum int
+	}
+	candidates := []candidate{}
+	offset := es.currentFileOffset
+	lineNum := es.currentLineNum
+	columnNum := es.currentColumnNum
+	for length := 1; maxLength == -1 || length <= maxLength; length++ {
+		r, width := es.reader.ReadRuneBefore(offset)
+		if width == 0 {
+			break
+		}
+		offset -= width
+		if r == '\n' {
+			lineNum -= 1
+			columnNum = es.COLUMNAT(offset)
+		} else {
+			columnNum -= 1
+		}
+		candidates = append(candidates, candidate{offset, lineNum, columnNum})
+	}
+
+	for i := len(candidates) - 1; i >= 0; i-- {
+		checkpoint := *es
+		checkpoint.currentFileOffset = candidates[i].offset
+		checkpoint.currentLineNum = candidates[i].lineNum
+		checkpoint.currentColumnNum = candidates[i].columnNum
+		es.PUSHCHECKPOINT(checkpoint)
+	}
+}
+
+func (es *SearchEngineState) COLUMNAT(offset int) int {
+	column := 1
+	for {
+		r, width := es.reader.ReadRuneBefore(offset)
+		if width == 0 || r == '\n' {
+			return column
+		}
+		offset -= width
+		column += 1
+	}
+}
+
+func (es *SearchEngineState) ENDLOOKAROUND(not bool, behind bool) {
+	top := es.lookaroundStack.Peek().GetValue()
+	if behind && es.currentFileOffset != top.fileOffset {
+		es.BACKTRACK()
+		return
+	}
+
+	es.lookaroundStack = es.lookaroundStack.Pop()
+	for es.backtrack.Size() > top.backtrackDepth {
+		es.backtrack.Pop()
+	}
+	es.backtrackMemory = top.backtrackMemory
+
+	if not {
+		es.BACKTRACK()
+		return
+	}
+
+	es.currentFileOffset = top.fileOffset
+	es.currentLineNum = top.lineNum
+	es.currentColumnNum = top.columnNum
+	es.currentMatch = top.match
+	// calls made while looking around aren't part of the match
+	es.tree = top.tree
+	es.NEXT()
+}
+
+func (es *SearchEngineState) STARTATOMIC() {
+	es.atomicStack = es.atomicStack.Push(AtomicState{
+		backtrackDepth:  es.backtrack.Size(),
+		backtrackMemory: es.backtrackMemory,
+	})
+	es.NEXT()
+}
+
+// ENDATOMIC throws away every checkpoint pushed since the group started so failing later on
+// backtracks to before the group instead of into it
+func (es *SearchEngineState) ENDATOMIC() {
+	top := es.atomicStack.Peek().GetValue()
+	es.atomicStack = es.atomicStack.Pop()
+	for es.backtrack.Size() > top.backtrackDepth {
+		es.backtrack.Pop()
+	}
+	es.backtrackMemory = top.backtrackMemory
+	es.NEXT()
 }
 
 func (es *SearchEngineState) CHECKPOINT() {
-	checkpoint := es.Copy()
-	es.backtrack.Push(*checkpoint)
+	es.PUSHCHECKPOINT(*es)
+}
+
+func (es *SearchEngineState) PUSHCHECKPOINT(checkpoint SearchEngineState) {
+	es.backtrackMemory += checkpoint.checkpointSize()
+	es.backtrack.Push(checkpoint)
 }
 
 func CreateState(filename string, reader *files.Reader, fileOffset int, lineNumber int, columnNumber int) *SearchEngineState {
 	return &SearchEngineState{
-		loopStack:         ds.NewStack[LoopState](),
+		loopStack:         ds.NewPersistentStack[LoopState](),
 		backtrack:         ds.NewStack[SearchEngineState](),
-		variableStack:     ds.NewStack[VariableRecord](),
-		callStack:         ds.NewStack[CallState](),
-		environment:       bytecode.NewMap(map[string]any{}),
+		variableStack:     ds.NewPersistentStack[VariableRecord](),
+		callStack:         ds.NewPersistentStack[CallState](),
+		lookaroundStack:   ds.NewPersistentStack[LookaroundState](),
+		atomicStack:       ds.NewPersistentStack[AtomicState](),
+		tree:              ds.NewPersistentStack[ParseNode](),
+		environment:       ds.NewPersistentMap[string, capturedValue](),
 		status:            INPROCESS,
 		programCounter:    0,
 		currentFileOffset: fileOffset,
@@ -642,12 +1374,18 @@ func CreateState(filename string, reader *files.Reader, fileOffset int, lineNumb
 	}
 }
 
+// Copy makes an independent state. Only the backtrack stack needs copying since everything else is persistent.
 func (es *SearchEngineState) Copy() *SearchEngineState {
 	return &SearchEngineState{
-		loopStack:         es.loopStack.Copy(),
+		loopStack:         es.loopStack,
 		backtrack:         es.backtrack.Copy(),
-		variableStack:     es.variableStack.Copy(),
-		callStack:         es.callStack.Copy(),
+		backtrackMemory:   es.backtrackMemory,
+		variableStack:     es.variableStack,
+		callStack:         es.callStack,
+		lookaroundStack:   es.lookaroundStack,
+		atomicStack:       es.atomicStack,
+		tree:              es.tree,
+		notIn:             es.notIn,
 		environment:       es.environment,
 		status:            es.status,
 		programCounter:    es.programCounter,
@@ -660,14 +1398,24 @@ func (es *SearchEngineState) Copy() *SearchEngineState {
 		startColumnNum:    es.startColumnNum,
 		reader:            es.reader,
 		filename:          es.filename,
+		memo:              es.memo,
+		exhausted:         es.exhausted,
+		buildTree:         es.buildTree,
+		err:               es.err,
 	}
 }
 
+// Set restores a checkpoint. The backtrack stack is left alone since it already holds
+// exactly the checkpoints that were pushed before this one.
 func (es *SearchEngineState) Set(value SearchEngineState) {
 	es.loopStack = value.loopStack
-	es.backtrack = value.backtrack
+	es.backtrackMemory = value.backtrackMemory
 	es.variableStack = value.variableStack
 	es.callStack = value.callStack
+	es.lookaroundStack = value.lookaroundStack
+	es.atomicStack = value.atomicStack
+	es.tree = value.tree
+	es.notIn = value.notIn
 	es.environment = value.environment
 	es.status = value.status
 	es.programCounter = value.programCounter
@@ -680,6 +1428,27 @@ func (es *SearchEngineState) Set(value SearchEngineState) {
 	es.startColumnNum = value.startColumnNum
 	es.reader = value.reader
 	es.filename = value.filename
+	es.memo = value.memo
+	es.exhausted = value.exhausted
+	es.buildTree = value.buildTree
+	es.err = value.err
+}
+
+// PARSENODE takes the parse nodes finished since frame was called off of the tree and makes them the children of the call
+func (es *SearchEngineState) PARSENODE(name string, frame CallState) ParseNode {
+	children := make([]ParseNode, es.tree.Size()-frame.treeSize)
+	for i := len(children) - 1; i >= 0; i-- {
+		children[i] = es.tree.Peek().GetValue()
+		es.tree = es.tree.Pop()
+	}
+	return ParseNode{
+		Name:     name,
+		Offset:   *ds.NewRange(frame.startFileOffset, es.currentFileOffset),
+		Line:     *ds.NewRange(frame.startLineNum, es.currentLineNum),
+		Column:   *ds.NewRange(frame.startColumnNum, es.currentColumnNum),
+		Value:    es.currentMatch[frame.startMatchOffset:],
+		Children: children,
+	}
 }
 
 func (es *SearchEngineState) MakeMatch(matchNumber int) Match {
@@ -690,8 +1459,24 @@ func (es *SearchEngineState) MakeMatch(matchNumber int) Match {
 		Line:        *ds.NewRange(es.startLineNum, es.currentLineNum),
 		Column:      *ds.NewRange(es.startColumnNum, es.currentColumnNum),
 		Value:       es.currentMatch,
-		Variables:   es.environment,
+		Variables:   environmentValue(es.environment),
+		Captures:    environmentCaptures(es.environment),
+		Tree:        es.TREE(),
+	}
+}
+
+// TREE is the parse nodes of the calls made by the match in the order they were made or nil when we aren't building a tree
+func (es *SearchEngineState) TREE() []ParseNode {
+	if !es.buildTree {
+		return nil
+	}
+	nodes := make([]ParseNode, es.tree.Size())
+	tree := es.tree
+	for i := len(nodes) - 1; i >= 0; i-- {
+		nodes[i] = tree.Peek().GetValue()
+		tree = tree.Pop()
 	}
+	return nodes
 }
 
 type ReplacerState struct {
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"github.com/jmeaster30/vore/libvore/bytecode"
+	"github.com/jmeaster30/vore/libvore/files"
+)
+
+type fileResult struct {
+	matches Matches
+	err     error
+}
+
+// searchFiles runs the command over each file with up to jobs goroutines. The results line up with
+// filenames so the caller can merge them in the same order no matter which search finished first.
+func searchFiles(ctx context.Context, command *bytecode.Command, filenames []string, processFilenames bool, mode ReplaceMode, limits Limits, jobs int) []fileResult {
+	results := make([]fileResult, len(filenames))
+	groups := fileGroups(touchedPaths(command, filenames, processFilenames, mode))
+
+	next := make(chan []int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < jobs && worker < len(groups); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range next {
+				for _, index := range group {
+					results[index] = searchFile(ctx, command, filenames[index], processFilenames, mode, limits)
+				}
+			}
+		}()
+	}
+	for _, group := range groups {
+		next <- group
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+func searchFile(ctx context.Context, command *bytecode.Command, filename string, processFilenames bool, mode ReplaceMode, limits Limits) (result fileResult) {
+	if err := ctx.Err(); err != nil {
+		result.err = err
+		return
+	}
+	if processFilenames {
+		result.matches, result.err = search(ctx, command, filename, files.ReaderFromString(filename), mode, limits)
+		return
+	}
+
+	reader, err := files.ReaderFromFile(filename)
+	if err != nil {
+		result.err = NewFileError(filename, err)
+		return
+	}
+	defer reader.Close()
+	result.matches, result.err = search(ctx, command, filename, reader, mode, limits)
+	if result.err == nil && reader.Err() != nil {
+		result.err = NewFileError(filename, reader.Err())
+	}
+	return
+}
+
+// touchedPaths lists the paths each search reads or writes
+func touchedPaths(command *bytecode.Command, filenames []string, processFilenames bool, mode ReplaceMode) [][]string {
+	var ci any = *command
+	_, replacing := ci.(bytecode.ReplaceCommand)
+
+	touched := make([][]string, len(filenames))
+	for i, filename := range filenames {
+		if processFilenames {
+			// only the filename is searched and renaming happens after all of the searches are done
+			continue
+		}
+		touched[i] = append(touched[i], pathKey(filename))
+		if replacing && mode == NEW {
+			touched[i] = append(touched[i], pathKey(filename+".vored"))
+		}
+	}
+	return touched
+}
+
+func pathKey(filename string) string {
+	absolute, err := filepath.Abs(filename)
+	if err != nil {
+		return filepath.Clean(filename)
+	}
+	return absolute
+}
+
+// fileGroups puts the searches that touch the same path into one group so they run one after another
+// in their original order. Groups come out in the order their first search shows up.
+func fileGroups(touched [][]string) [][]int {
+	parent := make([]int, len(touched))
+	find := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	owner := map[string]int{}
+	for i, paths := range touched {
+		parent[i] = i
+		for _, path := range paths {
+			j, ok := owner[path]
+			if !ok {
+				owner[path] = i
+				continue
+			}
+			// the earliest search stays the root so groups keep their order
+			a, b := find(i), find(j)
+			if a < b {
+				a, b = b, a
+			}
+			parent[a] = b
+		}
+	}
+
+	groupIndex := map[int]int{}
+	groups := [][]int{}
+	for i := range touched {
+		root := find(i)
+		g, ok := groupIndex[root]
+		if !ok {
+			g = len(groups)
+			groupIndex[root] = g
+			groups = append(groups, []int{})
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
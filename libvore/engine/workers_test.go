@@ -0,0 +1,27 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/jmeaster30/vore/libvore/testutils"
+)
+
+func TestFileGroupsSeparateFiles(t *testing.T) {
+	groups := fileGroups([][]string{{"a"}, {"b"}, {"c"}})
+	testutils.AssertEqual(t, [][]int{{0}, {1}, {2}}, groups)
+}
+
+func TestFileGroupsSameFile(t *testing.T) {
+	groups := fileGroups([][]string{{"a"}, {"b"}, {"a"}, {"c"}, {"b"}})
+	testutils.AssertEqual(t, [][]int{{0, 2}, {1, 4}, {3}}, groups)
+}
+
+func TestFileGroupsJoinedByLaterFile(t *testing.T) {
+	groups := fileGroups([][]string{{"a", "a.vored"}, {"b", "b.vored"}, {"c"}, {"a.vored", "a.vored.vored"}, {"b", "a"}})
+	testutils.AssertEqual(t, [][]int{{0, 1, 3, 4}, {2}}, groups)
+}
+
+func TestFileGroupsNothingTouched(t *testing.T) {
+	groups := fileGroups([][]string{nil, nil})
+	testutils.AssertEqual(t, [][]int{{0}, {1}}, groups)
+}
@@ -1,61 +1,83 @@
 package libvore
 
 import (
+	"errors"
+
 	"github.com/jmeaster30/vore/libvore/ast"
 	"github.com/jmeaster30/vore/libvore/bytecode"
 	"github.com/jmeaster30/vore/libvore/ds"
 	"github.com/jmeaster30/vore/libvore/engine"
 )
 
+// These are aliases so the errors keep their methods and can be found with errors.As
 type (
-	LexError      ast.LexError
-	ParseError    ast.ParseError
-	GenError      bytecode.GenError
-	SemanticError bytecode.SemanticError
-	ExecError     engine.ExecError
+	LexError      = ast.LexError
+	ParseError    = ast.ParseError
+	GenError      = bytecode.GenError
+	SemanticError = bytecode.SemanticError
+	ExecError     = engine.ExecError
+	LimitError    = engine.LimitError
 )
 
-func ToLexError(err error) ds.Optional[LexError] {
-	switch a := err.(type) {
-	case *ast.LexError:
-		return ds.Some(LexError(*a))
-	default:
-		return ds.None[LexError]()
-	}
+// CompileError is implemented by every error Compile and CompileFile return for a bad program.
+// Span is where in the source the problem is.
+type (
+	CompileError = ast.CompileError
+	Span         = ast.Span
+)
+
+// The errors of RunFiles come back together in a FileErrors so these are aliases to keep their methods
+// and work with errors.As.
+type (
+	FileError     = engine.FileError
+	FileErrors    = engine.FileErrors
+	InternalError = engine.InternalError
+)
+
+func ToLexError(err error) ds.Optional[*LexError] {
+	return toError[*LexError](err)
 }
 
-func ToParseError(err error) ds.Optional[ParseError] {
-	switch a := err.(type) {
-	case *ast.ParseError:
-		return ds.Some(ParseError(*a))
-	default:
-		return ds.None[ParseError]()
-	}
+func ToParseError(err error) ds.Optional[*ParseError] {
+	return toError[*ParseError](err)
 }
 
-func ToGenError(err error) ds.Optional[GenError] {
-	switch a := err.(type) {
-	case *bytecode.GenError:
-		return ds.Some(GenError(*a))
-	default:
-		return ds.None[GenError]()
-	}
+func ToGenError(err error) ds.Optional[*GenError] {
+	return toError[*GenError](err)
 }
 
-func ToSemanticError(err error) ds.Optional[SemanticError] {
-	switch a := err.(type) {
-	case *bytecode.SemanticError:
-		return ds.Some(SemanticError(*a))
-	default:
-		return ds.None[SemanticError]()
-	}
+func ToSemanticError(err error) ds.Optional[*SemanticError] {
+	return toError[*SemanticError](err)
 }
 
-func ToExecError(err error) ds.Optional[ExecError] {
-	switch a := err.(type) {
-	case *engine.ExecError:
-		return ds.Some(ExecError(*a))
-	default:
-		return ds.None[ExecError]()
+func ToExecError(err error) ds.Optional[*ExecError] {
+	return toError[*ExecError](err)
+}
+
+func ToLimitError(err error) ds.Optional[*LimitError] {
+	return toError[*LimitError](err)
+}
+
+// ToCompileError gets the position and message of the error from Compile or CompileFile no matter
+// which stage of the compiler it came from.
+func ToCompileError(err error) ds.Optional[CompileError] {
+	return toError[CompileError](err)
+}
+
+func toError[T error](err error) ds.Optional[T] {
+	var target T
+	if errors.As(err, &target) {
+		return ds.Some(target)
 	}
+	return ds.None[T]()
+}
+
+// ToFileError finds the first FileError in err. It also looks inside of the FileErrors returned by RunFiles.
+func ToFileError(err error) ds.Optional[*FileError] {
+	return toError[*FileError](err)
+}
+
+// ToFileErrors gets the error of each file that couldn't be searched from the error returned by RunFiles.
+func ToFileErrors(err error) ds.Optional[FileErrors] {
+	return toError[FileErrors](err)
 }
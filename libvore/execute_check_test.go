@@ -17,7 +17,8 @@ end
 
 find all divisibleBy3`)
 	testutils.CheckNoError(t, err)
-	results := vore.Run("123 4 6 51 52")
+	results, err := vore.Run("123 4 6 51 52")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "123", ds.None[string](), []TestVar{}},
 		{6, "6", ds.None[string](), []TestVar{}},
@@ -25,6 +26,24 @@ find all divisibleBy3`)
 	})
 }
 
+func TestPredicateOnlySeesPatternMatch(t *testing.T) {
+	vore, err := Compile(`
+set divisibleBy3 to pattern
+	at least 1 digit
+begin
+	return match % 3 == 0
+end
+
+find all 'x' divisibleBy3`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("x12 x7 x33")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "x12", ds.None[string](), []TestVar{}},
+		{7, "x33", ds.None[string](), []TestVar{}},
+	})
+}
+
 func TestTrueLiteralIfStmt(t *testing.T) {
 	vore, err := Compile(`
 set check to function
@@ -36,7 +55,8 @@ end
 
 replace all 'test' with check`)
 	testutils.CheckNoError(t, err)
-	results := vore.Run("this is a test")
+	results, err := vore.Run("this is a test")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{10, "test", ds.Some("oh yeah"), []TestVar{}},
 	})
@@ -54,7 +74,8 @@ end
 
 replace all 'test' with check`)
 	testutils.CheckNoError(t, err)
-	results := vore.Run("this is a test")
+	results, err := vore.Run("this is a test")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{10, "test", ds.Some("test"), []TestVar{}},
 	})
@@ -199,7 +220,8 @@ end
 
 replace all word start at least 1 any fewest word end with ">" matchRepeater "<"`)
 	testutils.CheckNoError(t, err)
-	results := vore.Run("this is a test")
+	results, err := vore.Run("this is a test")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "this", ds.Some(">this<"), []TestVar{}},
 		{5, "is", ds.Some(">isis<"), []TestVar{}},
@@ -211,8 +233,27 @@ replace all word start at least 1 any fewest word end with ">" matchRepeater "<"
 func TestTheDarknessInsideMe(t *testing.T) {
 	vore, err := Compile("replace all 'hello' with 'goodbye'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("this is it. hello world")
+	results, err := vore.Run("this is it. hello world")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{12, "hello", ds.Some("goodbye"), []TestVar{}},
 	})
 }
+
+func TestSemanticErrorSpan(t *testing.T) {
+	_, err := Compile(`
+set foo to transform
+	if 1 == 1 then
+		break
+	end
+end
+
+replace all "bar" with foo`)
+	semanticErr := ToSemanticError(err)
+	testutils.AssertTrue(t, semanticErr.HasValue())
+	testutils.AssertEqual(t, "Cannot use 'break' outside of a loop.", semanticErr.GetValue().Message())
+	span := semanticErr.GetValue().Span()
+	testutils.AssertEqual(t, 4, span.Line.Start)
+	testutils.AssertEqual(t, 3, span.Column.Start)
+	testutils.AssertEqual(t, 8, span.Column.End)
+}
@@ -3,9 +3,12 @@ package libvore
 import "github.com/jmeaster30/vore/libvore/engine"
 
 type (
-	Matches     engine.Matches
-	Match       engine.Match
+	Matches     = engine.Matches
+	Match       = engine.Match
 	ReplaceMode engine.ReplaceMode
+	Limits      = engine.Limits
+	Capture     = engine.Capture
+	ParseNode   = engine.ParseNode
 )
 
 const (
@@ -14,3 +17,6 @@ const (
 	NEW       ReplaceMode = ReplaceMode(engine.NEW)
 	NOTHING   ReplaceMode = ReplaceMode(engine.NOTHING)
 )
+
+// DefaultMaxCallDepth is the call depth a program is limited to until SetLimits is called
+const DefaultMaxCallDepth = engine.DefaultMaxCallDepth
@@ -17,7 +17,7 @@ type BufferedFile struct {
 	currentOffset int64
 }
 
-func NewBufferedFile(file *os.File, fileSize int64) *BufferedFile {
+func NewBufferedFile(file *os.File, fileSize int64) (*BufferedFile, error) {
 	bufferSize := int64(4096)
 
 	bufferedFile := &BufferedFile{
@@ -31,12 +31,13 @@ func NewBufferedFile(file *os.File, fileSize int64) *BufferedFile {
 	}
 
 	bytesRead, err := bufferedFile.file.Read(bufferedFile.buffer)
-	if err != nil {
-		panic(err)
+	// an empty file is at its end right away
+	if err != nil && err != io.EOF {
+		return nil, err
 	}
 	bufferedFile.maxOffset = int64(bytesRead)
 
-	return bufferedFile
+	return bufferedFile, nil
 }
 
 func (v *BufferedFile) Read(p []byte) (int, error) {
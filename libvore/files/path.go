@@ -115,7 +115,9 @@ func normalize(path string) string {
 		} else if part == "~" {
 			current, err := user.Current()
 			if err != nil {
-				panic(err)
+				// without a user we can't tell where home is so the path is left as it is
+				finalPath = append(finalPath, part)
+				continue
 			}
 			finalPath = []string{"/", "home", current.Username}
 		} else if part == "" {
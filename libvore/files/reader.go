@@ -2,44 +2,56 @@ package files
 
 import (
 	"io"
+	"math"
 	"os"
+	"strings"
+	"unicode/utf8"
 )
 
 type Reader struct {
 	contents ReadSeekCloser
+	stream   *WindowedStream // only set when we don't know the size up front
 	offset   int
 	size     int
+	err      error // the first error reading the contents failed with
 }
 
-func ReaderFromFileToMemory(filename string) *Reader {
+func ReaderFromFileToMemory(filename string) (*Reader, error) {
 	contents, err := os.ReadFile(filename)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	return &Reader{
 		contents: NewStringReadCloser(string(contents)),
 		offset:   0,
 		size:     len(contents),
-	}
+	}, nil
 }
 
-func ReaderFromFile(filename string) *Reader {
+func ReaderFromFile(filename string) (*Reader, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	fileinfo, err := file.Stat()
 	if err != nil {
-		panic(err)
+		file.Close()
+		return nil, err
+	}
+
+	contents, err := NewBufferedFile(file, fileinfo.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
 	}
 
 	return &Reader{
-		contents: NewBufferedFile(file, fileinfo.Size()),
+		contents: contents,
 		offset:   0,
 		size:     int(fileinfo.Size()),
-	}
+	}, nil
 }
 
 func ReaderFromString(contents string) *Reader {
@@ -50,26 +62,97 @@ func ReaderFromString(contents string) *Reader {
 	}
 }
 
+// ReaderFromReader reads from a source of unknown size like stdin or a pipe. The source is only
+// read as far as the search has looked and bytes are dropped once they are released.
+func ReaderFromReader(source io.Reader) *Reader {
+	stream := NewWindowedStream(source)
+	return &Reader{
+		contents: stream,
+		stream:   stream,
+		offset:   0,
+		size:     -1,
+	}
+}
+
+// Size returns the size of the contents. For a stream this has to read the rest of the stream
+// so AtEnd and Clamp should be used when only the nearby end of the contents matters.
 func (v *Reader) Size() int {
+	if v.stream != nil {
+		return int(v.stream.Buffered(math.MaxInt64))
+	}
 	return v.size
 }
 
+// Clamp returns how many of the length bytes starting at offset are in the contents.
+// Once reading has failed the contents look like they end so searches stop instead of reading garbage.
+func (v *Reader) Clamp(offset int, length int) int {
+	if v.err != nil {
+		return 0
+	}
+	available := v.size
+	if v.stream != nil {
+		available = int(v.stream.Buffered(int64(offset + length)))
+	}
+	if offset+length > available {
+		length = available - offset
+	}
+	if length < 0 {
+		return 0
+	}
+	return length
+}
+
+// AtEnd reports whether offset is at or past the end of the contents
+func (v *Reader) AtEnd(offset int) bool {
+	return v.Clamp(offset, 1) == 0
+}
+
+func (v *Reader) Streaming() bool {
+	return v.stream != nil
+}
+
+// Release tells a stream that nothing before offset will be read again so it can stop holding onto it.
+// Readers with a known size keep their contents around and ignore this.
+func (v *Reader) Release(offset int) {
+	if v.stream != nil {
+		v.stream.Release(int64(offset))
+	}
+}
+
+// Err returns the first error reading the contents failed with. The contents look like they end where the error happened.
+func (v *Reader) Err() error {
+	if v.err != nil {
+		return v.err
+	}
+	if v.stream != nil {
+		return v.stream.Err()
+	}
+	return nil
+}
+
+func (v *Reader) fail(err error) {
+	if v.err == nil {
+		v.err = err
+	}
+}
+
 func (v *Reader) Seek(offset int) {
 	v.offset = offset
 	_, err := v.contents.Seek(int64(offset), io.SeekStart)
 	if err != nil {
-		panic(err)
+		v.fail(err)
 	}
 }
 
 func (v *Reader) Read(length int) string {
-	if v.offset+length-1 >= v.size {
+	if v.err != nil || length >= 0 && v.Clamp(v.offset, length) != length {
 		return ""
 	}
 	currentString := make([]byte, length)
 	n, err := v.contents.Read(currentString)
 	if err != nil {
-		panic(err)
+		v.fail(err)
+		return ""
 	}
 	if n != length {
 		return ""
@@ -78,14 +161,18 @@ func (v *Reader) Read(length int) string {
 }
 
 func (v *Reader) ReadAt(length int, offset int) string {
-	if offset+length-1 >= v.size {
+	if v.err != nil || length >= 0 && v.Clamp(offset, length) != length {
 		return ""
 	}
-	currentString := make([]byte, length)
 	v.Seek(offset)
+	if v.err != nil {
+		return ""
+	}
+	currentString := make([]byte, length)
 	n, err := v.contents.Read(currentString)
 	if err != nil {
-		panic(err)
+		v.fail(err)
+		return ""
 	}
 	if n != length {
 		return ""
@@ -93,9 +180,80 @@ func (v *Reader) ReadAt(length int, offset int) string {
 	return string(currentString)
 }
 
-func (v *Reader) Close() {
-	err := v.contents.Close()
-	if err != nil {
-		panic(err)
+// ReadRunes reads count UTF-8 encoded runes starting at the current offset.
+// Like Read, it returns "" when there are not enough runes left to read.
+func (v *Reader) ReadRunes(count int) string {
+	if count <= 0 {
+		return ""
+	}
+	start := v.offset
+	length := v.Clamp(start, count*utf8.UTFMax)
+	if length <= 0 {
+		return ""
+	}
+	buffer := v.ReadAt(length, start)
+	end := 0
+	for i := 0; i < count; i++ {
+		if end >= len(buffer) {
+			return ""
+		}
+		_, width := utf8.DecodeRuneInString(buffer[end:])
+		end += width
 	}
+	v.Seek(start + end)
+	return buffer[:end]
+}
+
+// ReadRuneAt decodes the rune that starts at offset. It returns the rune and its
+// width in bytes, or (utf8.RuneError, 0) when offset is at or past the end.
+func (v *Reader) ReadRuneAt(offset int) (rune, int) {
+	if offset < 0 {
+		return utf8.RuneError, 0
+	}
+	length := v.Clamp(offset, utf8.UTFMax)
+	if length == 0 {
+		return utf8.RuneError, 0
+	}
+	return utf8.DecodeRuneInString(v.ReadAt(length, offset))
+}
+
+// ReadRuneBefore decodes the rune that ends right before offset. It returns the
+// rune and its width in bytes, or (utf8.RuneError, 0) when offset is at the start.
+func (v *Reader) ReadRuneBefore(offset int) (rune, int) {
+	if offset <= 0 || v.Clamp(offset-1, 1) == 0 {
+		return utf8.RuneError, 0
+	}
+	length := utf8.UTFMax
+	if offset-length < 0 {
+		length = offset
+	}
+	return utf8.DecodeLastRuneInString(v.ReadAt(length, offset-length))
+}
+
+// Index returns the offset of the first occurrence of value at or after offset,
+// or -1 when value does not occur in the rest of the contents.
+func (v *Reader) Index(offset int, value string) int {
+	// start small since the next occurrence is usually close and grow the chunks when it isn't
+	chunkSize := 1 << 12
+	if len(value) == 0 {
+		return offset
+	}
+	for {
+		// chunks overlap so we find occurrences that cross a chunk boundary
+		length := v.Clamp(offset, chunkSize+len(value)-1)
+		if length < len(value) {
+			return -1
+		}
+		if chunkSize < 1<<16 {
+			chunkSize *= 2
+		}
+		if i := strings.Index(v.ReadAt(length, offset), value); i != -1 {
+			return offset + i
+		}
+		offset += length - len(value) + 1
+	}
+}
+
+func (v *Reader) Close() error {
+	return v.contents.Close()
 }
@@ -1,7 +1,11 @@
 package files
 
 import (
+	"errors"
+	"io/fs"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/jmeaster30/vore/libvore/testutils"
 )
@@ -9,18 +13,14 @@ import (
 func TestWriterTest(t *testing.T) {
 	filename := testutils.GetTestingFilename(t, true)
 
-	writer := WriterFromFile(filename)
-	if writer == nil {
-		t.Errorf("Writer was initialized as nil :(")
-	}
+	writer, err := WriterFromFile(filename)
+	testutils.CheckNoError(t, err)
 
-	writer.WriteAt(0, "my data :)")
-	writer.Close()
+	testutils.CheckNoError(t, writer.WriteAt(0, "my data :)"))
+	testutils.CheckNoError(t, writer.Close())
 
-	reader := ReaderFromFile(filename)
-	if reader == nil {
-		t.Errorf("Reader was initialized as nil :(")
-	}
+	reader, err := ReaderFromFile(filename)
+	testutils.CheckNoError(t, err)
 
 	value := reader.Read(10)
 	if len(value) != 10 {
@@ -31,47 +31,38 @@ func TestWriterTest(t *testing.T) {
 		t.Errorf("Expected to read 'my data :)' from file but actually read '%s'", value)
 	}
 
-	reader.Close()
+	testutils.CheckNoError(t, reader.Close())
 	testutils.RemoveTestingFile(t, filename)
 }
 
-func TestWriterPanicTest(t *testing.T) {
+func TestWriterErrorTest(t *testing.T) {
 	filename := testutils.GetTestingFilename(t, true)
 
-	writer := WriterFromFile(filename)
-	if writer == nil {
-		t.Errorf("Writer was initialized as nil :(")
-	}
+	writer, err := WriterFromFile(filename)
+	testutils.CheckNoError(t, err)
 
-	testutils.MustPanic(t, "Expected panic when writing 'my data :)' to -7", func(t *testing.T) {
-		writer.WriteAt(-7, "my data :)")
-	})
-
-	writer.Close()
+	if err := writer.WriteAt(-7, "my data :)"); err == nil {
+		t.Errorf("Expected an error when writing 'my data :)' to -7")
+	}
 
-	// TODO I can't figure out how to hit the other panics in the VWriter
+	testutils.CheckNoError(t, writer.Close())
 
-	testutils.MustPanic(t, "Expected panic when closing an already closed writer", func(t *testing.T) {
-		writer.Close()
-	})
+	if err := writer.Close(); err == nil {
+		t.Errorf("Expected an error when closing an already closed writer")
+	}
 
 	testutils.RemoveTestingFile(t, filename)
 }
 
 func TestReaderTest(t *testing.T) {
 	filename := testutils.GetTestingFilename(t, true)
-	writer := WriterFromFile(filename)
-	if writer == nil {
-		t.Errorf("Writer initialized as nil :(")
-	}
-	writer.WriteAt(0, "hello world")
-	writer.Close()
+	writer, err := WriterFromFile(filename)
+	testutils.CheckNoError(t, err)
+	testutils.CheckNoError(t, writer.WriteAt(0, "hello world"))
+	testutils.CheckNoError(t, writer.Close())
 
-	reader := ReaderFromFile(filename)
-
-	testutils.MustPanic(t, "Expected panic when seeking to -1", func(t *testing.T) {
-		reader.Seek(-1)
-	})
+	reader, err := ReaderFromFile(filename)
+	testutils.CheckNoError(t, err)
 
 	testutils.MustPanic(t, "Expected panic when reading a negative length string", func(t *testing.T) {
 		reader.Read(-1)
@@ -83,34 +74,150 @@ func TestReaderTest(t *testing.T) {
 	}
 
 	reader.Seek(0)
+	testutils.CheckNoError(t, reader.Err())
 
-	reader.Close()
+	reader.Seek(-1)
+	if reader.Err() == nil {
+		t.Errorf("Expected an error after seeking to -1")
+	}
+	testutils.AssertEqual(t, "", reader.ReadAt(5, 0))
 
-	testutils.MustPanic(t, "Expected panic when read from an already closed reader", func(t *testing.T) {
-		value := reader.Read(5)
-		t.Errorf("Value was returned as '%s' (offset: %d, size: %d)", value, reader.offset, reader.size)
-	})
+	testutils.CheckNoError(t, reader.Close())
+	testutils.RemoveTestingFile(t, filename)
+}
 
-	testutils.MustPanic(t, "Expected panic when read at from an already closed reader", func(t *testing.T) {
-		value := reader.ReadAt(5, 0)
-		t.Errorf("Value was returned as '%s' (offset: %d, size: %d)", value, reader.offset, reader.size)
-	})
+func TestReaderClosedTest(t *testing.T) {
+	filename := testutils.GetTestingFilename(t, true)
+	writer, err := WriterFromFile(filename)
+	testutils.CheckNoError(t, err)
+	testutils.CheckNoError(t, writer.WriteAt(0, "hello world"))
+	testutils.CheckNoError(t, writer.Close())
+
+	reader, err := ReaderFromFile(filename)
+	testutils.CheckNoError(t, err)
+	testutils.CheckNoError(t, reader.Close())
+
+	// a reader that failed looks like it has nothing left to read
+	testutils.AssertEqual(t, "", reader.ReadAt(5, 0))
+	if reader.Err() == nil {
+		t.Errorf("Expected an error after reading from an already closed reader")
+	}
+	testutils.AssertEqual(t, "", reader.Read(5))
 
-	testutils.MustPanic(t, "Expected panic when closing an already closed reader", func(t *testing.T) {
-		reader.Close()
-	})
+	if err := reader.Close(); err == nil {
+		t.Errorf("Expected an error when closing an already closed reader")
+	}
+
+	testutils.RemoveTestingFile(t, filename)
+}
+
+func TestReaderEmptyFile(t *testing.T) {
+	filename := testutils.GetTestingFilename(t, true)
+	writer, err := WriterFromFile(filename)
+	testutils.CheckNoError(t, err)
+	testutils.CheckNoError(t, writer.Close())
+
+	reader, err := ReaderFromFile(filename)
+	testutils.CheckNoError(t, err)
+	testutils.AssertTrue(t, reader.AtEnd(0))
+	testutils.CheckNoError(t, reader.Close())
 
 	testutils.RemoveTestingFile(t, filename)
 }
 
-func TestReaderPanicTest(t *testing.T) {
+func TestReaderMissingFileTest(t *testing.T) {
 	filename := testutils.GetTestingFilename(t, false)
 
-	testutils.MustPanic(t, "Expected to panic when opening a file that is supposed to not exist", func(t *testing.T) {
-		_ = ReaderFromFileToMemory(filename)
-	})
+	_, err := ReaderFromFileToMemory(filename)
+	testutils.AssertTrue(t, errors.Is(err, fs.ErrNotExist))
 
-	testutils.MustPanic(t, "Expected to panic when opening a file that is supposed to not exist", func(t *testing.T) {
-		_ = ReaderFromFile(filename)
-	})
+	_, err = ReaderFromFile(filename)
+	testutils.AssertTrue(t, errors.Is(err, fs.ErrNotExist))
+}
+
+func TestReaderRunes(t *testing.T) {
+	reader := ReaderFromString("añb日本")
+
+	testutils.AssertEqual(t, "añb", reader.ReadRunes(3))
+	testutils.AssertEqual(t, "日本", reader.ReadRunes(2))
+	reader.Seek(4)
+	testutils.AssertEqual(t, "", reader.ReadRunes(3))
+
+	r, width := reader.ReadRuneAt(1)
+	testutils.AssertEqual(t, 'ñ', r)
+	testutils.AssertEqual(t, 2, width)
+
+	r, width = reader.ReadRuneBefore(reader.Size())
+	testutils.AssertEqual(t, '本', r)
+	testutils.AssertEqual(t, 3, width)
+
+	_, width = reader.ReadRuneAt(reader.Size())
+	testutils.AssertEqual(t, 0, width)
+	_, width = reader.ReadRuneBefore(0)
+	testutils.AssertEqual(t, 0, width)
+
+	reader.Close()
+}
+
+func TestReaderIndex(t *testing.T) {
+	reader := ReaderFromString("abc@def@g")
+
+	testutils.AssertEqual(t, 3, reader.Index(0, "@"))
+	testutils.AssertEqual(t, 7, reader.Index(4, "@"))
+	testutils.AssertEqual(t, -1, reader.Index(8, "@"))
+	testutils.AssertEqual(t, 4, reader.Index(0, "def"))
+	testutils.AssertEqual(t, -1, reader.Index(0, "xyz"))
+	testutils.AssertEqual(t, 2, reader.Index(2, ""))
+
+	reader.Close()
+}
+
+func TestReaderIndexAcrossChunks(t *testing.T) {
+	contents := strings.Repeat("a", (1<<16)-2) + "needle" + strings.Repeat("b", 1<<17) + "needle"
+	reader := ReaderFromString(contents)
+
+	testutils.AssertEqual(t, (1<<16)-2, reader.Index(0, "needle"))
+	testutils.AssertEqual(t, len(contents)-6, reader.Index((1<<16)-1, "needle"))
+
+	reader.Close()
+}
+
+func TestReaderFromReader(t *testing.T) {
+	reader := ReaderFromReader(iotest.OneByteReader(strings.NewReader("añb日本")))
+
+	testutils.AssertTrue(t, reader.Streaming())
+	testutils.AssertFalse(t, reader.AtEnd(0))
+	testutils.AssertEqual(t, 3, reader.Clamp(0, 3))
+	testutils.AssertEqual(t, "añb", reader.ReadRunes(3))
+	testutils.AssertEqual(t, "b", reader.ReadAt(1, 3))
+	testutils.AssertEqual(t, "", reader.ReadAt(20, 3))
+	testutils.AssertEqual(t, 2, reader.Clamp(8, 10))
+	testutils.AssertTrue(t, reader.AtEnd(10))
+	testutils.AssertEqual(t, 10, reader.Size())
+	testutils.AssertEqual(t, 3, reader.Index(0, "b"))
+	testutils.AssertEqual(t, -1, reader.Index(0, "x"))
+	testutils.CheckNoError(t, reader.Err())
+}
+
+func TestReaderFromReaderRelease(t *testing.T) {
+	reader := ReaderFromReader(strings.NewReader(strings.Repeat("abc", 100000)))
+
+	reader.Release(1000)
+	testutils.AssertEqual(t, "bca", reader.ReadAt(3, 1000))
+	testutils.AssertEqual(t, "abc", reader.ReadAt(3, 201000))
+	reader.Release(200000)
+	testutils.AssertTrue(t, len(reader.stream.buffer) <= 100000)
+	testutils.AssertEqual(t, "abc", reader.ReadAt(3, 201000))
+	testutils.AssertEqual(t, "", reader.ReadAt(3, 1000))
+	if reader.Err() == nil {
+		t.Errorf("Expected an error when reading from a released offset")
+	}
+}
+
+func TestReaderFromReaderError(t *testing.T) {
+	reader := ReaderFromReader(iotest.TimeoutReader(strings.NewReader("hello world")))
+
+	testutils.AssertEqual(t, "hello", reader.ReadAt(5, 0))
+	testutils.AssertEqual(t, "", reader.ReadAt(5, 100000))
+	testutils.AssertEqual(t, iotest.ErrTimeout, reader.Err())
 }
@@ -0,0 +1,113 @@
+package files
+
+import (
+	"errors"
+	"io"
+)
+
+// WindowedStream makes an unsized io.Reader seekable by buffering what has been read from it.
+// Bytes before the released offset are dropped so only the part of the stream that can still
+// be looked at is kept in memory.
+type WindowedStream struct {
+	closed        bool
+	source        io.Reader
+	err           error
+	eof           bool
+	buffer        []byte
+	bufferStart   int64
+	currentOffset int64
+}
+
+const windowedStreamChunkSize = 1 << 15
+
+func NewWindowedStream(source io.Reader) *WindowedStream {
+	return &WindowedStream{
+		closed:        false,
+		source:        source,
+		buffer:        []byte{},
+		bufferStart:   0,
+		currentOffset: 0,
+	}
+}
+
+// Buffered reads from the source until the buffer reaches end or the source runs out and
+// returns the offset the buffer ends at. Once the source runs out that is the size of the stream.
+func (v *WindowedStream) Buffered(end int64) int64 {
+	for !v.eof && v.bufferStart+int64(len(v.buffer)) < end {
+		if cap(v.buffer)-len(v.buffer) < windowedStreamChunkSize {
+			grown := make([]byte, len(v.buffer), 2*len(v.buffer)+windowedStreamChunkSize)
+			copy(grown, v.buffer)
+			v.buffer = grown
+		}
+		n, err := v.source.Read(v.buffer[len(v.buffer):cap(v.buffer)])
+		v.buffer = v.buffer[:len(v.buffer)+n]
+		if err == io.EOF {
+			v.eof = true
+		} else if err != nil {
+			v.err = err
+			v.eof = true
+		}
+	}
+	return v.bufferStart + int64(len(v.buffer))
+}
+
+// Release drops the buffered bytes before offset. Seeking back before a released offset is an error.
+func (v *WindowedStream) Release(offset int64) {
+	if offset <= v.bufferStart {
+		return
+	}
+	if end := v.bufferStart + int64(len(v.buffer)); offset > end {
+		offset = end
+	}
+	// slicing keeps the old array around until the buffer has to grow again
+	v.buffer = v.buffer[offset-v.bufferStart:]
+	v.bufferStart = offset
+}
+
+// Err returns the error the source failed with other than io.EOF
+func (v *WindowedStream) Err() error {
+	return v.err
+}
+
+func (v *WindowedStream) Read(p []byte) (int, error) {
+	if v.closed {
+		return 0, io.ErrClosedPipe
+	}
+	end := v.Buffered(v.currentOffset + int64(len(p)))
+	if v.currentOffset >= end {
+		return 0, io.EOF
+	}
+	n := copy(p, v.buffer[v.currentOffset-v.bufferStart:])
+	v.currentOffset += int64(n)
+	return n, nil
+}
+
+func (v *WindowedStream) Seek(offset int64, whence int) (int64, error) {
+	if v.closed {
+		return 0, io.ErrClosedPipe
+	}
+	newOffset := v.currentOffset
+	if whence == io.SeekStart {
+		newOffset = offset
+	} else if whence == io.SeekCurrent {
+		newOffset += offset
+	} else if whence == io.SeekEnd {
+		return v.currentOffset, errors.New("cannot seek from the end of a stream")
+	}
+
+	if newOffset < v.bufferStart {
+		return v.currentOffset, errors.New("seeking to a stream offset that was already released")
+	}
+
+	v.currentOffset = newOffset
+	return v.currentOffset, nil
+}
+
+func (v *WindowedStream) Close() error {
+	if v.closed {
+		return io.ErrClosedPipe
+	}
+	// the source belongs to whoever handed it to us so we leave it open
+	v.closed = true
+	return nil
+}
@@ -9,15 +9,15 @@ type Writer struct {
 	contents WriteSeekCloser
 }
 
-func WriterFromFile(filename string) *Writer {
+func WriterFromFile(filename string) (*Writer, error) {
 	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.FileMode(0666))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	return &Writer{
 		contents: file,
-	}
+	}, nil
 }
 
 func WriterFromMemory() *Writer {
@@ -26,20 +26,15 @@ func WriterFromMemory() *Writer {
 	}
 }
 
-func (vw *Writer) WriteAt(offset int, data string) {
-	_, serr := vw.contents.Seek(int64(offset), io.SeekStart)
-	if serr != nil {
-		panic(serr)
-	}
-	_, werr := vw.contents.Write([]byte(data))
-	if werr != nil {
-		panic(werr)
+func (vw *Writer) WriteAt(offset int, data string) error {
+	_, err := vw.contents.Seek(int64(offset), io.SeekStart)
+	if err != nil {
+		return err
 	}
+	_, err = vw.contents.Write([]byte(data))
+	return err
 }
 
-func (vw *Writer) Close() {
-	err := vw.contents.Close()
-	if err != nil {
-		panic(err)
-	}
+func (vw *Writer) Close() error {
+	return vw.contents.Close()
 }
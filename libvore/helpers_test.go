@@ -76,3 +76,16 @@ func checkVoreError(t *testing.T, err error, expectedType string, expectedMessag
 		t.Errorf("Expected message '%s' but got '%s'", expectedMessageFixed, err.Error())
 	}
 }
+
+// treeString writes a parse tree as name(child child) with the value of each leaf in quotes
+func treeString(nodes []engine.ParseNode) string {
+	parts := []string{}
+	for _, node := range nodes {
+		if len(node.Children) == 0 {
+			parts = append(parts, fmt.Sprintf("%s'%s'", node.Name, node.Value))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s(%s)", node.Name, treeString(node.Children)))
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -1,9 +1,11 @@
 package libvore
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/jmeaster30/vore/libvore/ast"
@@ -14,10 +16,12 @@ import (
 type Vore struct {
 	ast      *ast.Ast
 	bytecode *bytecode.Bytecode
+	limits   engine.Limits
+	jobs     int
 }
 
 func Compile(command string) (*Vore, error) {
-	return compile(strings.NewReader(command))
+	return compile(strings.NewReader(command), "source", "")
 }
 
 func CompileFile(source string) (*Vore, error) {
@@ -25,29 +29,70 @@ func CompileFile(source string) (*Vore, error) {
 	if err != nil {
 		return nil, err
 	}
-	return compile(source_file)
+	defer source_file.Close()
+	return compile(source_file, source, filepath.Dir(source))
 }
 
-func compile(reader io.Reader) (*Vore, error) {
+// compile parses and generates the program in reader. Term list files are loaded relative to directory.
+func compile(reader io.Reader, name string, directory string) (result *Vore, err error) {
+	// a bug in the compiler shouldn't crash whatever program is using it
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, engine.NewInternalError(name, r)
+		}
+	}()
+
 	commands, err := ast.ParseReader(reader)
 	if err != nil {
 		return nil, err
 	}
 
-	bytecode, err := bytecode.GenerateBytecode(commands)
+	bytecode, err := bytecode.GenerateBytecodeIn(commands, directory)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Vore{commands, bytecode}, nil
+	return &Vore{commands, bytecode, engine.DefaultLimits(), 1}, nil
+}
+
+// SetLimits bounds the work done by later runs. When a limit is hit the run stops and returns a LimitError.
+// The limits replace DefaultLimits so leaving MaxCallDepth at zero lets recursive patterns call themselves
+// without a bound.
+func (v *Vore) SetLimits(limits engine.Limits) {
+	v.limits = limits
+}
+
+// SetJobs sets how many files RunFiles searches at the same time. Zero or less uses one per CPU.
+// The matches come back in the same order whatever the number of jobs.
+func (v *Vore) SetJobs(jobs int) {
+	v.jobs = jobs
+}
+
+func (v *Vore) Run(searchText string) (engine.Matches, error) {
+	return v.RunContext(context.Background(), searchText)
+}
+
+// RunContext stops searching once ctx is done and returns the matches found so far along with ctx.Err()
+func (v *Vore) RunContext(ctx context.Context, searchText string) (engine.Matches, error) {
+	return engine.RunContext(ctx, v.bytecode, searchText, v.limits)
+}
+
+// RunReader searches everything read from source. The name is used as the filename of the matches.
+func (v *Vore) RunReader(source io.Reader, name string) (engine.Matches, error) {
+	return v.RunReaderContext(context.Background(), source, name)
+}
+
+func (v *Vore) RunReaderContext(ctx context.Context, source io.Reader, name string) (engine.Matches, error) {
+	return engine.RunReaderContext(ctx, v.bytecode, source, name, v.limits)
 }
 
-func (v *Vore) Run(searchText string) engine.Matches {
-	return engine.Run(v.bytecode, searchText)
+func (v *Vore) RunFiles(filenames []string, mode engine.ReplaceMode, processFilenames bool) (engine.Matches, error) {
+	return v.RunFilesContext(context.Background(), filenames, mode, processFilenames)
 }
 
-func (v *Vore) RunFiles(filenames []string, mode engine.ReplaceMode, processFilenames bool) engine.Matches {
-	return engine.RunFiles(v.bytecode, filenames, mode, processFilenames)
+// RunFilesContext stops searching once ctx is done and returns the matches found so far along with ctx.Err()
+func (v *Vore) RunFilesContext(ctx context.Context, filenames []string, mode engine.ReplaceMode, processFilenames bool) (engine.Matches, error) {
+	return engine.RunFilesContext(ctx, v.bytecode, filenames, mode, processFilenames, v.limits, v.jobs)
 }
 
 func (v *Vore) PrintAST() {
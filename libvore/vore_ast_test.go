@@ -1,6 +1,11 @@
 package libvore
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/jmeaster30/vore/libvore/ast"
+	"github.com/jmeaster30/vore/libvore/testutils"
+)
 
 func TestBigTest(t *testing.T) {
 	vore, err := Compile(`
@@ -15,3 +20,41 @@ func TestBigTest(t *testing.T) {
 	}
 	vore.PrintAST()
 }
+
+func checkSpan(t *testing.T, span Span, line int, startColumn int, endColumn int) {
+	t.Helper()
+	testutils.AssertEqual(t, line, span.Line.Start)
+	testutils.AssertEqual(t, startColumn, span.Column.Start)
+	testutils.AssertEqual(t, endColumn, span.Column.End)
+}
+
+func TestNodeSpans(t *testing.T) {
+	vore, err := Compile("find all 'a'\nreplace all  @/b[0-9]+/ with 'c'")
+	testutils.CheckNoError(t, err)
+
+	commands := vore.ast.Commands()
+	testutils.AssertEqual(t, 2, len(commands))
+	checkSpan(t, commands[0].Span(), 1, 1, 13)
+	checkSpan(t, commands[1].Span(), 2, 1, 33)
+
+	find := commands[0].(*ast.AstFind)
+	checkSpan(t, find.Body[0].Span(), 1, 10, 13)
+
+	// the parts of a regexp point inside of it
+	replace := commands[1].(*ast.AstReplace)
+	regexp := replace.Body[0].(*ast.AstPrimary).Literal.(*ast.AstSubExpr)
+	checkSpan(t, regexp.Body[0].Span(), 2, 16, 17)
+	checkSpan(t, regexp.Body[1].Span(), 2, 17, 23)
+}
+
+func TestProcessNodeSpans(t *testing.T) {
+	vore, err := Compile(`set x to transform
+	return 1 + matchLength
+end`)
+	testutils.CheckNoError(t, err)
+
+	set := vore.ast.Commands()[0].(*ast.AstSet)
+	ret := set.Body.(*ast.AstSetTransform).Statements[0].(*ast.AstProcessReturn)
+	checkSpan(t, ret.Span(), 2, 2, 24)
+	checkSpan(t, ret.Expr.Span(), 2, 9, 24)
+}
@@ -1,23 +1,34 @@
 package libvore
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/jmeaster30/vore/libvore/ds"
+	"github.com/jmeaster30/vore/libvore/engine"
 	"github.com/jmeaster30/vore/libvore/testutils"
 )
 
 func TestFindString(t *testing.T) {
 	vore, err := Compile("find all 'yay'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("OMG yay :)")
+	results, err := vore.Run("OMG yay :)")
+	testutils.CheckNoError(t, err)
 	singleMatch(t, results, 4, "yay")
 }
 
 func TestFindDigit(t *testing.T) {
 	vore, err := Compile("find all digit")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("please 1234567890 wow")
+	results, err := vore.Run("please 1234567890 wow")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{7, "1", ds.None[string](), []TestVar{}},
 		{8, "2", ds.None[string](), []TestVar{}},
@@ -35,35 +46,40 @@ func TestFindDigit(t *testing.T) {
 func TestFindAtLeast1Digit(t *testing.T) {
 	vore, err := Compile("find all at least 1 digit")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("please 1234567890 wow")
+	results, err := vore.Run("please 1234567890 wow")
+	testutils.CheckNoError(t, err)
 	singleMatch(t, results, 7, "1234567890")
 }
 
 func TestFindEscapedCharacters(t *testing.T) {
 	vore, err := Compile("find all '\\x77\\x6f\\x77\\x20\\x3B\\x29'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("does this work? wow ;)")
+	results, err := vore.Run("does this work? wow ;)")
+	testutils.CheckNoError(t, err)
 	singleMatch(t, results, 16, "wow ;)")
 }
 
 func TestFindWhitespace(t *testing.T) {
 	vore, err := Compile("find all whitespace 'source' whitespace")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("you must provide a source for your claims.")
+	results, err := vore.Run("you must provide a source for your claims.")
+	testutils.CheckNoError(t, err)
 	singleMatch(t, results, 18, " source ")
 }
 
 func TestFindLetter(t *testing.T) {
 	vore, err := Compile("find all letter")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("345A98(&$(#*%")
+	results, err := vore.Run("345A98(&$(#*%")
+	testutils.CheckNoError(t, err)
 	singleMatch(t, results, 3, "A")
 }
 
 func TestFindAny(t *testing.T) {
 	vore, err := Compile("find all between 3 and 5 any")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("omg this is cool :)")
+	results, err := vore.Run("omg this is cool :)")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "omg t", ds.None[string](), []TestVar{}},
 		{5, "his i", ds.None[string](), []TestVar{}},
@@ -75,7 +91,8 @@ func TestFindAny(t *testing.T) {
 func TestFindAnyFewest(t *testing.T) {
 	vore, err := Compile("find all between 3 and 5 any fewest")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("omg this is")
+	results, err := vore.Run("omg this is")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "omg", ds.None[string](), []TestVar{}},
 		{3, " th", ds.None[string](), []TestVar{}},
@@ -86,7 +103,8 @@ func TestFindAnyFewest(t *testing.T) {
 func TestFindFewest(t *testing.T) {
 	vore, err := Compile("find all at least 3 letter fewest ' '")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("oh wow geez nice")
+	results, err := vore.Run("oh wow geez nice")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{3, "wow ", ds.None[string](), []TestVar{}},
 		{7, "geez ", ds.None[string](), []TestVar{}},
@@ -96,7 +114,8 @@ func TestFindFewest(t *testing.T) {
 func TestFindAtLeast3Upper(t *testing.T) {
 	vore, err := Compile("find all at least 3 upper")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("it SHOULD get THIS but THis")
+	results, err := vore.Run("it SHOULD get THIS but THis")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{3, "SHOULD", ds.None[string](), []TestVar{}},
 		{14, "THIS", ds.None[string](), []TestVar{}},
@@ -106,7 +125,8 @@ func TestFindAtLeast3Upper(t *testing.T) {
 func TestFindAtMost2Lower(t *testing.T) {
 	vore, err := Compile("find all at most 2 lower")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("IT WILL CATCH this AND it WILL GET me")
+	results, err := vore.Run("IT WILL CATCH this AND it WILL GET me")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{14, "th", ds.None[string](), []TestVar{}},
 		{16, "is", ds.None[string](), []TestVar{}},
@@ -118,21 +138,24 @@ func TestFindAtMost2Lower(t *testing.T) {
 func TestSkipTest(t *testing.T) {
 	vore, err := Compile("find skip 1 take 1 'here'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("here >here< here")
+	results, err := vore.Run("here >here< here")
+	testutils.CheckNoError(t, err)
 	singleMatch(t, results, 6, "here")
 }
 
 func TestTopTest(t *testing.T) {
 	vore, err := Compile("find top 1 'here'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(">here< here here")
+	results, err := vore.Run(">here< here here")
+	testutils.CheckNoError(t, err)
 	singleMatch(t, results, 1, "here")
 }
 
 func TestLastTest(t *testing.T) {
 	vore, err := Compile("find last 2 'here'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("here >here< >here<")
+	results, err := vore.Run("here >here< >here<")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{6, "here", ds.None[string](), []TestVar{}},
 		{13, "here", ds.None[string](), []TestVar{}},
@@ -142,28 +165,32 @@ func TestLastTest(t *testing.T) {
 func TestRecursion1(t *testing.T) {
 	vore, err := Compile("find all {'a' maybe mySub 'b'} = mySub")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("aaaabbbb")
+	results, err := vore.Run("aaaabbbb")
+	testutils.CheckNoError(t, err)
 	singleMatch(t, results, 0, "aaaabbbb")
 }
 
 func TestRecursion2(t *testing.T) {
 	vore, err := Compile("find all {'a' maybe mySub 'b'} = mySub")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("aabbb")
+	results, err := vore.Run("aabbb")
+	testutils.CheckNoError(t, err)
 	singleMatch(t, results, 0, "aabb")
 }
 
 func TestRecursion3(t *testing.T) {
 	vore, err := Compile("find all {'a' maybe mySub 'b'} = mySub")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("aaaaab")
+	results, err := vore.Run("aaaaab")
+	testutils.CheckNoError(t, err)
 	singleMatch(t, results, 4, "ab")
 }
 
 func TestOrBranch(t *testing.T) {
 	vore, err := Compile("find all 'this' or 'that'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("this and that")
+	results, err := vore.Run("this and that")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "this", ds.None[string](), []TestVar{}},
 		{9, "that", ds.None[string](), []TestVar{}},
@@ -173,7 +200,8 @@ func TestOrBranch(t *testing.T) {
 func TestInBranch(t *testing.T) {
 	vore, err := Compile("find all in 'a', 'b', 'c'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("abcdefghijklmnopqrstuvwxyz")
+	results, err := vore.Run("abcdefghijklmnopqrstuvwxyz")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "a", ds.None[string](), []TestVar{}},
 		{1, "b", ds.None[string](), []TestVar{}},
@@ -184,7 +212,8 @@ func TestInBranch(t *testing.T) {
 func TestInBranchRange(t *testing.T) {
 	vore, err := Compile("find all in 'a' to 'c', 'x' to 'z'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("abcdefghijklmnopqrstuvwxyz")
+	results, err := vore.Run("abcdefghijklmnopqrstuvwxyz")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "a", ds.None[string](), []TestVar{}},
 		{1, "b", ds.None[string](), []TestVar{}},
@@ -198,7 +227,8 @@ func TestInBranchRange(t *testing.T) {
 func TestVariables(t *testing.T) {
 	vore, err := Compile("find all (at least 1 in 'a' to 'c', 'x' to 'z') = test")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("abcdefghijklmnopqrstuvwxyz")
+	results, err := vore.Run("abcdefghijklmnopqrstuvwxyz")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "abc", ds.None[string](), []TestVar{
 			{"test", "abc"},
@@ -220,9 +250,10 @@ func TestNameIdMatches(t *testing.T) {
 			(at least 1 any fewest) = name
 			line end`)
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`US123456	lilith
+	results, err := vore.Run(`US123456	lilith
 tx555555	martha
 FR420420	celeste`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "US123456\tlilith", ds.None[string](), []TestVar{
 			{"country", "US"},
@@ -242,7 +273,8 @@ FR420420	celeste`)
 func TestVariableMatch(t *testing.T) {
 	vore, err := Compile("find all 'wow' = wow wow")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("wow wowwow")
+	results, err := vore.Run("wow wowwow")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{4, "wowwow", ds.None[string](), []TestVar{
 			{"wow", "wow"},
@@ -253,7 +285,8 @@ func TestVariableMatch(t *testing.T) {
 func TestReplaceStatement(t *testing.T) {
 	vore, err := Compile("replace all 'wow' = wow with '>' wow wow '<'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("wow wowwow")
+	results, err := vore.Run("wow wowwow")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "wow", ds.Some(">wowwow<"), []TestVar{
 			{"wow", "wow"},
@@ -270,7 +303,8 @@ func TestReplaceStatement(t *testing.T) {
 func TestNot(t *testing.T) {
 	vore, err := Compile("find all at least 1 not whitespace")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("this \tfinds all  \nnon-whitespace!")
+	results, err := vore.Run("this \tfinds all  \nnon-whitespace!")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "this", ds.None[string](), []TestVar{}},
 		{6, "finds", ds.None[string](), []TestVar{}},
@@ -282,7 +316,8 @@ func TestNot(t *testing.T) {
 func TestNotInBasic(t *testing.T) {
 	vore, err := Compile("find all not in 'a' to 'c', 'x' to 'z'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("abcdefxyzghi")
+	results, err := vore.Run("abcdefxyzghi")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{3, "d", ds.None[string](), []TestVar{}},
 		{4, "e", ds.None[string](), []TestVar{}},
@@ -296,17 +331,100 @@ func TestNotInBasic(t *testing.T) {
 func TestNotInInLoop(t *testing.T) {
 	vore, err := Compile("find all at least 1 (not in 'a' to 'c', 'x' to 'z')")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("abcdefxyzghi")
+	results, err := vore.Run("abcdefxyzghi")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{3, "def", ds.None[string](), []TestVar{}},
 		{9, "ghi", ds.None[string](), []TestVar{}},
 	})
 }
 
+func TestNotInMixedLengths(t *testing.T) {
+	vore, err := Compile("find all not in 'ab', 'c'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("abc cab")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "a", ds.None[string](), []TestVar{}},
+		{1, "bc", ds.None[string](), []TestVar{}},
+		{3, " c", ds.None[string](), []TestVar{}},
+		{5, "a", ds.None[string](), []TestVar{}},
+		{6, "b", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestNotInShorterItemAtStart(t *testing.T) {
+	vore, err := Compile("find all not in 'ab', 'c'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("cx")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 0, "cx")
+}
+
+func TestNotInWholeTextInList(t *testing.T) {
+	vore, err := Compile("find all not in 'ab', 'c'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("c")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{})
+}
+
+func TestNotInBacktracksToShorterLength(t *testing.T) {
+	vore, err := Compile("find all not in 'ab', 'cd' 'x'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("zx abx")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "zx", ds.None[string](), []TestVar{}},
+		{4, "bx", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestNotInMixedLengthsInLoop(t *testing.T) {
+	vore, err := Compile("find all at least 1 (not in 'ab', 'c') 'c'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("xyc")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 0, "xyc")
+}
+
+func TestNotInUnboundedLoopAtEndOfFile(t *testing.T) {
+	vore, err := Compile("find all at least 1 not in 'ab', 'c'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("xyz")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 0, "xyz")
+}
+
+func TestNotInRangeAndLongerString(t *testing.T) {
+	vore, err := Compile("find all not in 'a' to 'c', 'xyz'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("xyz b dxyz")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "xy", ds.None[string](), []TestVar{}},
+		{2, "z b", ds.None[string](), []TestVar{}},
+		{5, " dx", ds.None[string](), []TestVar{}},
+		{8, "yz", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestNotInUnicodeMixedLengths(t *testing.T) {
+	vore, err := Compile("find all not in 'éé', 'ü' 'x'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ééx üx")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{2, "éx", ds.None[string](), []TestVar{}},
+		{5, " üx", ds.None[string](), []TestVar{}},
+	})
+}
+
 func TestBlockComment(t *testing.T) {
 	vore, err := Compile("--(find all at least))- 1 (not in 'a' to 'c', 'x' to 'z'))--")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("oh wow a test!")
+	results, err := vore.Run("oh wow a test!")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{})
 }
 
@@ -345,7 +463,8 @@ find all
       or (maybe (at least 0 ldd ld) ":" at least 1 (hexPart1 or ("\\" hexPart2)))
   "]")`)
 	testutils.CheckNoError(t, err)
-	results := vore.Run("snemail@gmail.com")
+	results, err := vore.Run("snemail@gmail.com")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "snemail@gmail.com", ds.None[string](), []TestVar{}},
 	})
@@ -354,9 +473,10 @@ find all
 func TestCSV(t *testing.T) {
 	vore, err := CompileFile("../docs/examples/csv.vore")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`a, b, c
+	results, err := vore.Run(`a, b, c
 1, 2, 3
 x, y, z`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "a, b, c\n", ds.None[string](), []TestVar{
 			{"row", "[ValueHashMap]"}, // TODO check the nested structure
@@ -373,12 +493,13 @@ x, y, z`)
 func TestCaseless(t *testing.T) {
 	vore, err := Compile("find all caseless 'test'")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`
+	results, err := vore.Run(`
 		this is a test
 		this is a TEST
 		this is a Test
 		this is a tEsT
 	`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{13, "test", ds.None[string](), []TestVar{}},
 		{30, "TEST", ds.None[string](), []TestVar{}},
@@ -390,7 +511,8 @@ func TestCaseless(t *testing.T) {
 func TestRegexp(t *testing.T) {
 	vore, err := Compile("find all @/a+b*/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("aaabbb ab a")
+	results, err := vore.Run("aaabbb ab a")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "aaabbb", ds.None[string](), []TestVar{}},
 		{7, "ab", ds.None[string](), []TestVar{}},
@@ -401,7 +523,8 @@ func TestRegexp(t *testing.T) {
 func TestRegexp2(t *testing.T) {
 	vore, err := Compile("find all @/a*?b/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("aaabbb ab a")
+	results, err := vore.Run("aaabbb ab a")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "aaab", ds.None[string](), []TestVar{}},
 		{4, "b", ds.None[string](), []TestVar{}},
@@ -413,7 +536,8 @@ func TestRegexp2(t *testing.T) {
 func TestRegexp3(t *testing.T) {
 	vore, err := Compile("find all @/a+?b?/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("aaabbb ab a")
+	results, err := vore.Run("aaabbb ab a")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "a", ds.None[string](), []TestVar{}},
 		{1, "a", ds.None[string](), []TestVar{}},
@@ -426,8 +550,9 @@ func TestRegexp3(t *testing.T) {
 func TestRegexp4(t *testing.T) {
 	vore, err := Compile("find all @/a{4,7}/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`aaaaaaaa
+	results, err := vore.Run(`aaaaaaaa
 	aaa aaaaaa`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "aaaaaaa", ds.None[string](), []TestVar{}},
 		{14, "aaaaaa", ds.None[string](), []TestVar{}},
@@ -437,8 +562,9 @@ func TestRegexp4(t *testing.T) {
 func TestRegexp5(t *testing.T) {
 	vore, err := Compile("find all @/a{4,}/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`aaaaaaaa
+	results, err := vore.Run(`aaaaaaaa
 	aaa aaaaaa`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "aaaaaaaa", ds.None[string](), []TestVar{}},
 		{14, "aaaaaa", ds.None[string](), []TestVar{}},
@@ -448,8 +574,9 @@ func TestRegexp5(t *testing.T) {
 func TestRegexp6(t *testing.T) {
 	vore, err := Compile("find all @/a{4}/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`aaaaaaaa
+	results, err := vore.Run(`aaaaaaaa
 	aaa aaaaaa`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "aaaa", ds.None[string](), []TestVar{}},
 		{4, "aaaa", ds.None[string](), []TestVar{}},
@@ -460,8 +587,9 @@ func TestRegexp6(t *testing.T) {
 func TestRegexp7(t *testing.T) {
 	vore, err := Compile("find all @/a{4,}?/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`aaaaaaaa
+	results, err := vore.Run(`aaaaaaaa
 	aaa aaaaaa`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "aaaa", ds.None[string](), []TestVar{}},
 		{4, "aaaa", ds.None[string](), []TestVar{}},
@@ -472,7 +600,8 @@ func TestRegexp7(t *testing.T) {
 func TestRegexp8(t *testing.T) {
 	vore, err := Compile("find all @/.{3}/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`12312312312`)
+	results, err := vore.Run(`12312312312`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "123", ds.None[string](), []TestVar{}},
 		{3, "123", ds.None[string](), []TestVar{}},
@@ -483,8 +612,9 @@ func TestRegexp8(t *testing.T) {
 func TestRegexp9(t *testing.T) {
 	vore, err := Compile("find all @/[^]*/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`1231231
+	results, err := vore.Run(`1231231
 	2312`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, `1231231
 	2312`, ds.None[string](), []TestVar{}},
@@ -494,7 +624,8 @@ func TestRegexp9(t *testing.T) {
 func TestRegexp10(t *testing.T) {
 	vore, err := Compile("find all @/[abc]*/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`123aabbcc986`)
+	results, err := vore.Run(`123aabbcc986`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{3, `aabbcc`, ds.None[string](), []TestVar{}},
 	})
@@ -503,7 +634,8 @@ func TestRegexp10(t *testing.T) {
 func TestRegexp11(t *testing.T) {
 	vore, err := Compile("find all @/[a-z]{0,2}/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("IT WILL CATCH this AND it WILL GET me")
+	results, err := vore.Run("IT WILL CATCH this AND it WILL GET me")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{14, "th", ds.None[string](), []TestVar{}},
 		{16, "is", ds.None[string](), []TestVar{}},
@@ -515,7 +647,8 @@ func TestRegexp11(t *testing.T) {
 func TestRegexp12(t *testing.T) {
 	vore, err := Compile("find all @/[a-]*/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`123aa--a-ac986`)
+	results, err := vore.Run(`123aa--a-ac986`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{3, `aa--a-a`, ds.None[string](), []TestVar{}},
 	})
@@ -524,7 +657,8 @@ func TestRegexp12(t *testing.T) {
 func TestRegexp13(t *testing.T) {
 	vore, err := Compile("find all @/test/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("this is a test")
+	results, err := vore.Run("this is a test")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{10, "test", ds.None[string](), []TestVar{}},
 	})
@@ -533,7 +667,8 @@ func TestRegexp13(t *testing.T) {
 func TestRegexp14(t *testing.T) {
 	vore, err := Compile("find all @/a|b/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("abc")
+	results, err := vore.Run("abc")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "a", ds.None[string](), []TestVar{}},
 		{1, "b", ds.None[string](), []TestVar{}},
@@ -543,7 +678,8 @@ func TestRegexp14(t *testing.T) {
 func TestRegexp15(t *testing.T) {
 	vore, err := Compile("find all @/^test/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("test a test")
+	results, err := vore.Run("test a test")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "test", ds.None[string](), []TestVar{}},
 	})
@@ -552,7 +688,8 @@ func TestRegexp15(t *testing.T) {
 func TestRegexp16(t *testing.T) {
 	vore, err := Compile("find all @/test$/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("test a test")
+	results, err := vore.Run("test a test")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{7, "test", ds.None[string](), []TestVar{}},
 	})
@@ -561,7 +698,8 @@ func TestRegexp16(t *testing.T) {
 func TestRegexp17(t *testing.T) {
 	vore, err := Compile("find all @/[^abc]*/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("I really hate the abc's")
+	results, err := vore.Run("I really hate the abc's")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "I re", ds.None[string](), []TestVar{}},
 		{5, "lly h", ds.None[string](), []TestVar{}},
@@ -573,14 +711,16 @@ func TestRegexp17(t *testing.T) {
 func TestRegexp18(t *testing.T) {
 	vore, err := Compile("find all @/[]/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("This is not a match")
+	results, err := vore.Run("This is not a match")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{})
 }
 
 func TestNotExpressionDeclaration(t *testing.T) {
 	vore, err := Compile("find all not letter = wow")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("123 &abc")
+	results, err := vore.Run("123 &abc")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "1", ds.None[string](), []TestVar{{"wow", "1"}}},
 		{1, "2", ds.None[string](), []TestVar{{"wow", "2"}}},
@@ -593,7 +733,8 @@ func TestNotExpressionDeclaration(t *testing.T) {
 func TestRegexp19(t *testing.T) {
 	vore, err := Compile("find all @/(?<test>a|b)/ test")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("aabaccabjjbb")
+	results, err := vore.Run("aabaccabjjbb")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "aa", ds.None[string](), []TestVar{{"test", "a"}}},
 		{10, "bb", ds.None[string](), []TestVar{{"test", "b"}}},
@@ -603,7 +744,8 @@ func TestRegexp19(t *testing.T) {
 func TestRegexp20(t *testing.T) {
 	vore, err := Compile("find all ('a' or 'b') = test @/\\k<test>/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("aabaccabjjbb")
+	results, err := vore.Run("aabaccabjjbb")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "aa", ds.None[string](), []TestVar{{"test", "a"}}},
 		{10, "bb", ds.None[string](), []TestVar{{"test", "b"}}},
@@ -613,7 +755,8 @@ func TestRegexp20(t *testing.T) {
 func TestBranchVariable(t *testing.T) {
 	vore, err := Compile("find all ('a' or 'b') = test test")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("aabaccabjjbb")
+	results, err := vore.Run("aabaccabjjbb")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "aa", ds.None[string](), []TestVar{{"test", "a"}}},
 		{10, "bb", ds.None[string](), []TestVar{{"test", "b"}}},
@@ -623,7 +766,8 @@ func TestBranchVariable(t *testing.T) {
 func TestRegexp21(t *testing.T) {
 	vore, err := Compile("find all at least 1 @/\\d/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("1234abc567")
+	results, err := vore.Run("1234abc567")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "1234", ds.None[string](), []TestVar{}},
 		{7, "567", ds.None[string](), []TestVar{}},
@@ -633,7 +777,8 @@ func TestRegexp21(t *testing.T) {
 func TestRegexp22(t *testing.T) {
 	vore, err := Compile("find all at least 1 @/\\D/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run("1234abc567")
+	results, err := vore.Run("1234abc567")
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{4, "abc", ds.None[string](), []TestVar{}},
 	})
@@ -642,8 +787,9 @@ func TestRegexp22(t *testing.T) {
 func TestRegexp23(t *testing.T) {
 	vore, err := Compile("find all at least 1 @/\\s/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`12 34a	bc
+	results, err := vore.Run(`12 34a	bc
 567`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{2, " ", ds.None[string](), []TestVar{}},
 		{6, "\t", ds.None[string](), []TestVar{}},
@@ -654,8 +800,9 @@ func TestRegexp23(t *testing.T) {
 func TestRegexp24(t *testing.T) {
 	vore, err := Compile("find all at least 1 @/\\S/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`12 34a	bc
+	results, err := vore.Run(`12 34a	bc
 567`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "12", ds.None[string](), []TestVar{}},
 		{3, "34a", ds.None[string](), []TestVar{}},
@@ -667,7 +814,8 @@ func TestRegexp24(t *testing.T) {
 func TestRegexp25(t *testing.T) {
 	vore, err := Compile("find all @/\\D{0,2}/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`1234abc567`)
+	results, err := vore.Run(`1234abc567`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{4, "ab", ds.None[string](), []TestVar{}},
 		{6, "c", ds.None[string](), []TestVar{}},
@@ -677,7 +825,8 @@ func TestRegexp25(t *testing.T) {
 func TestRegexp26(t *testing.T) {
 	vore, err := Compile("find all @/\\D{2,}/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`1234abc567`)
+	results, err := vore.Run(`1234abc567`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{4, "abc", ds.None[string](), []TestVar{}},
 	})
@@ -686,14 +835,16 @@ func TestRegexp26(t *testing.T) {
 func TestRegexp27(t *testing.T) {
 	vore, err := Compile("find all @/\\D{0,2}?/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`1234abc567`)
+	results, err := vore.Run(`1234abc567`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{})
 }
 
 func TestRegexp28(t *testing.T) {
 	vore, err := Compile("find all @/\\D{2,}?/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`1234abc567`)
+	results, err := vore.Run(`1234abc567`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{4, "ab", ds.None[string](), []TestVar{}},
 	})
@@ -702,7 +853,8 @@ func TestRegexp28(t *testing.T) {
 func TestRegexp29(t *testing.T) {
 	vore, err := Compile("find all @/(test)\\1/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`testtest`)
+	results, err := vore.Run(`testtest`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "testtest", ds.None[string](), []TestVar{
 			{"_1", "test"},
@@ -713,7 +865,8 @@ func TestRegexp29(t *testing.T) {
 func TestRegexp30(t *testing.T) {
 	vore, err := Compile("find all @/(t)(e)(s)(t)(e)(x)(p)(r)(e)(s)(s)(i)(o)(n)\\14\\13/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`testexpressionno`)
+	results, err := vore.Run(`testexpressionno`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "testexpressionno", ds.None[string](), []TestVar{
 			{"_1", "t"},
@@ -737,10 +890,1871 @@ func TestRegexp30(t *testing.T) {
 func TestRegexp31(t *testing.T) {
 	vore, err := Compile("find all @/(test)\\1test/")
 	testutils.CheckNoError(t, err)
-	results := vore.Run(`testtesttest`)
+	results, err := vore.Run(`testtesttest`)
+	testutils.CheckNoError(t, err)
 	matches(t, results, []TestMatch{
 		{0, "testtesttest", ds.None[string](), []TestVar{
 			{"_1", "test"},
 		}},
 	})
 }
+
+func TestFindAnyUnicode(t *testing.T) {
+	vore, err := Compile("find all 'caf' any")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("un café noir")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 3, "café")
+}
+
+func TestFindRangeUnicode(t *testing.T) {
+	vore, err := Compile("find all at least 1 in 'α' to 'ω'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("abc λογος xyz")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 4, "λογος")
+}
+
+func TestFindNotInUnicode(t *testing.T) {
+	vore, err := Compile("find all at least 1 not in whitespace, ','")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("東京, 大阪")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "東京", ds.None[string](), []TestVar{}},
+		{8, "大阪", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestFindColumnUnicode(t *testing.T) {
+	vore, err := Compile("find all 'ü' at least 1 in 'a' to 'z'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("Zoë\nnaïve über")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 12, "über")
+	testutils.AssertEqual(t, 2, results[0].Line.Start)
+	testutils.AssertEqual(t, 7, results[0].Column.Start)
+	testutils.AssertEqual(t, 11, results[0].Column.End)
+}
+
+func TestFindRegexpUnicode(t *testing.T) {
+	vore, err := Compile("find all @/é+/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ééé e")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 0, "ééé")
+}
+
+func TestFollowedBy(t *testing.T) {
+	vore, err := Compile("find all at least 1 digit followed by 'px'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("10px 20em 30px")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "10", ds.None[string](), []TestVar{}},
+		{10, "30", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestNotFollowedBy(t *testing.T) {
+	vore, err := Compile("find all at least 1 digit not followed by in digit, 'px'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("10px 20em 30px")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 5, "20")
+}
+
+func TestPrecededBy(t *testing.T) {
+	vore, err := Compile("find all preceded by '$' at least 1 digit")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("cost: $25, qty: 3")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 7, "25")
+}
+
+func TestNotPrecededBy(t *testing.T) {
+	vore, err := Compile("find all not preceded by (letter or '-') at least 1 digit")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("a12 -34 56")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{2, "2", ds.None[string](), []TestVar{}},
+		{6, "4", ds.None[string](), []TestVar{}},
+		{8, "56", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestPrecededByLoop(t *testing.T) {
+	vore, err := Compile("find all preceded by ('<' between 1 and 10 letter '>') at least 1 letter")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("<b>bold</b> plain")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 3, "bold")
+}
+
+func TestPrecededByUnboundedIsRejected(t *testing.T) {
+	_, err := Compile("find all preceded by ('<' at least 1 letter '>') at least 1 letter")
+	checkVoreError(t, err, "GenError", "the body of a lookbehind must have a maximum length")
+	_, err = Compile("find all @/(?<=a+)b/")
+	checkVoreError(t, err, "GenError", "the body of a lookbehind must have a maximum length")
+}
+
+func TestPrecededByPattern(t *testing.T) {
+	vore, err := Compile(`
+set tag to pattern '<' between 1 and 10 letter '>'
+find all preceded by tag at least 1 letter`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("<b>bold</b> plain")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 3, "bold")
+}
+
+func TestPrecededByDoesNotWalkBackThroughTheFile(t *testing.T) {
+	// every attempt only looks back as far as the longest the body can be so this stays linear in the input
+	vore, err := Compile("find all preceded by (at most 100 'a' 'c') 'b'")
+	testutils.CheckNoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	results, err := vore.RunContext(ctx, strings.Repeat("cb\n", 4000))
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 4000, len(results))
+}
+
+func TestRegexpLookahead(t *testing.T) {
+	vore, err := Compile("find all @/\\w+(?=!)/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("hey! you")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 0, "hey")
+}
+
+func TestRegexpNegativeLookahead(t *testing.T) {
+	vore, err := Compile("find all @/q(?!u)/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("quit qatar")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 5, "q")
+}
+
+func TestRegexpLookbehind(t *testing.T) {
+	vore, err := Compile("find all @/(?<=#)\\d+/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("12 #34 56")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 4, "34")
+}
+
+func TestRegexpNegativeLookbehind(t *testing.T) {
+	vore, err := Compile("find all @/(?<!-)\\b\\d+/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("-12 34")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 4, "34")
+}
+
+func TestFindWord(t *testing.T) {
+	vore, err := Compile("find all at least 1 word")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("snake_case, x2 + y")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "snake_case", ds.None[string](), []TestVar{}},
+		{12, "x2", ds.None[string](), []TestVar{}},
+		{17, "y", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestFindNotWord(t *testing.T) {
+	vore, err := Compile("find all at least 1 not word")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("a_1 + b")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 3, " + ")
+}
+
+func TestFindWordBoundaryUnderscore(t *testing.T) {
+	vore, err := Compile("find all word start 'id' word end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("user_id id id2")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 8, "id")
+}
+
+func TestRegexpWord(t *testing.T) {
+	vore, err := Compile("find all @/\\w+/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("foo_bar9 baz")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "foo_bar9", ds.None[string](), []TestVar{}},
+		{9, "baz", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestRegexpNotWordInClass(t *testing.T) {
+	vore, err := Compile("find all @/[\\W\\d]+/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ab12-_cd")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 2, "12-")
+}
+
+func TestFindUpperUnicode(t *testing.T) {
+	vore, err := Compile("find all upper at least 1 lower")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("Élodie and Øystein met Ñandú")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "Élodie", ds.None[string](), []TestVar{}},
+		{12, "Øystein", ds.None[string](), []TestVar{}},
+		{25, "Ñandú", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestFindLetterUnicode(t *testing.T) {
+	vore, err := Compile("find all at least 1 letter")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("東京 2024")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 0, "東京")
+}
+
+func TestFindUnicodeCategory(t *testing.T) {
+	vore, err := Compile("find all at least 1 in category 'Lu', category 'Nd'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ref ÄB12c")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 4, "ÄB12")
+}
+
+func TestFindUnicodeScript(t *testing.T) {
+	vore, err := Compile("find all at least 1 script 'Cyrillic'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("hello привет world")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 6, "привет")
+}
+
+func TestFindUnicodePunctuation(t *testing.T) {
+	vore, err := Compile("find all unicode punctuation")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("¿Qué? ok")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "¿", ds.None[string](), []TestVar{}},
+		{6, "?", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestFindNotUnicodeScript(t *testing.T) {
+	vore, err := Compile("find all at least 1 not script 'Latin'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("abcΩΨdef")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 3, "ΩΨ")
+}
+
+func TestUnknownUnicodeCategory(t *testing.T) {
+	_, err := Compile("find all category 'Qq'")
+	checkVoreError(t, err, "ParseError", " Unknown unicode category 'Qq'.")
+}
+
+func TestRegexpUnicodeProperty(t *testing.T) {
+	vore, err := Compile("find all @/\\p{Greek}+\\P{L}/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("abc αβγ!")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 4, "αβγ!")
+}
+
+func TestRegexpUnicodePropertyInClass(t *testing.T) {
+	vore, err := Compile("find all @/[\\p{Lu}\\d]+/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("id: ÉA7x")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 4, "ÉA7")
+}
+
+func TestStepLimit(t *testing.T) {
+	vore, err := Compile("find all at least 1 (at least 1 'a') 'b'")
+	testutils.CheckNoError(t, err)
+	vore.SetLimits(Limits{MaxSteps: 10000})
+	results, err := vore.Run("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	testutils.AssertEqual(t, 0, len(results))
+	var limitErr *engine.LimitError
+	testutils.AssertTrue(t, errors.As(err, &limitErr))
+	testutils.AssertEqual(t, engine.StepLimit, limitErr.Kind())
+	testutils.AssertEqual(t, 10000, limitErr.Limit())
+	testutils.AssertEqual(t, "text", limitErr.Filename())
+	testutils.AssertEqual(t, 0, limitErr.Offset())
+	testutils.AssertTrue(t, limitErr.Instruction() != nil)
+	checkVoreError(t, err, "LimitError", "Exceeded the step limit of 10000 at query line 1 column 38 in 'text' while matching from line 1 column 1 (offset 0).")
+}
+
+func TestLimitErrorSpan(t *testing.T) {
+	vore, err := Compile("find all\n    at least 1 (at least 1 'a')\n    'b'")
+	testutils.CheckNoError(t, err)
+	vore.SetLimits(Limits{MaxBacktrackDepth: 16})
+	_, err = vore.Run("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	var limitErr *engine.LimitError
+	testutils.AssertTrue(t, errors.As(err, &limitErr))
+	testutils.AssertEqual(t, 2, limitErr.Span().Line.Start)
+	testutils.AssertEqual(t, 17, limitErr.Span().Column.Start)
+	checkVoreError(t, err, "LimitError", "Exceeded the backtrack depth limit of 16 at query line 2 column 17 in 'text' while matching from line 1 column 1 (offset 0).")
+}
+
+func TestFileStepLimit(t *testing.T) {
+	vore, err := Compile("find all 'b'")
+	testutils.CheckNoError(t, err)
+	vore.SetLimits(Limits{MaxFileSteps: 10})
+	results, err := vore.Run("bbbbbbbbbbbbbbbbbbbb")
+	testutils.AssertEqual(t, 10, len(results))
+	testutils.AssertTrue(t, ToLimitError(err).HasValue())
+	var limitErr *engine.LimitError
+	testutils.AssertTrue(t, errors.As(err, &limitErr))
+	testutils.AssertEqual(t, engine.FileStepLimit, limitErr.Kind())
+	testutils.AssertEqual(t, 10, limitErr.Offset())
+}
+
+func TestBacktrackDepthLimit(t *testing.T) {
+	vore, err := Compile("find all at least 1 (at least 1 'a') 'b'")
+	testutils.CheckNoError(t, err)
+	vore.SetLimits(Limits{MaxBacktrackDepth: 16})
+	_, err = vore.Run("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	var limitErr *engine.LimitError
+	testutils.AssertTrue(t, errors.As(err, &limitErr))
+	testutils.AssertEqual(t, engine.BacktrackDepthLimit, limitErr.Kind())
+}
+
+func TestMemoryLimit(t *testing.T) {
+	vore, err := Compile("find all at least 1 (at least 1 'a') 'b'")
+	testutils.CheckNoError(t, err)
+	vore.SetLimits(Limits{MaxMemory: 1 << 12})
+	_, err = vore.Run("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	var limitErr *engine.LimitError
+	testutils.AssertTrue(t, errors.As(err, &limitErr))
+	testutils.AssertEqual(t, engine.MemoryLimit, limitErr.Kind())
+}
+
+func TestLimitsNotReached(t *testing.T) {
+	vore, err := Compile("find all at least 1 (at least 1 'a') 'b'")
+	testutils.CheckNoError(t, err)
+	vore.SetLimits(Limits{MaxSteps: 10000, MaxFileSteps: 100000, MaxBacktrackDepth: 100, MaxMemory: 1 << 20})
+	results, err := vore.Run("aaab ab b aab")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "aaab", ds.None[string](), []TestVar{}},
+		{5, "ab", ds.None[string](), []TestVar{}},
+		{10, "aab", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestBacktrackingForgetsVariables(t *testing.T) {
+	vore, err := Compile("find all ('a' = x 'b') or ('a' = y 'c')")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ac")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "ac", ds.None[string](), []TestVar{
+			{"y", "a"},
+		}},
+	})
+	_, found := results[0].Variables.Get("x")
+	testutils.AssertFalse(t, found)
+}
+
+func TestNamedLoopVariables(t *testing.T) {
+	vore, err := Compile("find all at most 3 ((in 'a', 'b') = x) named run")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("aab")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "aab", ds.None[string](), []TestVar{
+			{"run", `{"0":{"x":"a"},"1":{"x":"a"},"2":{"x":"b"},"3":{}}`},
+		}},
+	})
+}
+
+func TestRunReader(t *testing.T) {
+	vore, err := Compile("find all 'b' at least 1 letter")
+	testutils.CheckNoError(t, err)
+	results, err := vore.RunReader(iotest.OneByteReader(strings.NewReader("abc\nbad bob")), "stdin")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{1, "bc", ds.None[string](), []TestVar{}},
+		{4, "bad", ds.None[string](), []TestVar{}},
+		{8, "bob", ds.None[string](), []TestVar{}},
+	})
+	testutils.AssertEqual(t, "stdin", results[2].Filename)
+	testutils.AssertEqual(t, 2, results[2].Line.Start)
+	testutils.AssertEqual(t, 5, results[2].Column.Start)
+}
+
+func TestRunReaderBacktracking(t *testing.T) {
+	vore, err := Compile("find all (at least 1 letter) = w line end")
+	testutils.CheckNoError(t, err)
+	text := strings.Repeat("some words on a line\n", 1000)
+	results, err := vore.RunReader(strings.NewReader(text), "stdin")
+	testutils.CheckNoError(t, err)
+	expected, err := vore.Run(text)
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, len(expected), len(results))
+	for i := range expected {
+		testutils.AssertEqual(t, expected[i].Offset, results[i].Offset)
+		testutils.AssertEqual(t, expected[i].Value, results[i].Value)
+	}
+}
+
+func TestRunReaderLookbehind(t *testing.T) {
+	vore, err := Compile("find all preceded by (line end whitespace between 0 and 2 letter) letter")
+	testutils.CheckNoError(t, err)
+	text := strings.Repeat("some words\non a line\n", 1000)
+	results, err := vore.RunReader(strings.NewReader(text), "stdin")
+	testutils.CheckNoError(t, err)
+	expected, err := vore.Run(text)
+	testutils.CheckNoError(t, err)
+	testutils.AssertTrue(t, len(expected) > 1000)
+	testutils.AssertEqual(t, len(expected), len(results))
+	for i := range expected {
+		testutils.AssertEqual(t, expected[i].Offset, results[i].Offset)
+		testutils.AssertEqual(t, expected[i].Line, results[i].Line)
+		testutils.AssertEqual(t, expected[i].Column, results[i].Column)
+	}
+}
+
+func TestRunReaderMultipleCommands(t *testing.T) {
+	vore, err := Compile("find all 'a' replace all 'b' with 'c'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.RunReader(strings.NewReader("ab"), "stdin")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "a", ds.None[string](), []TestVar{}},
+		{1, "b", ds.Some("c"), []TestVar{}},
+	})
+}
+
+func TestRunReaderError(t *testing.T) {
+	vore, err := Compile("find all 'o'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.RunReader(iotest.TimeoutReader(strings.NewReader("foo")), "stdin")
+	testutils.AssertEqual(t, iotest.ErrTimeout, err)
+	testutils.AssertEqual(t, 2, len(results))
+}
+
+// countdownContext reports that it was cancelled once Err has been called enough times
+type countdownContext struct {
+	context.Context
+	remaining int
+}
+
+func (c *countdownContext) Err() error {
+	if c.remaining == 0 {
+		return context.Canceled
+	}
+	c.remaining -= 1
+	return nil
+}
+
+func TestRunContextCancelled(t *testing.T) {
+	vore, err := Compile("find all 'a'")
+	testutils.CheckNoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := vore.RunContext(ctx, "aaaa")
+	testutils.AssertTrue(t, errors.Is(err, context.Canceled))
+	testutils.AssertEqual(t, 0, len(results))
+}
+
+func TestRunContextPartialResults(t *testing.T) {
+	vore, err := Compile("find all 'a'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.RunContext(&countdownContext{context.Background(), 3}, "aaaa")
+	testutils.AssertTrue(t, errors.Is(err, context.Canceled))
+	matches(t, results, []TestMatch{
+		{0, "a", ds.None[string](), []TestVar{}},
+		{1, "a", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestRunContextDeadline(t *testing.T) {
+	vore, err := Compile("find all (at least 1 (at least 1 'a')) = as 'b'")
+	testutils.CheckNoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = vore.RunContext(ctx, strings.Repeat("a", 40))
+	testutils.AssertTrue(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestRunFilesContextCancelled(t *testing.T) {
+	vore, err := Compile("find all 'a'")
+	testutils.CheckNoError(t, err)
+	filename := testutils.GetTestingFilename(t, true)
+	defer testutils.RemoveTestingFile(t, filename)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := vore.RunFilesContext(ctx, []string{filename}, engine.NOTHING, false)
+	testutils.AssertTrue(t, errors.Is(err, context.Canceled))
+	testutils.AssertEqual(t, 0, len(results))
+}
+
+func TestRunFilesJobsKeepOrder(t *testing.T) {
+	paths := []string{}
+	for i := 0; i < 24; i++ {
+		paths = append(paths, fmt.Sprintf("file%02d.txt", i))
+	}
+	directory := testutils.BuildTestingFilesystem(t, paths...)
+	defer testutils.RemoveTestingFilesystem(t, directory)
+	for i, path := range paths {
+		err := os.WriteFile(directory+"/"+path, []byte(strings.Repeat("ab ", i+1)), 0666)
+		testutils.CheckNoError(t, err)
+	}
+
+	vore, err := Compile("find all 'ab' find all 'b'")
+	testutils.CheckNoError(t, err)
+	expected, err := vore.RunFiles([]string{directory}, engine.NOTHING, false)
+	testutils.CheckNoError(t, err)
+	vore.SetJobs(8)
+	actual, err := vore.RunFiles([]string{directory}, engine.NOTHING, false)
+	testutils.CheckNoError(t, err)
+
+	testutils.AssertEqual(t, 2*300, len(expected))
+	testutils.AssertEqual(t, len(expected), len(actual))
+	for i := range expected {
+		testutils.AssertEqual(t, expected[i].Filename, actual[i].Filename)
+		testutils.AssertEqual(t, expected[i].MatchNumber, actual[i].MatchNumber)
+		testutils.AssertEqual(t, expected[i].Offset, actual[i].Offset)
+		testutils.AssertEqual(t, expected[i].Value, actual[i].Value)
+	}
+}
+
+func TestRunFilesJobsSameFileOverwrite(t *testing.T) {
+	filename := testutils.GetTestingFilename(t, true)
+	defer testutils.RemoveTestingFile(t, filename)
+	err := os.WriteFile(filename, []byte("x"), 0666)
+	testutils.CheckNoError(t, err)
+
+	vore, err := Compile("replace all 'x' with 'xx'")
+	testutils.CheckNoError(t, err)
+	vore.SetJobs(4)
+	results, err := vore.RunFiles([]string{filename, filename, filename}, engine.OVERWRITE, false)
+	testutils.CheckNoError(t, err)
+
+	// each search has to see what the one before it wrote
+	testutils.AssertEqual(t, 1+2+4, len(results))
+	testutils.AssertEqual(t, 1, results[0].MatchNumber)
+	testutils.AssertEqual(t, 2, results[2].MatchNumber)
+	testutils.AssertEqual(t, 4, results[6].MatchNumber)
+	contents, err := os.ReadFile(filename)
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, "xxxxxxxx", string(contents))
+}
+
+func TestRunFilesJobsCollectErrors(t *testing.T) {
+	directory := testutils.BuildTestingFilesystem(t, "a.txt", "b.txt", "c.txt")
+	defer testutils.RemoveTestingFilesystem(t, directory)
+	for _, path := range []string{"a.txt", "b.txt", "c.txt"} {
+		err := os.WriteFile(directory+"/"+path, []byte("aaaa"), 0666)
+		testutils.CheckNoError(t, err)
+	}
+	err := os.WriteFile(directory+"/b.txt", []byte(strings.Repeat("a", 100)), 0666)
+	testutils.CheckNoError(t, err)
+
+	vore, err := Compile("find all 'a' = x")
+	testutils.CheckNoError(t, err)
+	vore.SetLimits(Limits{MaxFileSteps: 50})
+	vore.SetJobs(3)
+	results, err := vore.RunFiles([]string{directory}, engine.NOTHING, false)
+	var limitErr *engine.LimitError
+	testutils.AssertTrue(t, errors.As(err, &limitErr))
+	testutils.AssertEqual(t, directory+"/b.txt", limitErr.Filename())
+	testutils.AssertEqual(t, 1, len(ToFileErrors(err).GetValue()))
+	// the files around the one that failed are still searched
+	testutils.AssertEqual(t, 4+16+4, len(results))
+	testutils.AssertEqual(t, directory+"/a.txt", results[0].Filename)
+	testutils.AssertEqual(t, directory+"/c.txt", results[len(results)-1].Filename)
+}
+
+func TestRunFilesMissingFile(t *testing.T) {
+	filename := testutils.GetTestingFilename(t, true)
+	defer testutils.RemoveTestingFile(t, filename)
+	err := os.WriteFile(filename, []byte("aa"), 0666)
+	testutils.CheckNoError(t, err)
+	missing := filename + ".missing"
+
+	vore, err := Compile("find all 'a'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.RunFiles([]string{missing, filename}, engine.NOTHING, false)
+	testutils.AssertEqual(t, 2, len(results))
+	testutils.AssertTrue(t, errors.Is(err, fs.ErrNotExist))
+	fileErr := ToFileError(err)
+	testutils.AssertTrue(t, fileErr.HasValue())
+	testutils.AssertEqual(t, missing, fileErr.GetValue().Filename())
+}
+
+func TestRunFilesMissingFileReportedOnce(t *testing.T) {
+	filename := testutils.GetTestingFilename(t, true)
+	defer testutils.RemoveTestingFile(t, filename)
+	err := os.WriteFile(filename, []byte("ab"), 0666)
+	testutils.CheckNoError(t, err)
+	missing := filename + ".missing"
+
+	vore, err := Compile("find all 'a' find all 'b'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.RunFiles([]string{missing, filename}, engine.NOTHING, false)
+	testutils.AssertEqual(t, 2, len(results))
+	testutils.AssertEqual(t, 1, len(ToFileErrors(err).GetValue()))
+}
+
+func TestRunFilesReplaceExecError(t *testing.T) {
+	filename := testutils.GetTestingFilename(t, true)
+	defer testutils.RemoveTestingFile(t, filename)
+	err := os.WriteFile(filename, []byte("ab"), 0666)
+	testutils.CheckNoError(t, err)
+
+	vore, err := Compile(`
+set half to transform
+	return matchLength / 0
+end
+replace all 'b' with half`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.RunFiles([]string{filename}, engine.OVERWRITE, false)
+	testutils.AssertEqual(t, 0, len(results))
+	var execErr *engine.ExecError
+	testutils.AssertTrue(t, errors.As(err, &execErr))
+	testutils.AssertEqual(t, "Divided by zero", execErr.Message())
+	testutils.AssertEqual(t, filename, execErr.Filename())
+	// nothing is written when a replacement fails
+	contents, err := os.ReadFile(filename)
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, "ab", string(contents))
+}
+
+func TestPredicateExecError(t *testing.T) {
+	vore, err := Compile(`
+set p to pattern at least 1 digit begin
+	return matchLength % 0 == 0
+end
+find all p`)
+	testutils.CheckNoError(t, err)
+	_, err = vore.Run("12")
+	checkVoreError(t, err, "ExecError", "Took the modulo by zero")
+	testutils.AssertTrue(t, ToExecError(err).HasValue())
+}
+
+func TestMatchesJson(t *testing.T) {
+	vore, err := Compile("find all 'a' = x")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("aa")
+	testutils.CheckNoError(t, err)
+	json, err := results.Json()
+	testutils.CheckNoError(t, err)
+	testutils.AssertTrue(t, strings.HasPrefix(json, "[{"))
+	testutils.AssertTrue(t, strings.Contains(json, `"variables":{"x":"a"}`))
+	_, err = results.FormattedJson()
+	testutils.CheckNoError(t, err)
+}
+
+func TestFindOverlapping(t *testing.T) {
+	vore, err := Compile("find all overlapping 'aa'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("aaaa")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "aa", ds.None[string](), []TestVar{}},
+		{1, "aa", ds.None[string](), []TestVar{}},
+		{2, "aa", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestFindOverlappingNGrams(t *testing.T) {
+	vore, err := Compile("find all overlapping (exactly 3 letter) = gram")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("abcd é\nxyz")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "abc", ds.None[string](), []TestVar{{"gram", "abc"}}},
+		{1, "bcd", ds.None[string](), []TestVar{{"gram", "bcd"}}},
+		{8, "xyz", ds.None[string](), []TestVar{{"gram", "xyz"}}},
+	})
+	testutils.AssertEqual(t, 2, results[2].Line.Start)
+	testutils.AssertEqual(t, 1, results[2].Column.Start)
+	testutils.AssertEqual(t, 3, results[2].MatchNumber)
+}
+
+func TestFindOverlappingSkipTake(t *testing.T) {
+	vore, err := Compile("find skip 1 take 2 overlapping 'aa'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("aaaaa")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{1, "aa", ds.None[string](), []TestVar{}},
+		{2, "aa", ds.None[string](), []TestVar{}},
+	})
+	testutils.AssertEqual(t, 2, results[0].MatchNumber)
+	testutils.AssertEqual(t, 3, results[1].MatchNumber)
+}
+
+func TestFindOverlappingLast(t *testing.T) {
+	vore, err := Compile("find last 2 overlapping 'a' = x at least 1 'a'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("aaaa")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{1, "aaa", ds.None[string](), []TestVar{{"x", "a"}}},
+		{2, "aa", ds.None[string](), []TestVar{{"x", "a"}}},
+	})
+	testutils.AssertEqual(t, 2, results[0].MatchNumber)
+	testutils.AssertEqual(t, 3, results[1].MatchNumber)
+}
+
+func TestFindWithoutOverlapping(t *testing.T) {
+	vore, err := Compile("find all 'aa'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("aaaa")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "aa", ds.None[string](), []TestVar{}},
+		{2, "aa", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestReplaceOverlappingError(t *testing.T) {
+	_, err := Compile("replace all overlapping 'aa' with 'b'")
+	checkVoreError(t, err, "ParseError", " Replace statements can't use 'overlapping' since overlapping matches can't all be replaced.")
+}
+
+func TestCaptureSpans(t *testing.T) {
+	vore, err := Compile("find all (at least 1 letter) = key ':' at least 0 whitespace (at least 1 digit) = value")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("a: 1\nbé:\n22")
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 2, len(results))
+
+	key := results[0].Captures["key"]
+	testutils.AssertEqual(t, ds.Range{Start: 0, End: 1}, key.Offset)
+	testutils.AssertEqual(t, ds.Range{Start: 1, End: 1}, key.Line)
+	testutils.AssertEqual(t, ds.Range{Start: 1, End: 2}, key.Column)
+	value := results[0].Captures["value"]
+	testutils.AssertEqual(t, ds.Range{Start: 3, End: 4}, value.Offset)
+	testutils.AssertEqual(t, ds.Range{Start: 4, End: 5}, value.Column)
+
+	key = results[1].Captures["key"]
+	testutils.AssertEqual(t, ds.Range{Start: 5, End: 8}, key.Offset)
+	testutils.AssertEqual(t, ds.Range{Start: 2, End: 2}, key.Line)
+	testutils.AssertEqual(t, ds.Range{Start: 1, End: 3}, key.Column)
+	value = results[1].Captures["value"]
+	testutils.AssertEqual(t, ds.Range{Start: 10, End: 12}, value.Offset)
+	testutils.AssertEqual(t, ds.Range{Start: 3, End: 3}, value.Line)
+	testutils.AssertEqual(t, ds.Range{Start: 1, End: 3}, value.Column)
+	testutils.AssertTrue(t, value.Iterations == nil)
+}
+
+func TestCaptureSpansAfterBacktracking(t *testing.T) {
+	vore, err := Compile("find all ((at least 1 'a') = x 'b') or ('a' = y 'c')")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("xac")
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 1, len(results))
+	_, found := results[0].Captures["x"]
+	testutils.AssertFalse(t, found)
+	testutils.AssertEqual(t, ds.Range{Start: 1, End: 2}, results[0].Captures["y"].Offset)
+}
+
+func TestCaptureSpansInNamedLoop(t *testing.T) {
+	vore, err := Compile("find all 'start' at least 1 (' ' (at least 1 letter) = w) named words")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("start ab cde")
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 1, len(results))
+
+	words := results[0].Captures["words"]
+	testutils.AssertEqual(t, ds.Range{Start: 5, End: 12}, words.Offset)
+	// like the variables the iteration that failed to match is still there but it is empty
+	testutils.AssertEqual(t, 3, len(words.Iterations))
+	testutils.AssertEqual(t, ds.Range{Start: 6, End: 8}, words.Iterations[0]["w"].Offset)
+	testutils.AssertEqual(t, ds.Range{Start: 9, End: 12}, words.Iterations[1]["w"].Offset)
+	testutils.AssertEqual(t, ds.Range{Start: 10, End: 13}, words.Iterations[1]["w"].Column)
+	testutils.AssertEqual(t, 0, len(words.Iterations[2]))
+}
+
+func TestCaptureSpansJson(t *testing.T) {
+	vore, err := Compile("find all 'a' ('b') = x")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("zab")
+	testutils.CheckNoError(t, err)
+	json, err := results[0].Json()
+	testutils.CheckNoError(t, err)
+	testutils.AssertTrue(t, strings.Contains(json, `"captures":{"x":{"column":{"end":4,"start":3},"line":{"end":1,"start":1},"offset":{"end":3,"start":2}}}`))
+}
+
+func TestParseTree(t *testing.T) {
+	vore, err := Compile(`
+set sum to pattern term maybe ('+' sum)
+set term to pattern (at least 1 digit) or ('(' sum ')')
+find all tree sum`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("1+(2+3) 45")
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 2, len(results))
+	testutils.AssertEqual(t, "sum(term'1' sum(term(sum(term'2' sum(term'3')))))", treeString(results[0].Tree))
+	testutils.AssertEqual(t, "sum(term'45')", treeString(results[1].Tree))
+
+	inner := results[0].Tree[0].Children[1].Children[0]
+	testutils.AssertEqual(t, "(2+3)", inner.Value)
+	testutils.AssertEqual(t, ds.Range{Start: 2, End: 7}, inner.Offset)
+	testutils.AssertEqual(t, ds.Range{Start: 1, End: 1}, inner.Line)
+	testutils.AssertEqual(t, ds.Range{Start: 3, End: 8}, inner.Column)
+}
+
+func TestParseTreeAfterBacktracking(t *testing.T) {
+	vore, err := Compile(`
+set item to pattern at least 1 letter
+set pair to pattern item '=' item
+find all tree (pair ';') or (item ';')`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ab=c; ab;")
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 2, len(results))
+	testutils.AssertEqual(t, "pair(item'ab' item'c')", treeString(results[0].Tree))
+	testutils.AssertEqual(t, "item'ab'", treeString(results[1].Tree))
+}
+
+func TestParseTreeOfMemoizedCalls(t *testing.T) {
+	// the last branch calls rest from the same offset as the one before it so it gets the memoized ends
+	vore, err := Compile(`
+set rest to pattern at least 1 (letter or digit)
+set statement to pattern ('a' rest ';') or ('a' rest '.') or ('a' rest '!')
+find all tree statement`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ab1! a2;")
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 2, len(results))
+	testutils.AssertEqual(t, "statement(rest'b1')", treeString(results[0].Tree))
+	testutils.AssertEqual(t, "statement(rest'2')", treeString(results[1].Tree))
+}
+
+func TestParseTreeLeavesOutLookaround(t *testing.T) {
+	vore, err := Compile(`
+set name to pattern at least 1 letter
+find all tree name followed by (' ' name)`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("one two")
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 1, len(results))
+	testutils.AssertEqual(t, "name'one'", treeString(results[0].Tree))
+}
+
+func TestParseTreeInlineSubroutine(t *testing.T) {
+	vore, err := Compile("find all tree {'(' maybe parens ')'} = parens")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("(())")
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 1, len(results))
+	testutils.AssertEqual(t, "parens(parens'()')", treeString(results[0].Tree))
+}
+
+func TestParseTreeNotRequested(t *testing.T) {
+	vore, err := Compile(`
+set name to pattern at least 1 letter
+find all name`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("one")
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 1, len(results))
+	testutils.AssertTrue(t, results[0].Tree == nil)
+	json, err := results[0].Json()
+	testutils.CheckNoError(t, err)
+	testutils.AssertFalse(t, strings.Contains(json, `"tree"`))
+}
+
+func TestParseTreeJson(t *testing.T) {
+	vore, err := Compile(`
+set name to pattern at least 1 letter
+find all tree name`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("hi")
+	testutils.CheckNoError(t, err)
+	json, err := results[0].Json()
+	testutils.CheckNoError(t, err)
+	testutils.AssertTrue(t, strings.Contains(json, `"tree":[{"children":[],"column":{"end":3,"start":1},"line":{"end":1,"start":1},"name":"name","offset":{"end":2,"start":0},"value":"hi"}]`))
+}
+
+func TestReplaceTreeError(t *testing.T) {
+	_, err := Compile("replace all tree 'aa' with 'b'")
+	checkVoreError(t, err, "ParseError", " Replace statements can't use 'tree' since the replacement is built from the variables.")
+}
+
+func TestConditionalQuotes(t *testing.T) {
+	vore, err := Compile("find all maybe ('\"' = open) (at least 1 letter) = w if open then '\"' end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("\"abc\" def \"ghi x")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "\"abc\"", ds.None[string](), []TestVar{{"open", "\""}, {"w", "abc"}}},
+		{6, "def", ds.None[string](), []TestVar{{"w", "def"}}},
+		{11, "ghi", ds.None[string](), []TestVar{{"w", "ghi"}}},
+		{15, "x", ds.None[string](), []TestVar{{"w", "x"}}},
+	})
+}
+
+func TestConditionalElse(t *testing.T) {
+	vore, err := Compile("find all maybe ('<' = angle) 'x' if angle then '>' else '!' end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("<x> x! <x! x>")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "<x>", ds.None[string](), []TestVar{}},
+		{4, "x!", ds.None[string](), []TestVar{}},
+		{8, "x!", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestConditionalNested(t *testing.T) {
+	vore, err := Compile("find all maybe ('a' = a) maybe ('b' = b) if a then if b then 'ab' else 'a' end else 'x' end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("abab aa x bx")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "abab", ds.None[string](), []TestVar{}},
+		{5, "aa", ds.None[string](), []TestVar{}},
+		{8, "x", ds.None[string](), []TestVar{}},
+		{10, "bx", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestConditionalInNamedLoop(t *testing.T) {
+	vore, err := Compile("find all at least 1 (maybe ('+' = plus) digit if plus then '!' end) named n")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("+1!2")
+	testutils.CheckNoError(t, err)
+	singleMatch(t, results, 0, "+1!2")
+}
+
+func TestConditionalMissingThen(t *testing.T) {
+	_, err := Compile("find all if x 'a' end")
+	checkVoreError(t, err, "ParseError", " Unexpected token. Expected 'then'.")
+}
+
+func TestConditionalMissingEnd(t *testing.T) {
+	_, err := Compile("find all if x then 'a'")
+	checkVoreError(t, err, "ParseError", " Unexpected token. Expected 'else' or 'end'.")
+}
+
+func TestRegexpConditional(t *testing.T) {
+	for _, source := range []string{
+		"find all @/(<)?x(?(1)>|!)/",
+		"find all @/(?<angle><)?x(?(angle)>|!)/",
+		"find all @/(?<angle><)?x(?(<angle>)>|!)/",
+		"find all @/(?<angle><)?x(?('angle')>|!)/",
+	} {
+		vore, err := Compile(source)
+		testutils.CheckNoError(t, err)
+		results, err := vore.Run("<x> x! <x! x>")
+		testutils.CheckNoError(t, err)
+		matches(t, results, []TestMatch{
+			{0, "<x>", ds.None[string](), []TestVar{}},
+			{4, "x!", ds.None[string](), []TestVar{}},
+			{8, "x!", ds.None[string](), []TestVar{}},
+		})
+	}
+}
+
+func TestRegexpConditionalWithoutElse(t *testing.T) {
+	vore, err := Compile("find all @/(q)?\\w+(?(1)q)/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("qabq cd")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "qabq", ds.None[string](), []TestVar{}},
+		{5, "cd", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestRegexpConditionalTooManyAlternatives(t *testing.T) {
+	_, err := Compile("find all @/(a)?(?(1)b|c|d)/")
+	checkVoreError(t, err, "ParseError", " Conditional groups can only have two alternatives")
+}
+
+func TestRecursivePattern(t *testing.T) {
+	vore, err := Compile(`
+set balanced to pattern '(' at least 0 (balanced or (not in '(', ')')) ')'
+find all balanced`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("x (a(b)c) (() ((x)")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{2, "(a(b)c)", ds.None[string](), []TestVar{}},
+		{11, "()", ds.None[string](), []TestVar{}},
+		{15, "(x)", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestMutuallyRecursivePatterns(t *testing.T) {
+	vore, err := Compile(`
+set array to pattern '[' maybe (item at least 0 (',' item)) ']'
+set item to pattern digit or array
+find all array`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("[1,[2,[]],3] [,] [[4]")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "[1,[2,[]],3]", ds.None[string](), []TestVar{}},
+		{18, "[4]", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestMemoizedPatterns(t *testing.T) {
+	// every split of the a's into 'a' and 'aa' is a different way through t so without memoization this takes exponential time
+	vore, err := Compile(`
+set t to pattern ('a' t) or ('aa' t) or 'a'
+find all t 'b'`)
+	testutils.CheckNoError(t, err)
+	vore.SetLimits(Limits{MaxSteps: 100000})
+	results, err := vore.Run(strings.Repeat("a", 40) + "c aaab")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{42, "aaab", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestMemoizedMutuallyRecursivePatterns(t *testing.T) {
+	vore, err := Compile(`
+set sum to pattern term maybe ('+' sum)
+set term to pattern (at least 1 digit) or ('(' sum ')')
+find all sum`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("1+(2+3)+45 (6+7")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "1+(2+3)+45", ds.None[string](), []TestVar{}},
+		{12, "6+7", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestPatternWithBackreferenceIsNotMemoized(t *testing.T) {
+	vore, err := Compile(`
+set quoted to pattern (in "'", '"') = quote at least 0 any fewest quote
+find all quoted`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run(`"a'b" 'c"d'`)
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, `"a'b"`, ds.None[string](), []TestVar{}},
+		{6, `'c"d'`, ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestPatternCallingLaterPattern(t *testing.T) {
+	vore, err := Compile(`
+set a to pattern 'x' maybe b
+set b to pattern 'y' a
+find all a`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("xyxyx xy")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "xyxyx", ds.None[string](), []TestVar{}},
+		{6, "x", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestPatternUsedBeforeLaterPatternIsSet(t *testing.T) {
+	_, err := Compile(`
+set a to pattern 'x' maybe b
+find all a
+set b to pattern 'y' a`)
+	testutils.AssertTrue(t, ToGenError(err).HasValue())
+	testutils.AssertTrue(t, strings.Contains(err.Error(), "pattern 'b' is used before it is set"))
+}
+
+func TestCompileErrorsAs(t *testing.T) {
+	_, err := Compile("find all\n  'a' = ")
+	var parseErr *ParseError
+	testutils.AssertTrue(t, errors.As(err, &parseErr))
+	var compileErr CompileError
+	testutils.AssertTrue(t, errors.As(err, &compileErr))
+	testutils.AssertEqual(t, "ParseError", compileErr.Code())
+	testutils.AssertEqual(t, parseErr.Message(), compileErr.Message())
+	testutils.AssertEqual(t, 2, compileErr.Span().Line.Start)
+
+	_, err = Compile(`
+set a to pattern 'x' maybe b
+find all a
+set b to pattern 'y' a`)
+	compileErr = ToCompileError(err).GetValue()
+	testutils.AssertEqual(t, "GenError", compileErr.Code())
+	testutils.AssertEqual(t, "pattern 'b' is used before it is set", compileErr.Message())
+	testutils.AssertEqual(t, 3, compileErr.Span().Line.Start)
+
+	// errors that happen while searching aren't compile errors
+	vore, err := Compile("set p to pattern 'a' begin return matchLength % 0 == 0 end find all p")
+	testutils.CheckNoError(t, err)
+	_, err = vore.Run("a")
+	testutils.AssertFalse(t, ToCompileError(err).HasValue())
+}
+
+func TestRecursivePatternVariablesPerCall(t *testing.T) {
+	vore, err := Compile(`
+set element to pattern '<' (at least 1 letter) = tag '>' at least 0 (element or (not in '<')) '</' tag '>'
+find all element`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("<a><b>x</b><i></i></a> <a><b></a></b>")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "<a><b>x</b><i></i></a>", ds.None[string](), []TestVar{{"tag", "a"}}},
+	})
+}
+
+func TestRecursiveSubroutineVariablesPerCall(t *testing.T) {
+	vore, err := Compile("find all {(letter) = open maybe nested open} = nested")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("abba xyzzy")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "abba", ds.None[string](), []TestVar{{"open", "a"}}},
+		{6, "yzzy", ds.None[string](), []TestVar{{"open", "y"}}},
+	})
+}
+
+func TestPatternCallsExportVariables(t *testing.T) {
+	// every use of a pattern gives its variables to the caller so the last one wins
+	vore, err := Compile(`
+set x to pattern (letter) = inner
+find all x '-' x`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("a-b")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "a-b", ds.None[string](), []TestVar{{"inner", "b"}}},
+	})
+}
+
+func TestPatternCallsDontReplaceCallerVariables(t *testing.T) {
+	vore, err := Compile(`
+set x to pattern (letter) = inner
+find all (digit) = inner '-' x '-' inner`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("1-a-1 2-b-b")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "1-a-1", ds.None[string](), []TestVar{{"inner", "1"}}},
+	})
+}
+
+func TestLeftRecursivePattern(t *testing.T) {
+	vore, err := Compile(`
+set sum to pattern (sum '+' digit) or digit
+find all sum`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("1+2")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "1", ds.None[string](), []TestVar{}},
+		{2, "2", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	vore, err := Compile(`
+set nested to pattern '(' maybe nested ')'
+find all nested`)
+	testutils.CheckNoError(t, err)
+	vore.SetLimits(Limits{MaxCallDepth: 3})
+	results, err := vore.Run("(()) ((()))")
+	var limitErr *engine.LimitError
+	testutils.AssertTrue(t, errors.As(err, &limitErr))
+	testutils.AssertEqual(t, engine.CallDepthLimit, limitErr.Kind())
+	testutils.AssertEqual(t, 5, limitErr.Offset())
+	matches(t, results, []TestMatch{
+		{0, "(())", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestDefaultCallDepthLimit(t *testing.T) {
+	vore, err := Compile(`
+set nested to pattern '(' maybe nested ')'
+find all nested`)
+	testutils.CheckNoError(t, err)
+	_, err = vore.Run(strings.Repeat("(", DefaultMaxCallDepth+10))
+	var limitErr *engine.LimitError
+	testutils.AssertTrue(t, errors.As(err, &limitErr))
+	testutils.AssertEqual(t, engine.CallDepthLimit, limitErr.Kind())
+	testutils.AssertEqual(t, DefaultMaxCallDepth, limitErr.Limit())
+}
+
+func TestPatternUsedByTwoCommands(t *testing.T) {
+	vore, err := Compile(`
+set ab to pattern 'a' or 'b'
+find all 'x' ab
+find all ab 'y'`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("xa by")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "xa", ds.None[string](), []TestVar{}},
+		{3, "by", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestCaselessGroup(t *testing.T) {
+	vore, err := Compile("find all caseless ('hello' or 'world')")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("HeLLo WORLD hello")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "HeLLo", ds.None[string](), []TestVar{}},
+		{6, "WORLD", ds.None[string](), []TestVar{}},
+		{12, "hello", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestCaselessGroupRange(t *testing.T) {
+	vore, err := Compile("find all caseless (at least 1 in 'a' to 'f', '0' to '9')")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("DEADbeef 42 xyz")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "DEADbeef", ds.None[string](), []TestVar{}},
+		{9, "42", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestCaselessGroupNotIn(t *testing.T) {
+	vore, err := Compile("find all caseless (at least 1 not in 'a' to 'c', ' ')")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("aBxY Cz")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{2, "xY", ds.None[string](), []TestVar{}},
+		{6, "z", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestCaselessBackreference(t *testing.T) {
+	vore, err := Compile("find all (at least 1 letter) = w ' ' caseless (w)")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("Hello HELLO hello world")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "Hello HELLO", ds.None[string](), []TestVar{{"w", "Hello"}}},
+	})
+}
+
+func TestCaselessBackreferenceOutsideGroup(t *testing.T) {
+	vore, err := Compile("find all (at least 1 letter) = w ' ' w")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("Hello HELLO hello hello")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{12, "hello hello", ds.None[string](), []TestVar{{"w", "hello"}}},
+	})
+}
+
+func TestCaselessGroupPatternCall(t *testing.T) {
+	vore, err := Compile("set p to pattern 'a' find all caseless (p) find all p")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("aA")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "a", ds.None[string](), []TestVar{}},
+		{1, "A", ds.None[string](), []TestVar{}},
+		{0, "a", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestCaselessGroupPatternCallBothWays(t *testing.T) {
+	vore, err := Compile("set p to pattern 'a' in 'b' to 'c' find all p ' ' caseless (p) ' ' p")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ab AB ab ab ab Ab")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "ab AB ab", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestCaselessGroupLaterPatternCall(t *testing.T) {
+	vore, err := Compile("set p to pattern 'x' q set q to pattern 'a' find all caseless (p)")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("XA xa Xb")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "XA", ds.None[string](), []TestVar{}},
+		{3, "xa", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestCaselessUnicodeSimpleFolding(t *testing.T) {
+	vore, err := Compile("find all caseless ('straße' or ('σ' in 'à' to 'ä'))")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("STRAẞE Σâ ςÀ σx")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "STRAẞE", ds.None[string](), []TestVar{}},
+		{9, "Σâ", ds.None[string](), []TestVar{}},
+		{14, "ςÀ", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestCaselessProgram(t *testing.T) {
+	vore, err := Compile(`
+caseless
+set ab to pattern 'ab'
+find all ab in 'x' to 'z'`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ABY abz aBw")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "ABY", ds.None[string](), []TestVar{}},
+		{4, "abz", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestCaselessProgramAfterCommand(t *testing.T) {
+	_, err := Compile(`
+set check to function
+	return match
+end
+caseless
+find all 'b'`)
+	checkVoreError(t, err, "ParseError", " A program can only be made caseless before its first command.")
+}
+
+func TestRegexpCaselessGroup(t *testing.T) {
+	vore, err := Compile("find all @/(?i:ab[x-z])c/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ABXc abzc abxC")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "ABXc", ds.None[string](), []TestVar{}},
+		{5, "abzc", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestFuzzy(t *testing.T) {
+	vore, err := Compile("find all word start within 1 edits of 'colour' word end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("color colour clour colr")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "color", ds.None[string](), []TestVar{}},
+		{6, "colour", ds.None[string](), []TestVar{}},
+		{13, "clour", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestFuzzyDistance(t *testing.T) {
+	vore, err := Compile("find all word start within 2 edits of 'colour' named distance word end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("colour colr colours c")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "colour", ds.None[string](), []TestVar{{"distance", "0"}}},
+		{7, "colr", ds.None[string](), []TestVar{{"distance", "2"}}},
+		{12, "colours", ds.None[string](), []TestVar{{"distance", "1"}}},
+	})
+}
+
+func TestFuzzyPrefersClosestThenLongest(t *testing.T) {
+	vore, err := Compile("find all within 1 edits of 'abc' named distance")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("abcd")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "abc", ds.None[string](), []TestVar{{"distance", "0"}}},
+	})
+}
+
+func TestFuzzyPrefersExactOverLeadingInsertion(t *testing.T) {
+	vore, err := Compile("find all within 1 edits of 'colour' named distance")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("color colour")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "color", ds.None[string](), []TestVar{{"distance", "1"}}},
+		{6, "colour", ds.None[string](), []TestVar{{"distance", "0"}}},
+	})
+}
+
+func TestFuzzyLeadingInsertion(t *testing.T) {
+	vore, err := Compile("find all within 2 edits of 'cat' named distance")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("xxcat")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{2, "cat", ds.None[string](), []TestVar{{"distance", "0"}}},
+	})
+}
+
+func TestFuzzyLeadingInsertionAfterOtherText(t *testing.T) {
+	vore, err := Compile("find all 'z' within 1 edits of 'cat' named distance")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("zxcat")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "zxcat", ds.None[string](), []TestVar{{"distance", "1"}}},
+	})
+}
+
+func TestFuzzyTrailingInsertion(t *testing.T) {
+	vore, err := Compile("find all within 1 edits of 'cat' named distance")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("catx cot")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "cat", ds.None[string](), []TestVar{{"distance", "0"}}},
+		{5, "cot", ds.None[string](), []TestVar{{"distance", "1"}}},
+	})
+}
+
+func TestFuzzyBacktracksToOtherLengths(t *testing.T) {
+	vore, err := Compile("find all within 1 edits of 'abc' 'cd'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("abcd")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "abcd", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestFuzzySwaps(t *testing.T) {
+	vore, err := Compile("find all word start within 1 edits or swaps of 'colour' named distance word end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("coluor ocluor")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "coluor", ds.None[string](), []TestVar{{"distance", "1"}}},
+	})
+}
+
+func TestFuzzyWithoutSwaps(t *testing.T) {
+	vore, err := Compile("find all word start within 1 edits of 'colour' word end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("coluor")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{})
+}
+
+func TestFuzzyCaseless(t *testing.T) {
+	vore, err := Compile("find all word start within 1 edits of caseless 'colour' word end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("COLOR Colour")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "COLOR", ds.None[string](), []TestVar{}},
+		{6, "Colour", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestFuzzyVariable(t *testing.T) {
+	vore, err := Compile("find all (at least 1 letter) = w ' ' within 1 edits of w")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("hello helo world word")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "hello helo", ds.None[string](), []TestVar{{"w", "hello"}}},
+		{11, "world word", ds.None[string](), []TestVar{{"w", "world"}}},
+	})
+}
+
+func TestFuzzySubroutine(t *testing.T) {
+	_, err := Compile("find all {'a'} = s within 1 edits of s")
+	testutils.AssertTrue(t, ToGenError(err).HasValue())
+}
+
+func TestFuzzyMissingEdits(t *testing.T) {
+	_, err := Compile("find all within 1 of 'colour'")
+	checkVoreError(t, err, "ParseError", " Unexpected token. Expected 'edits'.")
+}
+
+func TestNumberRange(t *testing.T) {
+	vore, err := Compile("find all word start number between 0 and 255 word end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("0 7 42 199 255 256 300 007 1000")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "0", ds.None[string](), []TestVar{}},
+		{2, "7", ds.None[string](), []TestVar{}},
+		{4, "42", ds.None[string](), []TestVar{}},
+		{7, "199", ds.None[string](), []TestVar{}},
+		{11, "255", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestNumberRangeDoesNotSplitDigits(t *testing.T) {
+	vore, err := Compile("find all number between 0 and 255")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("256 1000 2556 x255y")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{15, "255", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestNumberRangeDoesNotLeaveDigitsForTheRest(t *testing.T) {
+	vore, err := Compile("find all number between 0 and 255 '5'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("2555 25 5")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{})
+}
+
+func TestNumberRangeBacktracksToWholePart(t *testing.T) {
+	vore, err := Compile("find all number between 0 and 10 decimal '.5'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("7.5")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "7.5", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestNumberRangeLeadingZeros(t *testing.T) {
+	vore, err := Compile("find all word start number between 0 and 255 padded word end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("007 0255 0256")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "007", ds.None[string](), []TestVar{}},
+		{4, "0255", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestNumberRangeNegative(t *testing.T) {
+	vore, err := Compile("find all number between -40 and 40 word end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("-40 -41 +5 -0 12")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "-40", ds.None[string](), []TestVar{}},
+		{9, "5", ds.None[string](), []TestVar{}},
+		{12, "0", ds.None[string](), []TestVar{}},
+		{14, "12", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestNumberRangeSigned(t *testing.T) {
+	vore, err := Compile("find all number between -40 and 40 signed word end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("+5 -0 -41")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "+5", ds.None[string](), []TestVar{}},
+		{3, "-0", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestNumberRangeDecimal(t *testing.T) {
+	vore, err := Compile("find all word start number between 0 and 100 decimal word end")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("99.5 100.0 100.5 0.25 7.")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "99.5", ds.None[string](), []TestVar{}},
+		{5, "100.0", ds.None[string](), []TestVar{}},
+		{11, "100", ds.None[string](), []TestVar{}},
+		{15, "5", ds.None[string](), []TestVar{}},
+		{17, "0.25", ds.None[string](), []TestVar{}},
+		{22, "7", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestNumberRangeInLoopAndVariable(t *testing.T) {
+	vore, err := Compile("find all exactly 3 (number between 0 and 255 '.') number between 0 and 255 = final")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("192.168.0.1 256.1.1.1")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "192.168.0.1", ds.None[string](), []TestVar{{"final", "1"}}},
+	})
+}
+
+func TestNumberRangeBoundsOutOfOrder(t *testing.T) {
+	_, err := Compile("find all number between 10 and 1")
+	checkVoreError(t, err, "ParseError", " The largest number can't be smaller than the smallest number.")
+}
+
+func TestTermList(t *testing.T) {
+	vore, err := Compile(`find all in list "cat", "category", "dog"`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("category cat dogs")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "category", ds.None[string](), []TestVar{}},
+		{9, "cat", ds.None[string](), []TestVar{}},
+		{13, "dog", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestTermListBacktracksToShorterTerm(t *testing.T) {
+	vore, err := Compile(`find all in list "cat", "category" 'egory!'`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("category!")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "category!", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestTermListCaseless(t *testing.T) {
+	vore, err := Compile(`find all in caseless list "cat", "dog"`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("Cat DOG bird")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "Cat", ds.None[string](), []TestVar{}},
+		{4, "DOG", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestTermListCaselessProgram(t *testing.T) {
+	vore, err := Compile(`caseless find all in list "cat"`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("CAT")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "CAT", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestTermListWholeWord(t *testing.T) {
+	vore, err := Compile(`find all in whole word list "cat", "dog"`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("cat concat cats dog_ dog.")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "cat", ds.None[string](), []TestVar{}},
+		{21, "dog", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestTermListWholeWordSkipsLongerTerm(t *testing.T) {
+	vore, err := Compile(`find all in whole word list "new", "new york"`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("new yorker")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "new", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestTermListInLookbehind(t *testing.T) {
+	vore, err := Compile(`find all preceded by in list "a", "bc" 'x'`)
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ax bcx cx")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{1, "x", ds.None[string](), []TestVar{}},
+		{5, "x", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestTermListFromFile(t *testing.T) {
+	filename := t.TempDir() + "/terms.txt"
+	err := os.WriteFile(filename, []byte("apple\r\nbanana\n\nCherry\n"), 0666)
+	testutils.CheckNoError(t, err)
+
+	vore, err := Compile(fmt.Sprintf(`find all in caseless whole word list from file "%s"`, filename))
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("Apple pie, banana split, cherry tart, pineapple")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "Apple", ds.None[string](), []TestVar{}},
+		{11, "banana", ds.None[string](), []TestVar{}},
+		{25, "cherry", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestTermListFileRelativeToSource(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(dir+"/terms.txt", []byte("cat\ndog\n"), 0666)
+	testutils.CheckNoError(t, err)
+	err = os.WriteFile(dir+"/pets.vore", []byte(`find all in whole word list from file "terms.txt"`), 0666)
+	testutils.CheckNoError(t, err)
+
+	wd, err := os.Getwd()
+	testutils.CheckNoError(t, err)
+	defer os.Chdir(wd)
+	err = os.Chdir(t.TempDir())
+	testutils.CheckNoError(t, err)
+
+	vore, err := CompileFile(dir + "/pets.vore")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("cat catalog dog")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "cat", ds.None[string](), []TestVar{}},
+		{12, "dog", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestTermListMissingFile(t *testing.T) {
+	_, err := Compile(fmt.Sprintf(`find all in list from file "%s"`, t.TempDir()+"/missing.txt"))
+	testutils.AssertTrue(t, ToGenError(err).HasValue())
+}
+
+func TestTermListEmptyFile(t *testing.T) {
+	filename := t.TempDir() + "/terms.txt"
+	err := os.WriteFile(filename, []byte("\n\n"), 0666)
+	testutils.CheckNoError(t, err)
+
+	_, err = Compile(fmt.Sprintf(`find all in list from file "%s"`, filename))
+	testutils.AssertTrue(t, ToGenError(err).HasValue())
+}
+
+func TestTermListBadOption(t *testing.T) {
+	_, err := Compile(`find all in whole list "a"`)
+	checkVoreError(t, err, "ParseError", " Unexpected token. Expected 'word'.")
+}
+
+func TestAtomicGroup(t *testing.T) {
+	vore, err := Compile("find all atomic ('a' or 'ab') 'c'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("abc ac")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{4, "ac", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestAtomicGroupKeepsCheckpointsBeforeIt(t *testing.T) {
+	vore, err := Compile("find all ('x' or 'xy') atomic ('z' or 'zw') 'w'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("xyzw xyzww")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "xyzw", ds.None[string](), []TestVar{}},
+		{5, "xyzw", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestAtomicGroupVariable(t *testing.T) {
+	vore, err := Compile("find all atomic (at least 1 letter) = w digit")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ab1 cd")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "ab1", ds.None[string](), []TestVar{{"w", "ab"}}},
+	})
+}
+
+func TestAtomicWithoutParenIsAVariable(t *testing.T) {
+	_, err := Compile("find all atomic 'a'")
+	checkVoreError(t, err, "GenError", "undefined identifier")
+}
+
+func TestContextualKeywordsAsVariables(t *testing.T) {
+	words := []string{
+		"overlapping", "tree", "possessive", "atomic", "within", "edit", "edits", "swap", "swaps", "of",
+		"number", "padded", "signed", "decimal", "list", "from", "followed", "preceded", "by",
+		"unicode", "category", "script",
+	}
+	for _, word := range words {
+		vore, err := Compile(fmt.Sprintf("find all (at least 1 digit) = %s ' ' %s", word, word))
+		testutils.CheckNoError(t, err)
+		results, err := vore.Run("12 12 3 4")
+		testutils.CheckNoError(t, err)
+		matches(t, results, []TestMatch{
+			{0, "12 12", ds.None[string](), []TestVar{{word, "12"}}},
+		})
+	}
+}
+
+func TestContextualKeywordAsPatternName(t *testing.T) {
+	vore, err := Compile("set list to pattern 'a' or 'b' set number to pattern digit find all list number")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("a1 b2 c3")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "a1", ds.None[string](), []TestVar{}},
+		{3, "b2", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestPossessiveLoop(t *testing.T) {
+	vore, err := Compile("find all at least 1 'a' possessive 'a'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("aaa")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{})
+}
+
+func TestPossessiveBetween(t *testing.T) {
+	vore, err := Compile("find all between 1 and 3 digit possessive '5'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("1235 125")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "1235", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestPossessiveMaybe(t *testing.T) {
+	vore, err := Compile("find all maybe 'a' possessive 'a'")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("a aa")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{2, "aa", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestPossessiveNamedLoop(t *testing.T) {
+	vore, err := Compile("find all at least 1 (letter = c) possessive named chars digit")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("ab1")
+	testutils.CheckNoError(t, err)
+	testutils.AssertEqual(t, 1, len(results))
+	testutils.AssertEqual(t, "ab1", results[0].Value)
+}
+
+func TestRegexpAtomicGroup(t *testing.T) {
+	vore, err := Compile("find all @/(?>(?:a)|(?:ab))c/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("abc ac")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{4, "ac", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestRegexpPossessiveQuantifiers(t *testing.T) {
+	vore, err := Compile("find all @/a++a/")
+	testutils.CheckNoError(t, err)
+	results, err := vore.Run("aaa")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{})
+
+	vore, err = Compile("find all @/b*+c/")
+	testutils.CheckNoError(t, err)
+	results, err = vore.Run("bbc")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{0, "bbc", ds.None[string](), []TestVar{}},
+	})
+
+	vore, err = Compile("find all @/x?+x/")
+	testutils.CheckNoError(t, err)
+	results, err = vore.Run("x xx")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{
+		{2, "xx", ds.None[string](), []TestVar{}},
+	})
+}
+
+func TestPossessiveAvoidsCatastrophicBacktracking(t *testing.T) {
+	vore, err := Compile("find all at least 1 (at least 1 'a' possessive) possessive 'b'")
+	testutils.CheckNoError(t, err)
+	vore.SetLimits(Limits{MaxSteps: 10000})
+	results, err := vore.Run(strings.Repeat("a", 30) + "c")
+	testutils.CheckNoError(t, err)
+	matches(t, results, []TestMatch{})
+}
+
+func TestFileStepLimitWithoutCaptures(t *testing.T) {
+	vore, err := Compile("find all at least 1 'a'")
+	testutils.CheckNoError(t, err)
+	vore.SetLimits(Limits{MaxSteps: 10, MaxFileSteps: 10})
+	results, err := vore.Run(strings.Repeat("ab", 1000))
+	testutils.AssertTrue(t, len(results) < 1000)
+	testutils.AssertTrue(t, ToLimitError(err).HasValue())
+}
@@ -1,7 +1,17 @@
+let searchController = null;
+
+const stopVoreSearch = () => {
+  if (searchController) {
+    searchController.abort();
+  }
+}
+
 const doVoreSearch = () => {
+  stopVoreSearch();
+  searchController = new AbortController();
   const sourceCode = document.getElementById("sourceCode").value;
   const searchText = document.getElementById("searchText").value;
-  libvorejs.search(sourceCode, searchText)
+  libvorejs.search(sourceCode, searchText, searchController.signal)
     .then(value => {
       console.log(value);
       document.getElementById("results").innerHTML = JSON.stringify(value, null, 4);
@@ -1,2 +1,2 @@
 export as namespace libvorejs;
-export function search(source: string, text: string): Promise<any>;
\ No newline at end of file
+export function search(source: string, text: string, signal?: AbortSignal): Promise<any>;
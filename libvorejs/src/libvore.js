@@ -1,5 +1,7 @@
 import wasm from 'libvorejs';
 
-export function search(source, text) {
-  return wasm.voreSearch(source, text);
+// signal is an optional AbortSignal that stops the search and rejects with an AbortError.
+// A search that stops early rejects with its error along with the matches it found before stopping.
+export function search(source, text, signal) {
+  return wasm.voreSearch(source, text, signal);
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"syscall/js"
+	"time"
 
 	"github.com/jmeaster30/vore/libvore"
+	"github.com/jmeaster30/vore/libvore/ast"
 	"github.com/jmeaster30/vore/libvore/ds"
 )
 
@@ -24,77 +28,80 @@ func buildRange(value ds.Range) map[string]any {
 	}
 }
 
-func buildLexError(err libvore.LexError) map[string]any {
+func buildSpan(span libvore.Span) map[string]any {
 	return map[string]any{
-		"error": map[string]any{
-			"type":    "LexError",
-			"message": err.Message(),
-			"token": map[string]any{
-				"lexeme": err.Token().Lexeme,
-				"type":   err.Token().TokenType.PP(),
-				"offset": buildRange(err.Token().Offset),
-				"line":   buildRange(err.Token().Line),
-				"column": buildRange(err.Token().Column),
-			},
-		},
+		"offset": buildRange(span.Offset),
+		"line":   buildRange(span.Line),
+		"column": buildRange(span.Column),
 	}
 }
 
-func buildParseError(err libvore.ParseError) map[string]any {
+func buildToken(token *ast.Token) map[string]any {
 	return map[string]any{
-		"error": map[string]any{
-			"type":    "ParseError",
-			"message": err.Message(),
-			"token": map[string]any{
-				"lexeme": err.Token().Lexeme,
-				"type":   err.Token().TokenType.PP(),
-				"offset": buildRange(err.Token().Offset),
-				"line":   buildRange(err.Token().Line),
-				"column": buildRange(err.Token().Column),
-			},
-		},
+		"lexeme": token.Lexeme,
+		"type":   token.TokenType.PP(),
+		"offset": buildRange(*token.Offset),
+		"line":   buildRange(*token.Line),
+		"column": buildRange(*token.Column),
 	}
 }
 
-func buildGenError(err libvore.GenError) map[string]any {
+func buildCompileError(err libvore.CompileError) map[string]any {
+	result := map[string]any{
+		"type":    err.Code(),
+		"message": err.Message(),
+		"span":    buildSpan(err.Span()),
+	}
+	var lexErr *libvore.LexError
+	var parseErr *libvore.ParseError
+	if errors.As(err, &lexErr) {
+		result["token"] = buildToken(lexErr.Token())
+	} else if errors.As(err, &parseErr) {
+		result["token"] = buildToken(parseErr.Token())
+	}
 	return map[string]any{
-		"error": map[string]any{
-			"type":    "GenError",
-			"message": err.Message(),
-		},
+		"error": result,
 	}
 }
 
-func buildSemanticError(err libvore.SemanticError) map[string]any {
+func buildExecError(err *libvore.ExecError) map[string]any {
 	return map[string]any{
 		"error": map[string]any{
-			"type":    "SemanticError",
+			"type":    "ExecError",
 			"message": err.Message(),
 		},
 	}
 }
 
-func buildExecError(err libvore.ExecError) map[string]any {
+func buildLimitError(err *libvore.LimitError) map[string]any {
 	return map[string]any{
 		"error": map[string]any{
-			"type":    "ExecError",
+			"type":    "LimitError",
 			"message": err.Message(),
+			"span":    buildSpan(err.Span()),
 		},
 	}
 }
 
 func buildError(err error) map[string]any {
-	switch e := err.(type) {
-	case *libvore.LexError:
-		return buildLexError(e)
-	case *libvore.ParseError:
-		return buildParseError(e)
-	case *libvore.GenError:
-		return buildGenError(e)
-	case *libvore.SemanticError:
-		return buildSemanticError(e)
-	case *libvore.ExecError:
-		return buildExecError(e)
+	if errors.Is(err, context.Canceled) {
+		return map[string]any{
+			"error": map[string]any{
+				"type":    "AbortError",
+				"message": "The search was aborted.",
+			},
+		}
+	}
+
+	var compileErr libvore.CompileError
+	var execErr *libvore.ExecError
+	var limitErr *libvore.LimitError
+	if errors.As(err, &compileErr) {
+		return buildCompileError(compileErr)
+	} else if errors.As(err, &execErr) {
+		return buildExecError(execErr)
+	} else if errors.As(err, &limitErr) {
+		return buildLimitError(limitErr)
 	}
 
 	return map[string]any{
@@ -104,6 +111,45 @@ func buildError(err error) map[string]any {
 	}
 }
 
+func buildCapture(capture libvore.Capture) map[string]any {
+	result := map[string]any{
+		"offset": buildRange(capture.Offset),
+		"line":   buildRange(capture.Line),
+		"column": buildRange(capture.Column),
+	}
+	if capture.Iterations != nil {
+		iterations := []any{}
+		for _, iteration := range capture.Iterations {
+			iterations = append(iterations, buildCaptures(iteration))
+		}
+		result["iterations"] = iterations
+	}
+	return result
+}
+
+func buildCaptures(captures map[string]libvore.Capture) map[string]any {
+	result := map[string]any{}
+	for name, capture := range captures {
+		result[name] = buildCapture(capture)
+	}
+	return result
+}
+
+func buildParseNodes(nodes []libvore.ParseNode) []any {
+	result := []any{}
+	for _, node := range nodes {
+		result = append(result, map[string]any{
+			"name":     node.Name,
+			"offset":   buildRange(node.Offset),
+			"line":     buildRange(node.Line),
+			"column":   buildRange(node.Column),
+			"value":    node.Value,
+			"children": buildParseNodes(node.Children),
+		})
+	}
+	return result
+}
+
 func buildMatch(match libvore.Match) map[string]interface{} {
 	result := map[string]interface{}{
 		"filename":    match.Filename,
@@ -120,11 +166,15 @@ func buildMatch(match libvore.Match) map[string]interface{} {
 			"start": match.Column.Start,
 			"end":   match.Column.End,
 		},
-		"value": match.Value,
+		"value":    match.Value,
+		"captures": buildCaptures(match.Captures),
 	}
 	if match.Replacement.HasValue() {
 		result["replacement"] = match.Replacement.GetValue()
 	}
+	if match.Tree != nil {
+		result["tree"] = buildParseNodes(match.Tree)
+	}
 	return result
 }
 
@@ -154,25 +204,84 @@ func buildMatches(input string, matches libvore.Matches) map[string]interface{}
 	}
 }
 
+// yieldingContext sleeps for a moment every so often when the search checks it. Go runs on the browser's
+// only thread so without this the abort event of an AbortSignal would not fire until the search was done.
+type yieldingContext struct {
+	context.Context
+	lastYield time.Time
+}
+
+const yieldInterval = 50 * time.Millisecond
+
+func (c *yieldingContext) Err() error {
+	if time.Since(c.lastYield) > yieldInterval {
+		time.Sleep(time.Millisecond)
+		c.lastYield = time.Now()
+	}
+	return c.Context.Err()
+}
+
+// contextFromSignal makes a context that is cancelled when the AbortSignal fires. The signal can be undefined
+// and then the search runs without stopping to yield since nothing can abort it.
+func contextFromSignal(signal js.Value) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if signal.IsUndefined() || signal.IsNull() {
+		return ctx, cancel
+	}
+	if signal.Get("aborted").Truthy() {
+		cancel()
+	}
+	onAbort := js.FuncOf(func(this js.Value, args []js.Value) any {
+		cancel()
+		return nil
+	})
+	signal.Call("addEventListener", "abort", onAbort)
+	return &yieldingContext{ctx, time.Now()}, func() {
+		signal.Call("removeEventListener", "abort", onAbort)
+		onAbort.Release()
+		cancel()
+	}
+}
+
 func voreSearch(this js.Value, args []js.Value) any {
 	source := args[0].String()
 	input := args[1].String()
-	resolve := args[2]
-	reject := args[3]
-	defer func() {
-		if r := recover(); r == nil {
-			reject.Invoke(js.ValueOf(map[string]interface{}{
-				"error": "Aw man :( ... Go paniced",
-			}))
+	signal := js.Undefined()
+	if len(args) > 4 {
+		signal = args[2]
+	}
+	resolve := args[len(args)-2]
+	reject := args[len(args)-1]
+
+	// the search runs on its own goroutine so the browser can deliver the abort event while it is going
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reject.Invoke(js.ValueOf(map[string]interface{}{
+					"error": "Aw man :( ... Go paniced",
+				}))
+			}
+		}()
+
+		ctx, done := contextFromSignal(signal)
+		defer done()
+
+		vore, err := libvore.Compile(source)
+		if err != nil {
+			reject.Invoke(js.ValueOf(buildError(err)))
+			return
+		}
+		matches, err := vore.RunContext(ctx, input)
+		if err != nil {
+			// the matches found before the search stopped go along with the error
+			result := buildMatches(input, matches)
+			for key, value := range buildError(err) {
+				result[key] = value
+			}
+			reject.Invoke(js.ValueOf(result))
+			return
 		}
+		resolve.Invoke(js.ValueOf(buildMatches(input, matches)))
 	}()
-
-	vore, err := libvore.Compile(source)
-	if err != nil {
-		reject.Invoke(js.ValueOf(buildError(err)))
-		return nil
-	}
-	matches := vore.Run(input)
-	resolve.Invoke(js.ValueOf(buildMatches(input, matches)))
 	return nil
 }
@@ -35,7 +35,7 @@ func main() {
 	source_arg := flag.String("src", "", "Vore source file to run on search files")
 	command_arg := flag.String("com", "", "Vore command to run on search files")
 	debug_arg := flag.Bool("debug", false, "Prints the AST of the supplied command or source file")
-	search_files_glob_arg := flag.String("files", "", "Files to search")
+	search_files_glob_arg := flag.String("files", "", "Files to search (use - to search STDIN)")
 	filenames_arg := flag.Bool("filenames", false, "Process filenames instead of file contents")
 	out_json_arg := flag.Bool("json", false, "Output JSON to STDOUT")
 	out_fjson_arg := flag.Bool("formatted-json", false, "Output formatted JSON to STDOUT")
@@ -43,6 +43,12 @@ func main() {
 	fjson_file_arg := flag.String("formatted-json-file", "", "Formatted JSON output file")
 	no_output_arg := flag.Bool("no-output", false, "Do not output any results")
 	profile_arg := flag.String("profile", "", "CPU Profile")
+	max_steps_arg := flag.Int("max-steps", 0, "Maximum instructions run by each match attempt (0 for no limit)")
+	max_file_steps_arg := flag.Int("max-file-steps", 0, "Maximum instructions run by each command on a file (0 for no limit)")
+	max_backtrack_depth_arg := flag.Int("max-backtrack-depth", 0, "Maximum depth of the backtrack stack (0 for no limit)")
+	max_memory_arg := flag.Int("max-memory", 0, "Maximum approximate bytes held by the backtrack stack (0 for no limit)")
+	max_call_depth_arg := flag.Int("max-call-depth", engine.DefaultMaxCallDepth, "Maximum subroutine calls running inside of each other (0 for no limit)")
+	jobs_arg := flag.Int("jobs", 1, "Number of files to search at the same time (0 for one per CPU)")
 	flag.Func("replace-mode", "File mode for replace statements [NEW, NOTHING, OVERWRITE] (default: NEW)", replaceMode)
 	flag.Parse()
 
@@ -57,6 +63,13 @@ func main() {
 	profile_file := *profile_arg
 	command := *command_arg
 	debug := *debug_arg
+	limits := libvore.Limits{
+		MaxSteps:          *max_steps_arg,
+		MaxFileSteps:      *max_file_steps_arg,
+		MaxBacktrackDepth: *max_backtrack_depth_arg,
+		MaxMemory:         *max_memory_arg,
+		MaxCallDepth:      *max_call_depth_arg,
+	}
 
 	if debug {
 		fmt.Printf("source: '%s'\n", source)
@@ -133,6 +146,9 @@ func main() {
 		log.Fatal(compError)
 	}
 
+	vore.SetLimits(limits)
+	vore.SetJobs(*jobs_arg)
+
 	if debug {
 		println("-- AST -----------")
 		vore.PrintAST()
@@ -140,18 +156,33 @@ func main() {
 		vore.PrintBytecode()
 	}
 
-	currentDir, err := os.Getwd()
-	if err != nil {
-		log.Fatal(err)
-	}
+	var results engine.Matches
+	var runError error
+	if search_files_glob == "-" {
+		results, runError = vore.RunReader(os.Stdin, "stdin")
+	} else {
+		currentDir, err := os.Getwd()
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	search_files := files.ParsePath(search_files_glob).GetFileList(currentDir)
-	if len(search_files) == 0 {
-		fmt.Println("No files to search :(")
-		return
-	}
+		search_files := files.ParsePath(search_files_glob).GetFileList(currentDir)
+		if len(search_files) == 0 {
+			fmt.Println("No files to search :(")
+			return
+		}
 
-	results := vore.RunFiles(search_files, replaceModeArg, process_filenames)
+		results, runError = vore.RunFiles(search_files, replaceModeArg, process_filenames)
+	}
+	// files that couldn't be searched are reported and the matches of the rest are still shown
+	var fileErrors engine.FileErrors
+	if errors.As(runError, &fileErrors) {
+		for _, err := range fileErrors {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	} else if runError != nil {
+		log.Fatal(runError)
+	}
 
 	if no_output { // skip all output
 		return
@@ -166,23 +197,30 @@ func main() {
 		if len(json_file) != 0 {
 			f := OpenFile(json_file)
 			Truncate(f)
-			f.WriteString(results.Json())
+			f.WriteString(MustJson(results.Json()))
 		}
 		if len(fjson_file) != 0 {
 			f := OpenFile(fjson_file)
 			Truncate(f)
-			f.WriteString(results.FormattedJson())
+			f.WriteString(MustJson(results.FormattedJson()))
 		}
 		if out_json {
-			fmt.Println(results.Json())
+			fmt.Println(MustJson(results.Json()))
 		} else if out_fjson {
-			fmt.Println(results.FormattedJson())
+			fmt.Println(MustJson(results.FormattedJson()))
 		} else {
 			results.Print()
 		}
 	}
 }
 
+func MustJson(data string, err error) string {
+	if err != nil {
+		log.Fatal(err)
+	}
+	return data
+}
+
 func OpenFile(filename string) *os.File {
 	f, err := os.OpenFile(filename, os.O_CREATE, os.ModeAppend)
 	if err != nil {